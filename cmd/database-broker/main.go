@@ -1,11 +1,15 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 
 	"github.com/razzie-cloud/database-broker/internal/adapter/dragonfly"
+	"github.com/razzie-cloud/database-broker/internal/adapter/mongodb"
+	"github.com/razzie-cloud/database-broker/internal/adapter/mysql"
 	"github.com/razzie-cloud/database-broker/internal/adapter/postgres"
 	"github.com/razzie-cloud/database-broker/internal/broker"
 	"github.com/razzie-cloud/database-broker/internal/config"
@@ -13,35 +17,139 @@ import (
 )
 
 func main() {
+	os.Exit(run())
+}
+
+// run returns the exit code, so the adapters get closed by the deferred calls before the process exits
+func run() int {
 	cfg, err := config.Load()
 	if err != nil {
-		log.Fatal(err)
+		log.Print(err)
+		return 1
+	}
+	opts := []broker.Option{broker.WithWorkers(cfg.AsyncWorkers)}
+	if cfg.Verify != nil {
+		// the verify command only reports, it must not delete anything
+		opts = append(opts, broker.WithExpiryInterval(0))
+	} else {
+		opts = append(opts,
+			broker.WithExpiryInterval(cfg.ExpiryInterval),
+			broker.WithGarbageCollection(cfg.GCInterval, cfg.GCMinAge, cfg.GCDryRun))
+		for adapterName, size := range cfg.WarmPools {
+			opts = append(opts, broker.WithWarmPool(adapterName, size))
+		}
 	}
-	b := broker.New()
+	b := broker.New(opts...)
+	defer b.Close()
 
 	if cfg.PostgresURI != "" {
 		log.Println("Registering Postgres adapter")
-		p, err := postgres.New(cfg.PostgresURI)
+		var plans map[string]postgres.Plan
+		if err := cfg.Plans.Decode("postgres", &plans); err != nil {
+			log.Print(err)
+			return 1
+		}
+		opts := []postgres.Option{postgres.WithPlans(plans), postgres.WithExtensions(cfg.PostgresExtensions)}
+		if cfg.PostgresSeedDir != "" {
+			log.Print("Seeding Postgres instances from ", cfg.PostgresSeedDir)
+			opts = append(opts, postgres.WithSeedDir(cfg.PostgresSeedDir))
+		}
+		// both adapters share the instance records of the server, each serves the instances of its own mode.
+		// The broker runs the background jobs once for the shared records, and either adapter covers both modes there.
+		if cfg.PostgresSharedDB != "" {
+			log.Print("Registering Postgres schema mode adapter with shared database ", cfg.PostgresSharedDB)
+			s, err := postgres.New(cfg.PostgresURI, append(opts, postgres.WithSchemaMode(cfg.PostgresSharedDB))...)
+			if err != nil {
+				log.Print("Failed to connect to Postgres: ", err)
+				return 1
+			}
+			defer s.Close()
+			b.RegisterAdapter("postgres-schema", s)
+			opts = append(opts, postgres.WithSharedDatabase(cfg.PostgresSharedDB))
+		}
+		p, err := postgres.New(cfg.PostgresURI, opts...)
 		if err != nil {
-			log.Fatal("Failed to connect to Postgres: ", err)
+			log.Print("Failed to connect to Postgres: ", err)
+			return 1
 		}
 		defer p.Close()
 		b.RegisterAdapter("postgres", p)
 	}
 
+	if cfg.MySQLURI != "" {
+		log.Println("Registering MySQL adapter")
+		var plans map[string]mysql.Plan
+		if err := cfg.Plans.Decode("mysql", &plans); err != nil {
+			log.Print(err)
+			return 1
+		}
+		m, err := mysql.New(cfg.MySQLURI, mysql.WithPlans(plans))
+		if err != nil {
+			log.Print("Failed to connect to MySQL: ", err)
+			return 1
+		}
+		defer m.Close()
+		b.RegisterAdapter("mysql", m)
+		b.RegisterAdapter("mariadb", m)
+	}
+
+	if cfg.MongoDBURI != "" {
+		log.Println("Registering MongoDB adapter")
+		m, err := mongodb.New(cfg.MongoDBURI)
+		if err != nil {
+			log.Print("Failed to connect to MongoDB: ", err)
+			return 1
+		}
+		defer m.Close()
+		b.RegisterAdapter("mongodb", m)
+		b.RegisterAdapter("mongo", m)
+	}
+
 	if cfg.DragonflyURI != "" {
 		log.Println("Registering Dragonfly adapter")
-		d, err := dragonfly.New(cfg.DragonflyURI)
+		var plans map[string]dragonfly.Plan
+		if err := cfg.Plans.Decode("dragonfly", &plans); err != nil {
+			log.Print(err)
+			return 1
+		}
+		opts := []dragonfly.Option{dragonfly.WithPlans(plans), dragonfly.WithReconcileInterval(cfg.DragonflyReconcileInterval)}
+		if cfg.Verify != nil {
+			// verify only reports missing users, unless asked to repair them
+			opts = append(opts, dragonfly.WithReconcileInterval(0))
+		}
+		if cfg.DragonflyIsolation != "" {
+			log.Print("Using Dragonfly isolation mode ", cfg.DragonflyIsolation)
+			opts = append(opts, dragonfly.WithIsolation(dragonfly.Isolation(cfg.DragonflyIsolation)))
+		}
+		if cfg.DragonflySeedDir != "" {
+			log.Print("Seeding Dragonfly instances from ", cfg.DragonflySeedDir)
+			opts = append(opts, dragonfly.WithSeedDir(cfg.DragonflySeedDir))
+		}
+		d, err := dragonfly.New(cfg.DragonflyURI, opts...)
 		if err != nil {
-			log.Fatal("Failed to connect to Dragonfly: ", err)
+			log.Print("Failed to connect to Dragonfly: ", err)
+			return 1
 		}
 		defer d.Close()
 		b.RegisterAdapter("dragonfly", d)
 		b.RegisterAdapter("redis", d)
 	}
 
-	r := router.New(b)
+	if cfg.Verify != nil {
+		drift, err := verify(context.Background(), b, cfg.Verify.Repair)
+		if err != nil {
+			log.Print("Failed to verify: ", err)
+			return 1
+		}
+		if drift {
+			return 1
+		}
+		return 0
+	}
+
+	r := router.New(b, router.WithRotationGracePeriod(cfg.RotationGracePeriod))
 	addr := fmt.Sprintf(":%d", cfg.ServicePort)
 	log.Print("Listening on ", addr)
-	log.Fatal(http.ListenAndServe(addr, r))
+	log.Print(http.ListenAndServe(addr, r))
+	return 1
 }
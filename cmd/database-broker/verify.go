@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+
+	"github.com/razzie-cloud/database-broker/internal/broker"
+)
+
+// verify prints the drift reports of the adapters in JSON format and reports whether anything is out of sync
+func verify(ctx context.Context, b broker.Interface, repair bool) (bool, error) {
+	reports, err := b.DetectDrift(ctx, repair)
+	if err != nil {
+		return false, err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(reports); err != nil {
+		return false, err
+	}
+	for _, report := range reports {
+		if report.HasDrift() {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,9 @@
+package adapter
+
+import "context"
+
+// Cloner is implemented by the adapters that can create an instance with a copy of another instance's data.
+// The clone gets credentials of its own and the plan of the source instance.
+type Cloner interface {
+	CloneInstance(ctx context.Context, instanceName, cloneName string) (Instance, error)
+}
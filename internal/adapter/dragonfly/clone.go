@@ -0,0 +1,113 @@
+package dragonfly
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/razzie-cloud/database-broker/internal/adapter"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var _ adapter.Cloner = (*dragonflyAdapter)(nil)
+
+// CloneInstance creates an instance with the ACL profile, database and plan of the source instance,
+// then copies the keys of the source with DUMP and RESTORE
+func (d *dragonflyAdapter) CloneInstance(ctx context.Context, instanceName, cloneName string) (adapter.Instance, error) {
+	source, err := d.getInstance(ctx, instanceName)
+	if err != nil {
+		return nil, err
+	}
+	if source == nil {
+		return nil, adapter.ErrInstanceNotFound
+	}
+	if source.status() != adapter.StatusReady {
+		return nil, fmt.Errorf("%w: %s is %s", adapter.ErrInstanceNotReady, instanceName, source.status())
+	}
+	params, err := json.Marshal(CreateParams{ACLProfile: source.ACLProfile, Database: source.Database})
+	if err != nil {
+		return nil, fmt.Errorf("marshal params: %w", err)
+	}
+	created, err := d.CreateInstance(ctx, cloneName, adapter.CreateOptions{Plan: source.Plan, Params: params})
+	if err != nil {
+		return nil, err
+	}
+	clone := created.(*Instance)
+	if err := d.copyKeys(ctx, source, clone); err != nil {
+		if cleanupErr := d.DeleteInstance(context.WithoutCancel(ctx), cloneName); cleanupErr != nil {
+			log.Printf("cleanup dragonfly instance %s: %v", cloneName, cleanupErr)
+		}
+		return nil, fmt.Errorf("copy keys: %w", err)
+	}
+	return clone, nil
+}
+
+// copyKeys copies the keys of the source instance to the clone along with their TTLs.
+// Namespaces are only reachable by their users, so they get DUMP and RESTORE allowed temporarily,
+// as their ACL profile may deny them. Key prefixes are copied by the admin user.
+func (d *dragonflyAdapter) copyKeys(ctx context.Context, source, clone *Instance) error {
+	if source.KeyPrefix != "" {
+		opts := d.opts
+		opts.DB = source.Database
+		client := redis.NewClient(&opts)
+		defer client.Close()
+		return copyKeys(ctx, client, client, source.KeyPrefix, clone.KeyPrefix)
+	}
+	if err := d.setUserRules(ctx, source.Username, []string{"+DUMP"}); err != nil {
+		return err
+	}
+	defer d.resetUserRules(source)
+	if err := d.setUserRules(ctx, clone.Username, []string{"+RESTORE"}); err != nil {
+		return err
+	}
+	defer d.resetUserRules(clone)
+	sourceClient := d.userClient(source)
+	defer sourceClient.Close()
+	cloneClient := d.userClient(clone)
+	defer cloneClient.Close()
+	return copyKeys(ctx, sourceClient, cloneClient, "", "")
+}
+
+func (d *dragonflyAdapter) resetUserRules(instance *Instance) {
+	if err := d.setUserRules(context.Background(), instance.Username, d.userRules(instance)); err != nil {
+		log.Printf("reset rules of dragonfly user %s: %v", instance.Username, err)
+	}
+}
+
+func (d *dragonflyAdapter) userClient(instance *Instance) *redis.Client {
+	opts := d.opts
+	opts.Username = instance.Username
+	opts.Password = instance.Password
+	opts.DB = instance.Database
+	return redis.NewClient(&opts)
+}
+
+// copyKeys restores the keys starting with srcPrefix under dstPrefix, replacing existing ones
+func copyKeys(ctx context.Context, src, dst *redis.Client, srcPrefix, dstPrefix string) error {
+	iter := src.Scan(ctx, 0, srcPrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		value, err := src.Dump(ctx, key).Result()
+		if err == redis.Nil {
+			continue // deleted since the scan
+		}
+		if err != nil {
+			return fmt.Errorf("dump %s: %w", key, err)
+		}
+		ttl, err := src.PTTL(ctx, key).Result()
+		if err != nil {
+			return fmt.Errorf("get ttl of %s: %w", key, err)
+		}
+		if ttl < 0 {
+			ttl = 0 // no expiry
+		}
+		newKey := dstPrefix + strings.TrimPrefix(key, srcPrefix)
+		if err := dst.RestoreReplace(ctx, newKey, ttl, value).Err(); err != nil {
+			return fmt.Errorf("restore %s: %w", newKey, err)
+		}
+	}
+	return iter.Err()
+}
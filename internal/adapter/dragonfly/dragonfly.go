@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log"
 	"strings"
 	"time"
 
@@ -13,13 +14,67 @@ import (
 	"github.com/redis/go-redis/v9"
 )
 
+const (
+	credentialExpiryInterval = time.Minute
+	// provisioningTimeout is how long a creation may take including its seeds, a longer one got interrupted
+	provisioningTimeout      = time.Minute
+	provisioningPollInterval = 100 * time.Millisecond
+)
+
+// replaceInstanceScript replaces the record only if it still holds the expected data
+var replaceInstanceScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("SET", KEYS[1], ARGV[2])
+end
+return false
+`)
+
 type dragonflyAdapter struct {
-	client *redis.Client
-	host   string
-	port   int
+	client    *redis.Client
+	opts      redis.Options
+	host      string
+	port      int
+	plans     map[string]Plan
+	isolation Isolation
+	reconcile time.Duration
+	seedDir   string
+	seeds     []seed
+	done      chan struct{}
 }
 
-func New(dragonflyURI string) (adapter.Interface, error) {
+type Option func(d *dragonflyAdapter)
+
+// WithPlans sets the provisioning plans callers can choose from at instance creation
+func WithPlans(plans map[string]Plan) Option {
+	return func(d *dragonflyAdapter) {
+		d.plans = plans
+	}
+}
+
+// WithIsolation sets how the data of instances is kept apart (default: NamespaceIsolation)
+func WithIsolation(isolation Isolation) Option {
+	return func(d *dragonflyAdapter) {
+		d.isolation = isolation
+	}
+}
+
+// WithReconcileInterval sets how often the missing users of instances get restored, also done at startup
+// (default: 5m). Zero disables it.
+func WithReconcileInterval(interval time.Duration) Option {
+	return func(d *dragonflyAdapter) {
+		d.reconcile = interval
+	}
+}
+
+// WithSeedDir sets the directory of the command files (*.redis) that new instances get populated with,
+// in the order of their names. They run as the instance user right after its creation, one command per line.
+func WithSeedDir(dir string) Option {
+	return func(d *dragonflyAdapter) {
+		d.seedDir = dir
+	}
+}
+
+func New(dragonflyURI string, options ...Option) (adapter.Interface, error) {
 	host, port, err := util.GetURIHostPort(dragonflyURI, 5432)
 	if err != nil {
 		return nil, fmt.Errorf("parse dragonfly uri: %w", err)
@@ -28,13 +83,46 @@ func New(dragonflyURI string) (adapter.Interface, error) {
 	if err != nil {
 		return nil, err
 	}
-
-	client := redis.NewClient(opts)
-	return &dragonflyAdapter{
-		client: client,
-		host:   host,
-		port:   port,
-	}, nil
+	d := &dragonflyAdapter{
+		opts:      *opts,
+		host:      host,
+		port:      port,
+		isolation: NamespaceIsolation,
+		reconcile: defaultReconcileInterval,
+		done:      make(chan struct{}),
+	}
+	for _, opt := range options {
+		opt(d)
+	}
+	if err := d.isolation.validate(); err != nil {
+		return nil, err
+	}
+	for name, plan := range d.plans {
+		if err := plan.validate(); err != nil {
+			return nil, fmt.Errorf("invalid plan %s: %w", name, err)
+		}
+	}
+	if d.seedDir != "" {
+		// the commands can't know the key prefix of the instance
+		if d.isolation == KeyPrefixIsolation {
+			return nil, fmt.Errorf("seeds are not supported with key prefix isolation")
+		}
+		seeds, err := adapter.LoadSeeds(d.seedDir, "*.redis")
+		if err != nil {
+			return nil, err
+		}
+		if d.seeds, err = parseSeeds(seeds); err != nil {
+			return nil, err
+		}
+	}
+	d.client = redis.NewClient(opts) // NewClient modifies opts, d.opts keeps the parsed ones
+	go d.expireCredentialsLoop()
+	if d.reconcile > 0 {
+		// users get restored before the first request, as they may be gone after a restart of the server
+		d.logReconcileUsers()
+		go d.reconcileUsersLoop()
+	}
+	return d, nil
 }
 
 func (d *dragonflyAdapter) GetInstances(ctx context.Context) ([]string, error) {
@@ -53,47 +141,304 @@ func (d *dragonflyAdapter) GetInstances(ctx context.Context) ([]string, error) {
 	return instances, nil
 }
 
-func (d *dragonflyAdapter) GetOrCreateInstance(ctx context.Context, instanceName string) (adapter.Instance, error) {
+func (d *dragonflyAdapter) GetInstance(ctx context.Context, instanceName string) (adapter.Instance, error) {
 	instance, err := d.getInstance(ctx, instanceName)
 	if err != nil {
 		return nil, err
 	}
-	if instance != nil {
+	if instance == nil {
+		return nil, adapter.ErrInstanceNotFound
+	}
+	return instance, nil
+}
+
+// GetOrCreateInstance waits for a creation in progress and resumes failed or interrupted instances
+func (d *dragonflyAdapter) GetOrCreateInstance(ctx context.Context, instanceName string) (adapter.Instance, error) {
+	instance, err := d.waitForInstance(ctx, instanceName)
+	if err != nil {
+		return nil, err
+	}
+	if instance != nil && instance.status() != adapter.StatusFailed && !instance.interrupted() {
 		return instance, nil
 	}
-	user := "user_" + instanceName
-	pass := util.RandPassword()
-	ns := "ns_" + instanceName
-	instance = &Instance{
-		Instance:  instanceName,
-		Host:      d.host,
-		Port:      d.port,
-		Username:  user,
-		Password:  pass,
-		Namespace: ns,
-		CreatedAt: time.Now().UTC(),
+	created, err := d.CreateInstance(ctx, instanceName, adapter.CreateOptions{})
+	if err == adapter.ErrInstanceExists {
+		return d.GetInstance(ctx, instanceName)
 	}
-	data, err := json.Marshal(instance)
+	return created, err
+}
+
+// waitForInstance returns the instance once it's not provisioning anymore, or its creation got interrupted
+func (d *dragonflyAdapter) waitForInstance(ctx context.Context, instanceName string) (*Instance, error) {
+	for {
+		instance, err := d.getInstance(ctx, instanceName)
+		if err != nil || instance == nil || instance.status() != adapter.StatusProvisioning || instance.interrupted() {
+			return instance, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(provisioningPollInterval):
+		}
+	}
+}
+
+func (d *dragonflyAdapter) CreateInstance(ctx context.Context, instanceName string, opts adapter.CreateOptions) (adapter.Instance, error) {
+	var params CreateParams
+	if err := adapter.DecodeParams(opts.Params, &params); err != nil {
+		return nil, err
+	}
+	if err := params.validate(); err != nil {
+		return nil, err
+	}
+	planName, _, err := adapter.ResolvePlan(d.plans, opts.Plan)
 	if err != nil {
-		return nil, fmt.Errorf("marshal instance data: %w", err)
+		return nil, err
+	}
+	instance := &Instance{
+		Instance:   instanceName,
+		Status:     adapter.StatusProvisioning,
+		Host:       d.host,
+		Port:       d.port,
+		Username:   "user_" + instanceName,
+		Password:   util.RandPassword(),
+		ACLProfile: params.aclProfile(),
+		Database:   params.Database,
+		Plan:       planName,
+		CreatedAt:  time.Now().UTC(),
+	}
+	if opts.TTL > 0 && opts.Lease > 0 {
+		return nil, fmt.Errorf("%w: ttl and lease can't be combined", adapter.ErrInvalidParams)
 	}
-	if err := d.createUser(ctx, user, pass, ns); err != nil {
+	if opts.TTL > 0 {
+		expiresAt := instance.CreatedAt.Add(opts.TTL)
+		instance.ExpiresAt = &expiresAt
+	}
+	if opts.Lease > 0 {
+		expiresAt := instance.CreatedAt.Add(opts.Lease)
+		instance.ExpiresAt = &expiresAt
+		instance.LeaseID = strings.ToLower(util.RandToken(8))
+		instance.LeasePeriod = opts.Lease
+	}
+	if d.isolation == KeyPrefixIsolation {
+		if params.Database != 0 {
+			return nil, fmt.Errorf("%w: database is not supported with key prefix isolation", adapter.ErrInvalidParams)
+		}
+		instance.KeyPrefix = "ns_" + instanceName + ":"
+	} else {
+		instance.Namespace = "ns_" + instanceName
+	}
+	// the record is reserved before the user gets created,
+	// so a concurrent creation can't reset the password of the winner's user
+	if err := d.reserveInstance(ctx, instance); err != nil {
+		return nil, err
+	}
+	if err := d.createUser(ctx, instance); err != nil {
+		d.failInstance(ctx, instance)
 		return nil, fmt.Errorf("create user: %w", err)
 	}
-	ok, err := d.client.SetNX(ctx, "instance:"+instanceName, string(data), 0).Result()
+	if len(d.seeds) > 0 {
+		if err := d.seedInstance(ctx, instance); err != nil {
+			d.failInstance(ctx, instance)
+			return nil, err
+		}
+	}
+	ready, err := d.updateInstance(ctx, instanceName, func(current *Instance) error {
+		current.Status = adapter.StatusReady
+		current.Seeds = instance.Seeds
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("save instance data: %w", err)
+		d.failInstance(ctx, instance)
+		return nil, err
 	}
-	if !ok {
-		return d.getInstance(ctx, instanceName)
+	return ready, nil
+}
+
+// reserveInstance saves the record of a provisioning instance. A failed or interrupted instance gets taken over,
+// any other existing instance is a conflict.
+func (d *dragonflyAdapter) reserveInstance(ctx context.Context, instance *Instance) error {
+	data, err := json.Marshal(instance)
+	if err != nil {
+		return fmt.Errorf("marshal instance data: %w", err)
 	}
-	return instance, nil
+	key := "instance:" + instance.Instance
+	ok, err := d.client.SetNX(ctx, key, string(data), 0).Result()
+	if err != nil {
+		return fmt.Errorf("save instance data: %w", err)
+	}
+	if ok {
+		return nil
+	}
+	existingData, err := d.client.Get(ctx, key).Result()
+	if err == redis.Nil {
+		return adapter.ErrInstanceExists
+	}
+	if err != nil {
+		return fmt.Errorf("get instance data: %w", err)
+	}
+	existing, err := d.unmarshalInstance(instance.Instance, existingData)
+	if err != nil {
+		return err
+	}
+	if existing.status() != adapter.StatusFailed && !existing.interrupted() {
+		return adapter.ErrInstanceExists
+	}
+	// only one of the concurrent retries gets to replace the failed record
+	err = replaceInstanceScript.Run(ctx, d.client, []string{key}, existingData, string(data)).Err()
+	if err == redis.Nil {
+		return adapter.ErrInstanceExists
+	}
+	if err != nil {
+		return fmt.Errorf("save instance data: %w", err)
+	}
+	// the failed attempt's user may have survived its cleanup
+	if err := d.deleteUser(ctx, instance.Username); err != nil {
+		return fmt.Errorf("delete user: %w", err)
+	}
+	return nil
+}
+
+// failInstance deletes the user of an instance whose provisioning failed and marks the instance as failed
+func (d *dragonflyAdapter) failInstance(ctx context.Context, instance *Instance) {
+	ctx = context.WithoutCancel(ctx)
+	if err := d.deleteUser(ctx, instance.Username); err != nil {
+		log.Printf("cleanup dragonfly instance %s: %v", instance.Instance, err)
+	}
+	instance.Status = adapter.StatusFailed
+	_, err := d.updateInstance(ctx, instance.Instance, func(current *Instance) error {
+		current.Status = adapter.StatusFailed
+		return nil
+	})
+	if err != nil {
+		log.Printf("mark dragonfly instance %s as failed: %v", instance.Instance, err)
+	}
+}
+
+func (d *dragonflyAdapter) DeleteInstance(ctx context.Context, instanceName string) error {
+	instance, err := d.getInstance(ctx, instanceName)
+	if err != nil {
+		return err
+	}
+	if instance == nil {
+		return adapter.ErrInstanceNotFound
+	}
+	// instances that aren't ready never had a working user, so they have no keys either
+	if instance.status() == adapter.StatusReady {
+		if err := d.deleteKeys(ctx, instance); err != nil {
+			return fmt.Errorf("delete instance keys: %w", err)
+		}
+	}
+	if err := d.deleteUser(ctx, instance.Username); err != nil {
+		return fmt.Errorf("delete user: %w", err)
+	}
+	if err := d.client.Del(ctx, "instance:"+instanceName).Err(); err != nil {
+		return fmt.Errorf("delete instance data: %w", err)
+	}
+	return nil
+}
+
+// RotateCredentials changes the passwords of the user first, then only takes them over into the current record,
+// so a lease renewed in the meantime keeps its expiry
+func (d *dragonflyAdapter) RotateCredentials(ctx context.Context, instanceName string, opts adapter.RotateOptions) (adapter.Instance, error) {
+	instance, err := d.getInstance(ctx, instanceName)
+	if err != nil {
+		return nil, err
+	}
+	if instance == nil {
+		return nil, adapter.ErrInstanceNotFound
+	}
+	if instance.status() != adapter.StatusReady {
+		return nil, fmt.Errorf("%w: %s is %s", adapter.ErrInstanceNotReady, instanceName, instance.status())
+	}
+	if err := d.retirePreviousPassword(ctx, instance); err != nil {
+		return nil, fmt.Errorf("remove previous password: %w", err)
+	}
+	pass := util.RandPassword()
+	if opts.GracePeriod > 0 {
+		if err := d.addUserPassword(ctx, instance.Username, pass); err != nil {
+			return nil, fmt.Errorf("add user password: %w", err)
+		}
+		expiresAt := time.Now().UTC().Add(opts.GracePeriod)
+		instance.PreviousPassword = instance.Password
+		instance.PreviousExpiresAt = &expiresAt
+	} else {
+		if err := d.setUserPassword(ctx, instance.Username, pass); err != nil {
+			return nil, fmt.Errorf("set user password: %w", err)
+		}
+	}
+	instance.Password = pass
+	rotated, err := d.updateInstance(ctx, instanceName, func(current *Instance) error {
+		current.setCredentials(instance)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rotated, nil
 }
 
 func (d *dragonflyAdapter) Close() error {
+	close(d.done)
 	return d.client.Close()
 }
 
+func (d *dragonflyAdapter) expireCredentialsLoop() {
+	ticker := time.NewTicker(credentialExpiryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C:
+			if err := d.expireCredentials(context.Background()); err != nil {
+				log.Printf("expire dragonfly credentials: %v", err)
+			}
+		}
+	}
+}
+
+// expireCredentials removes the previous passwords whose grace period has ended
+func (d *dragonflyAdapter) expireCredentials(ctx context.Context) error {
+	instanceNames, err := d.GetInstances(ctx)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, instanceName := range instanceNames {
+		instance, err := d.getInstance(ctx, instanceName)
+		if err != nil {
+			return err
+		}
+		if instance == nil || instance.PreviousExpiresAt == nil || instance.PreviousExpiresAt.After(now) {
+			continue
+		}
+		if err := d.retirePreviousPassword(ctx, instance); err != nil {
+			return fmt.Errorf("remove previous password of %s: %w", instanceName, err)
+		}
+		_, err = d.updateInstance(ctx, instanceName, func(current *Instance) error {
+			current.setCredentials(instance)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *dragonflyAdapter) retirePreviousPassword(ctx context.Context, instance *Instance) error {
+	if instance.PreviousPassword == "" {
+		return nil
+	}
+	if err := d.client.Do(ctx, "ACL", "SETUSER", instance.Username, "<"+instance.PreviousPassword).Err(); err != nil {
+		return err
+	}
+	instance.PreviousPassword = ""
+	instance.PreviousExpiresAt = nil
+	return nil
+}
+
 func (d *dragonflyAdapter) getInstance(ctx context.Context, instanceName string) (*Instance, error) {
 	data, err := d.client.Get(ctx, "instance:"+instanceName).Result()
 	if err != nil {
@@ -116,13 +461,113 @@ func (d *dragonflyAdapter) unmarshalInstance(instanceName, data string) (*Instan
 	return &instance, nil
 }
 
-func (d *dragonflyAdapter) createUser(ctx context.Context, username, password, namespace string) error {
-	return d.client.Do(ctx, "ACL",
-		"SETUSER", username,
-		"NAMESPACE:"+namespace,
-		"ON",
-		">"+password,
-		"+@all", "-@admin", "-ACL", "-CONFIG", "-MODULE", "-CLUSTER",
-		"~*",
-	).Err()
+func (d *dragonflyAdapter) createUser(ctx context.Context, instance *Instance) error {
+	args := []any{"ACL", "SETUSER", instance.Username}
+	if instance.Namespace != "" {
+		args = append(args, "NAMESPACE:"+instance.Namespace)
+	}
+	args = append(args, "ON", ">"+instance.Password)
+	for _, rule := range d.userRules(instance) {
+		args = append(args, rule)
+	}
+	for _, selector := range d.userSelectors(instance) {
+		args = append(args, selector)
+	}
+	return d.client.Do(ctx, args...).Err()
+}
+
+// userRules returns the command rules of the instance's ACL profile followed by the ones of its plan
+func (d *dragonflyAdapter) userRules(instance *Instance) []string {
+	rules := aclProfiles[instance.aclProfile()]
+	rules = append(rules[:len(rules):len(rules)], d.plans[instance.Plan].Commands...)
+	if instance.KeyPrefix != "" {
+		rules = append(rules, keyPrefixRules...)
+	}
+	return rules
+}
+
+func (d *dragonflyAdapter) userKeyPatterns(instance *Instance) []string {
+	if patterns := d.plans[instance.Plan].KeyPatterns; len(patterns) > 0 {
+		return patterns
+	}
+	return []string{"~*"}
+}
+
+// updateInstance applies fn to the current record of the instance and replaces the record only if it didn't change
+// since it got read. Otherwise fn gets applied again to the new record, so concurrent updates, like a lease renewal
+// during a credential rotation, don't overwrite each other. fn may run several times, so it must only change the record.
+func (d *dragonflyAdapter) updateInstance(ctx context.Context, instanceName string, fn func(instance *Instance) error) (*Instance, error) {
+	key := "instance:" + instanceName
+	for {
+		data, err := d.client.Get(ctx, key).Result()
+		if err == redis.Nil {
+			return nil, adapter.ErrInstanceNotFound
+		}
+		if err != nil {
+			return nil, fmt.Errorf("get instance data: %w", err)
+		}
+		instance, err := d.unmarshalInstance(instanceName, data)
+		if err != nil {
+			return nil, err
+		}
+		if err := fn(instance); err != nil {
+			return nil, err
+		}
+		updated, err := json.Marshal(instance)
+		if err != nil {
+			return nil, fmt.Errorf("marshal instance data: %w", err)
+		}
+		err = replaceInstanceScript.Run(ctx, d.client, []string{key}, data, string(updated)).Err()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("save instance data: %w", err)
+		}
+		return instance, nil
+	}
+}
+
+func (d *dragonflyAdapter) setUserPassword(ctx context.Context, username, password string) error {
+	return d.client.Do(ctx, "ACL", "SETUSER", username, "resetpass", ">"+password).Err()
+}
+
+func (d *dragonflyAdapter) setUserRules(ctx context.Context, username string, rules []string) error {
+	args := []any{"ACL", "SETUSER", username}
+	for _, rule := range rules {
+		args = append(args, rule)
+	}
+	return d.client.Do(ctx, args...).Err()
+}
+
+func (d *dragonflyAdapter) addUserPassword(ctx context.Context, username, password string) error {
+	return d.client.Do(ctx, "ACL", "SETUSER", username, ">"+password).Err()
+}
+
+func (d *dragonflyAdapter) deleteUser(ctx context.Context, username string) error {
+	return d.client.Do(ctx, "ACL", "DELUSER", username).Err()
+}
+
+func (d *dragonflyAdapter) deleteKeys(ctx context.Context, instance *Instance) error {
+	if instance.KeyPrefix != "" {
+		return d.deleteKeyPrefix(ctx, instance)
+	}
+	return d.flushNamespace(ctx, instance)
+}
+
+// flushNamespace connects as the instance user, so FLUSHALL only wipes the user's namespace.
+// FLUSHALL is allowed temporarily, as the user's ACL profile may deny it.
+func (d *dragonflyAdapter) flushNamespace(ctx context.Context, instance *Instance) error {
+	if err := d.client.Do(ctx, "ACL", "SETUSER", instance.Username, "+FLUSHALL").Err(); err != nil {
+		return err
+	}
+	opts := d.opts
+	opts.Username = instance.Username
+	opts.Password = instance.Password
+	client := redis.NewClient(&opts)
+	defer client.Close()
+	if err := client.FlushAll(ctx).Err(); err != nil {
+		return err
+	}
+	return d.setUserRules(ctx, instance.Username, d.userRules(instance))
 }
@@ -2,9 +2,16 @@ package dragonfly
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	adapterpkg "github.com/razzie-cloud/database-broker/internal/adapter"
 
 	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/require"
@@ -53,6 +60,12 @@ func TestDragonflyAdapterIntegration(t *testing.T) {
 	require.NoError(t, err)
 	require.ElementsMatch(t, []string{"foo", "bar"}, instances)
 
+	fooLookup, err := adapter.GetInstance(ctx, "foo")
+	require.NoError(t, err)
+	require.Equal(t, foo.GetURI(), fooLookup.GetURI())
+	_, err = adapter.GetInstance(ctx, "missing")
+	require.ErrorIs(t, err, adapterpkg.ErrInstanceNotFound)
+
 	fooClientOpts, _ := redis.ParseURL(foo.GetURI())
 	fooClient := redis.NewClient(fooClientOpts)
 	defer fooClient.Close()
@@ -83,3 +96,776 @@ func TestDragonflyAdapterIntegration(t *testing.T) {
 	require.Error(t, err)
 	require.True(t, strings.Contains(err.Error(), "nil"))
 }
+
+func TestDragonflyAdapterDeleteInstance(t *testing.T) {
+	ctx := context.Background()
+	container, uri, _ := startDragonflyContainer(t)
+	defer container.Terminate(ctx)
+
+	adapter, err := New(uri)
+	require.NoError(t, err)
+
+	foo, err := adapter.GetOrCreateInstance(ctx, "foo")
+	require.NoError(t, err)
+
+	fooClientOpts, _ := redis.ParseURL(foo.GetURI())
+	fooClient := redis.NewClient(fooClientOpts)
+	require.NoError(t, fooClient.Set(ctx, "foo-key", "foo-value", 0).Err())
+	fooClient.Close()
+
+	require.NoError(t, adapter.DeleteInstance(ctx, "foo"))
+	require.ErrorIs(t, adapter.DeleteInstance(ctx, "foo"), adapterpkg.ErrInstanceNotFound)
+
+	instances, err := adapter.GetInstances(ctx)
+	require.NoError(t, err)
+	require.Empty(t, instances)
+
+	// The old credentials no longer work
+	fooClient = redis.NewClient(fooClientOpts)
+	defer fooClient.Close()
+	require.Error(t, fooClient.Ping(ctx).Err())
+
+	// A recreated instance starts with an empty namespace
+	foo, err = adapter.GetOrCreateInstance(ctx, "foo")
+	require.NoError(t, err)
+	newFooClientOpts, _ := redis.ParseURL(foo.GetURI())
+	newFooClient := redis.NewClient(newFooClientOpts)
+	defer newFooClient.Close()
+	_, err = newFooClient.Get(ctx, "foo-key").Result()
+	require.ErrorIs(t, err, redis.Nil)
+}
+
+func TestDragonflyAdapterRotateCredentials(t *testing.T) {
+	ctx := context.Background()
+	container, uri, _ := startDragonflyContainer(t)
+	defer container.Terminate(ctx)
+
+	adapter, err := New(uri)
+	require.NoError(t, err)
+
+	foo, err := adapter.GetOrCreateInstance(ctx, "foo")
+	require.NoError(t, err)
+	fooClientOpts, _ := redis.ParseURL(foo.GetURI())
+	fooClient := redis.NewClient(fooClientOpts)
+	require.NoError(t, fooClient.Set(ctx, "foo-key", "foo-value", 0).Err())
+	fooClient.Close()
+
+	rotated, err := adapter.RotateCredentials(ctx, "foo", adapterpkg.RotateOptions{})
+	require.NoError(t, err)
+	require.NotEqual(t, foo.GetURI(), rotated.GetURI())
+
+	// The stored instance matches the rotated one
+	stored, err := adapter.GetOrCreateInstance(ctx, "foo")
+	require.NoError(t, err)
+	require.Equal(t, rotated.GetURI(), stored.GetURI())
+
+	// Old password no longer works
+	oldClient := redis.NewClient(fooClientOpts)
+	defer oldClient.Close()
+	require.Error(t, oldClient.Ping(ctx).Err())
+
+	// New password works and data is kept
+	newClientOpts, _ := redis.ParseURL(rotated.GetURI())
+	newClient := redis.NewClient(newClientOpts)
+	defer newClient.Close()
+	val, err := newClient.Get(ctx, "foo-key").Result()
+	require.NoError(t, err)
+	require.Equal(t, "foo-value", val)
+
+	_, err = adapter.RotateCredentials(ctx, "missing", adapterpkg.RotateOptions{})
+	require.ErrorIs(t, err, adapterpkg.ErrInstanceNotFound)
+}
+
+func TestDragonflyAdapterRotateCredentialsWithGracePeriod(t *testing.T) {
+	ctx := context.Background()
+	container, uri, _ := startDragonflyContainer(t)
+	defer container.Terminate(ctx)
+
+	adapter, err := New(uri)
+	require.NoError(t, err)
+
+	foo, err := adapter.GetOrCreateInstance(ctx, "foo")
+	require.NoError(t, err)
+
+	rotated, err := adapter.RotateCredentials(ctx, "foo", adapterpkg.RotateOptions{GracePeriod: time.Hour})
+	require.NoError(t, err)
+	resp := rotated.GetJSON().(InstanceResponse)
+	require.NotNil(t, resp.PreviousCredential)
+	require.Equal(t, foo.GetURI(), resp.PreviousCredential.URI)
+
+	// Both credentials work during the grace period
+	oldClientOpts, _ := redis.ParseURL(foo.GetURI())
+	oldClient := redis.NewClient(oldClientOpts)
+	require.NoError(t, oldClient.Ping(ctx).Err())
+	oldClient.Close()
+	newClientOpts, _ := redis.ParseURL(rotated.GetURI())
+	newClient := redis.NewClient(newClientOpts)
+	defer newClient.Close()
+	require.NoError(t, newClient.Ping(ctx).Err())
+
+	// The old password is removed once the grace period ends
+	rotated, err = adapter.RotateCredentials(ctx, "foo", adapterpkg.RotateOptions{GracePeriod: time.Millisecond})
+	require.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, adapter.(*dragonflyAdapter).expireCredentials(ctx))
+	stored, err := adapter.(*dragonflyAdapter).getInstance(ctx, "foo")
+	require.NoError(t, err)
+	require.Empty(t, stored.PreviousPassword)
+	require.Nil(t, stored.PreviousExpiresAt)
+	expiredClient := redis.NewClient(newClientOpts)
+	defer expiredClient.Close()
+	require.Error(t, expiredClient.Ping(ctx).Err())
+	latestClientOpts, _ := redis.ParseURL(rotated.GetURI())
+	latestClient := redis.NewClient(latestClientOpts)
+	defer latestClient.Close()
+	require.NoError(t, latestClient.Ping(ctx).Err())
+}
+
+func TestDragonflyAdapterCreateInstance(t *testing.T) {
+	ctx := context.Background()
+	container, uri, _ := startDragonflyContainer(t)
+	defer container.Terminate(ctx)
+
+	adapter, err := New(uri)
+	require.NoError(t, err)
+
+	params := json.RawMessage(`{"acl_profile":"restricted","database":1}`)
+	foo, err := adapter.CreateInstance(ctx, "foo", adapterpkg.CreateOptions{Params: params})
+	require.NoError(t, err)
+	require.True(t, strings.HasSuffix(foo.GetURI(), "/1"))
+
+	_, err = adapter.CreateInstance(ctx, "foo", adapterpkg.CreateOptions{})
+	require.ErrorIs(t, err, adapterpkg.ErrInstanceExists)
+
+	fooClientOpts, _ := redis.ParseURL(foo.GetURI())
+	fooClient := redis.NewClient(fooClientOpts)
+	defer fooClient.Close()
+	require.NoError(t, fooClient.Set(ctx, "foo-key", "foo-value", 0).Err())
+	// Dangerous commands are denied by the restricted profile
+	require.Error(t, fooClient.FlushAll(ctx).Err())
+	require.NoError(t, adapter.DeleteInstance(ctx, "foo"))
+
+	_, err = adapter.CreateInstance(ctx, "bar", adapterpkg.CreateOptions{Params: json.RawMessage(`{"acl_profile":"unknown"}`)})
+	require.ErrorIs(t, err, adapterpkg.ErrInvalidParams)
+}
+
+func TestDragonflyAdapterConcurrentCreate(t *testing.T) {
+	ctx := context.Background()
+	container, uri, _ := startDragonflyContainer(t)
+	defer container.Terminate(ctx)
+
+	adapter, err := New(uri)
+	require.NoError(t, err)
+	defer adapter.Close()
+
+	const workers = 20
+	uris := make([]string, workers)
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	for i := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			instance, err := adapter.GetOrCreateInstance(ctx, "foo")
+			errs[i] = err
+			if err == nil {
+				uris[i] = instance.GetURI()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Every caller gets the same credential, and it's the one that works
+	for i := range workers {
+		require.NoError(t, errs[i])
+		require.Equal(t, uris[0], uris[i])
+	}
+	clientOpts, err := redis.ParseURL(uris[0])
+	require.NoError(t, err)
+	client := redis.NewClient(clientOpts)
+	defer client.Close()
+	require.NoError(t, client.Set(ctx, "key", "value", 0).Err())
+}
+
+func TestDragonflyAdapterExpiry(t *testing.T) {
+	ctx := context.Background()
+	container, uri, _ := startDragonflyContainer(t)
+	defer container.Terminate(ctx)
+
+	adapter, err := New(uri)
+	require.NoError(t, err)
+	defer adapter.Close()
+	expirer := adapter.(adapterpkg.Expirer)
+
+	foo, err := adapter.CreateInstance(ctx, "foo", adapterpkg.CreateOptions{TTL: time.Hour})
+	require.NoError(t, err)
+	expiresAt := foo.GetJSON().(InstanceResponse).ExpiresAt
+	require.NotNil(t, expiresAt)
+	_, err = adapter.CreateInstance(ctx, "bar", adapterpkg.CreateOptions{})
+	require.NoError(t, err)
+
+	deleted, err := expirer.DeleteExpiredInstances(ctx, time.Now())
+	require.NoError(t, err)
+	require.Empty(t, deleted)
+
+	deleted, err = expirer.DeleteExpiredInstances(ctx, expiresAt.Add(time.Second))
+	require.NoError(t, err)
+	require.Equal(t, []string{"foo"}, deleted)
+	_, err = adapter.GetInstance(ctx, "foo")
+	require.ErrorIs(t, err, adapterpkg.ErrInstanceNotFound)
+	_, err = adapter.GetInstance(ctx, "bar")
+	require.NoError(t, err)
+}
+
+func TestDragonflyAdapterLease(t *testing.T) {
+	ctx := context.Background()
+	container, uri, _ := startDragonflyContainer(t)
+	defer container.Terminate(ctx)
+
+	adapter, err := New(uri)
+	require.NoError(t, err)
+	defer adapter.Close()
+	leaser := adapter.(adapterpkg.Leaser)
+
+	_, err = adapter.CreateInstance(ctx, "foo", adapterpkg.CreateOptions{TTL: time.Hour, Lease: time.Minute})
+	require.ErrorIs(t, err, adapterpkg.ErrInvalidParams)
+	foo, err := adapter.CreateInstance(ctx, "foo", adapterpkg.CreateOptions{Lease: time.Minute})
+	require.NoError(t, err)
+	lease := foo.GetJSON().(InstanceResponse).Lease
+	require.NotNil(t, lease)
+	require.Equal(t, "1m0s", lease.Period)
+	expiresAt := foo.GetJSON().(InstanceResponse).ExpiresAt
+
+	_, err = leaser.RenewLease(ctx, "foo", "wrong")
+	require.ErrorIs(t, err, adapterpkg.ErrInvalidLease)
+	time.Sleep(10 * time.Millisecond)
+	renewed, err := leaser.RenewLease(ctx, "foo", lease.ID)
+	require.NoError(t, err)
+	require.True(t, renewed.GetJSON().(InstanceResponse).ExpiresAt.After(*expiresAt))
+
+	// The lease survives a restart and the instance is reclaimed once it's not renewed
+	restarted, err := New(uri)
+	require.NoError(t, err)
+	defer restarted.Close()
+	leaser = restarted.(adapterpkg.Leaser)
+	deleted, err := leaser.DeleteExpiredInstances(ctx, time.Now().Add(2*time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, []string{"foo"}, deleted)
+	_, err = leaser.RenewLease(ctx, "foo", lease.ID)
+	require.ErrorIs(t, err, adapterpkg.ErrInstanceNotFound)
+}
+
+func TestDragonflyAdapterConcurrentRenewAndRotate(t *testing.T) {
+	ctx := context.Background()
+	container, uri, _ := startDragonflyContainer(t)
+	defer container.Terminate(ctx)
+
+	adapter, err := New(uri)
+	require.NoError(t, err)
+	defer adapter.Close()
+	leaser := adapter.(adapterpkg.Leaser)
+
+	foo, err := adapter.CreateInstance(ctx, "foo", adapterpkg.CreateOptions{Lease: time.Minute})
+	require.NoError(t, err)
+	leaseID := foo.GetJSON().(InstanceResponse).Lease.ID
+
+	const rounds = 50
+	var renewed adapterpkg.Instance
+	var renewErr, rotateErr error
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < rounds && renewErr == nil; i++ {
+			renewed, renewErr = leaser.RenewLease(ctx, "foo", leaseID)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < rounds && rotateErr == nil; i++ {
+			_, rotateErr = adapter.RotateCredentials(ctx, "foo", adapterpkg.RotateOptions{})
+		}
+	}()
+	wg.Wait()
+	require.NoError(t, renewErr)
+	require.NoError(t, rotateErr)
+
+	// Renewals don't bring back a rotated password, and rotations don't bring back an earlier expiry
+	foo, err = adapter.GetInstance(ctx, "foo")
+	require.NoError(t, err)
+	require.WithinDuration(t, *renewed.GetJSON().(InstanceResponse).ExpiresAt, *foo.GetJSON().(InstanceResponse).ExpiresAt, 0)
+	fooClientOpts, err := redis.ParseURL(foo.GetURI())
+	require.NoError(t, err)
+	fooClient := redis.NewClient(fooClientOpts)
+	defer fooClient.Close()
+	require.NoError(t, fooClient.Ping(ctx).Err())
+}
+
+func TestDragonflyAdapterFailedInstance(t *testing.T) {
+	ctx := context.Background()
+	container, uri, _ := startDragonflyContainer(t)
+	defer container.Terminate(ctx)
+
+	adapter, err := New(uri, WithPlans(map[string]Plan{
+		"bad": {Commands: []string{"+nosuchcommand"}},
+	}))
+	require.NoError(t, err)
+	defer adapter.Close()
+
+	adminOpts, _ := redis.ParseURL(uri)
+	adminClient := redis.NewClient(adminOpts)
+	defer adminClient.Close()
+
+	// A failed creation deletes its user and is kept as failed
+	_, err = adapter.CreateInstance(ctx, "foo", adapterpkg.CreateOptions{Plan: "bad"})
+	require.Error(t, err)
+	failed, err := adapter.GetInstance(ctx, "foo")
+	require.NoError(t, err)
+	require.Equal(t, adapterpkg.StatusFailed, failed.GetJSON().(InstanceResponse).Status)
+	users, err := adminClient.Do(ctx, "ACL", "USERS").StringSlice()
+	require.NoError(t, err)
+	require.NotContains(t, users, "user_foo")
+	_, err = adapter.RotateCredentials(ctx, "foo", adapterpkg.RotateOptions{})
+	require.ErrorIs(t, err, adapterpkg.ErrInstanceNotReady)
+
+	// Creating it again resumes it
+	foo, err := adapter.GetOrCreateInstance(ctx, "foo")
+	require.NoError(t, err)
+	require.Equal(t, adapterpkg.StatusReady, foo.GetJSON().(InstanceResponse).Status)
+	fooClientOpts, _ := redis.ParseURL(foo.GetURI())
+	fooClient := redis.NewClient(fooClientOpts)
+	defer fooClient.Close()
+	require.NoError(t, fooClient.Set(ctx, "foo-key", "foo-value", 0).Err())
+	_, err = adapter.CreateInstance(ctx, "foo", adapterpkg.CreateOptions{})
+	require.ErrorIs(t, err, adapterpkg.ErrInstanceExists)
+
+	// Failed instances can be deleted
+	_, err = adapter.CreateInstance(ctx, "bar", adapterpkg.CreateOptions{Plan: "bad"})
+	require.Error(t, err)
+	require.NoError(t, adapter.DeleteInstance(ctx, "bar"))
+	_, err = adapter.GetInstance(ctx, "bar")
+	require.ErrorIs(t, err, adapterpkg.ErrInstanceNotFound)
+
+	// An instance left provisioning by a crashed creator is resumed once the provisioning timeout passed
+	d := adapter.(*dragonflyAdapter)
+	baz, err := adapter.GetOrCreateInstance(ctx, "baz")
+	require.NoError(t, err)
+	_, err = d.updateInstance(ctx, "baz", func(stale *Instance) error {
+		stale.Status = adapterpkg.StatusProvisioning
+		return nil
+	})
+	require.NoError(t, err)
+	_, err = adapter.CreateInstance(ctx, "baz", adapterpkg.CreateOptions{})
+	require.ErrorIs(t, err, adapterpkg.ErrInstanceExists)
+	_, err = d.updateInstance(ctx, "baz", func(stale *Instance) error {
+		stale.CreatedAt = time.Now().UTC().Add(-2 * provisioningTimeout)
+		return nil
+	})
+	require.NoError(t, err)
+	resumed, err := adapter.GetOrCreateInstance(ctx, "baz")
+	require.NoError(t, err)
+	require.Equal(t, adapterpkg.StatusReady, resumed.GetJSON().(InstanceResponse).Status)
+	require.NotEqual(t, baz.GetURI(), resumed.GetURI())
+	bazClientOpts, _ := redis.ParseURL(resumed.GetURI())
+	bazClient := redis.NewClient(bazClientOpts)
+	defer bazClient.Close()
+	require.NoError(t, bazClient.Ping(ctx).Err())
+}
+
+func TestDragonflyAdapterReconcileUsers(t *testing.T) {
+	ctx := context.Background()
+	container, uri, _ := startDragonflyContainer(t)
+	defer container.Terminate(ctx)
+
+	adapter, err := New(uri)
+	require.NoError(t, err)
+	defer adapter.Close()
+
+	foo, err := adapter.GetOrCreateInstance(ctx, "foo")
+	require.NoError(t, err)
+	_, err = adapter.GetOrCreateInstance(ctx, "bar")
+	require.NoError(t, err)
+
+	// Simulate a server restart without an ACL file
+	adminOpts, _ := redis.ParseURL(uri)
+	adminClient := redis.NewClient(adminOpts)
+	defer adminClient.Close()
+	require.NoError(t, adminClient.Do(ctx, "ACL", "DELUSER", "user_foo").Err())
+
+	restored, err := adapter.(*dragonflyAdapter).reconcileUsers(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"user_foo"}, restored)
+
+	// The stored credential works again
+	fooClientOpts, _ := redis.ParseURL(foo.GetURI())
+	fooClient := redis.NewClient(fooClientOpts)
+	defer fooClient.Close()
+	require.NoError(t, fooClient.Set(ctx, "foo-key", "foo-value", 0).Err())
+
+	restored, err = adapter.(*dragonflyAdapter).reconcileUsers(ctx)
+	require.NoError(t, err)
+	require.Empty(t, restored)
+}
+
+func TestDragonflyAdapterDetectDrift(t *testing.T) {
+	ctx := context.Background()
+	container, uri, _ := startDragonflyContainer(t)
+	defer container.Terminate(ctx)
+
+	adapter, err := New(uri)
+	require.NoError(t, err)
+	defer adapter.Close()
+	detector := adapter.(adapterpkg.DriftDetector)
+
+	foo, err := adapter.GetOrCreateInstance(ctx, "foo")
+	require.NoError(t, err)
+	_, err = adapter.GetOrCreateInstance(ctx, "bar")
+	require.NoError(t, err)
+
+	adminOpts, _ := redis.ParseURL(uri)
+	adminClient := redis.NewClient(adminOpts)
+	defer adminClient.Close()
+	require.NoError(t, adminClient.Do(ctx, "ACL", "DELUSER", "user_foo").Err())
+	require.NoError(t, adminClient.Do(ctx, "ACL", "SETUSER", "user_orphan").Err())
+
+	report, err := detector.DetectDrift(ctx, false)
+	require.NoError(t, err)
+	require.Equal(t, []string{"user_orphan"}, report.OrphanedUsers)
+	require.Equal(t, []string{"foo"}, report.MissingUsers)
+
+	report, err = detector.DetectDrift(ctx, true)
+	require.NoError(t, err)
+	require.Equal(t, []string{"foo"}, report.Repaired)
+	require.Empty(t, report.MissingUsers)
+	fooClientOpts, _ := redis.ParseURL(foo.GetURI())
+	fooClient := redis.NewClient(fooClientOpts)
+	defer fooClient.Close()
+	require.NoError(t, fooClient.Set(ctx, "foo-key", "foo-value", 0).Err())
+}
+
+func TestDragonflyAdapterDropOrphans(t *testing.T) {
+	ctx := context.Background()
+	container, uri, _ := startDragonflyContainer(t)
+	defer container.Terminate(ctx)
+
+	adapter, err := New(uri)
+	require.NoError(t, err)
+	defer adapter.Close()
+	gc := adapter.(adapterpkg.GarbageCollector)
+
+	foo, err := adapter.GetOrCreateInstance(ctx, "foo")
+	require.NoError(t, err)
+	adminOpts, _ := redis.ParseURL(uri)
+	adminClient := redis.NewClient(adminOpts)
+	defer adminClient.Close()
+	require.NoError(t, adminClient.Do(ctx, "ACL", "SETUSER", "user_orphan").Err())
+
+	// The users of instances are skipped
+	require.NoError(t, gc.DropOrphans(ctx, nil, []string{"user_orphan", "user_foo"}))
+	report, err := gc.DetectDrift(ctx, false)
+	require.NoError(t, err)
+	require.False(t, report.HasDrift())
+	fooClientOpts, _ := redis.ParseURL(foo.GetURI())
+	fooClient := redis.NewClient(fooClientOpts)
+	defer fooClient.Close()
+	require.NoError(t, fooClient.Set(ctx, "foo-key", "foo-value", 0).Err())
+}
+
+func TestDragonflyAdapterPlans(t *testing.T) {
+	ctx := context.Background()
+	container, uri, _ := startDragonflyContainer(t)
+	defer container.Terminate(ctx)
+
+	adapter, err := New(uri, WithPlans(map[string]Plan{
+		"small": {
+			Commands:    []string{"-@dangerous"},
+			KeyPatterns: []string{"~cache:*"},
+		},
+	}))
+	require.NoError(t, err)
+
+	foo, err := adapter.CreateInstance(ctx, "foo", adapterpkg.CreateOptions{Plan: "small"})
+	require.NoError(t, err)
+	require.Equal(t, "small", foo.GetJSON().(InstanceResponse).Plan)
+
+	fooClientOpts, _ := redis.ParseURL(foo.GetURI())
+	fooClient := redis.NewClient(fooClientOpts)
+	defer fooClient.Close()
+	require.NoError(t, fooClient.Set(ctx, "cache:key", "value", 0).Err())
+	require.Error(t, fooClient.Set(ctx, "other:key", "value", 0).Err())
+	require.Error(t, fooClient.FlushAll(ctx).Err())
+
+	_, err = adapter.CreateInstance(ctx, "bar", adapterpkg.CreateOptions{Plan: "huge"})
+	require.ErrorIs(t, err, adapterpkg.ErrInvalidParams)
+
+	_, err = New(uri, WithPlans(map[string]Plan{"bad": {Commands: []string{"nopass"}}}))
+	require.Error(t, err)
+}
+
+func startRedisContainer(t *testing.T) (testcontainers.Container, string, int) {
+	req := testcontainers.ContainerRequest{
+		Image:        "redis:7",
+		ExposedPorts: []string{"6379/tcp"},
+		Cmd:          []string{"redis-server", "--requirepass", "adminpass"},
+		WaitingFor:   wait.ForListeningPort("6379/tcp"),
+	}
+	container, err := testcontainers.GenericContainer(context.Background(), testcontainers.GenericContainerRequest{
+		ContainerRequest: req,
+		Started:          true,
+	})
+	require.NoError(t, err)
+
+	host, err := container.Host(context.Background())
+	require.NoError(t, err)
+	portStr, err := container.MappedPort(context.Background(), "6379")
+	require.NoError(t, err)
+	port := portStr.Int()
+	uri := fmt.Sprintf("redis://:adminpass@%s:%d/0", host, port)
+	return container, uri, port
+}
+
+func TestDragonflyAdapterCloneInstance(t *testing.T) {
+	ctx := context.Background()
+	container, uri, _ := startDragonflyContainer(t)
+	defer container.Terminate(ctx)
+
+	adapter, err := New(uri)
+	require.NoError(t, err)
+	defer adapter.Close()
+	cloner := adapter.(adapterpkg.Cloner)
+
+	_, err = cloner.CloneInstance(ctx, "foo", "bar")
+	require.ErrorIs(t, err, adapterpkg.ErrInstanceNotFound)
+	foo, err := adapter.CreateInstance(ctx, "foo", adapterpkg.CreateOptions{Params: json.RawMessage(`{"acl_profile":"restricted"}`)})
+	require.NoError(t, err)
+	fooClientOpts, err := redis.ParseURL(foo.GetURI())
+	require.NoError(t, err)
+	fooClient := redis.NewClient(fooClientOpts)
+	defer fooClient.Close()
+	require.NoError(t, fooClient.Set(ctx, "key", "value", 0).Err())
+	require.NoError(t, fooClient.Set(ctx, "temp", "value", time.Hour).Err())
+
+	bar, err := cloner.CloneInstance(ctx, "foo", "bar")
+	require.NoError(t, err)
+	barResp := bar.GetJSON().(InstanceResponse)
+	require.Equal(t, "ns_bar", barResp.Namespace)
+	require.Equal(t, "restricted", barResp.ACLProfile)
+	_, err = cloner.CloneInstance(ctx, "foo", "bar")
+	require.ErrorIs(t, err, adapterpkg.ErrInstanceExists)
+
+	barClientOpts, err := redis.ParseURL(bar.GetURI())
+	require.NoError(t, err)
+	barClient := redis.NewClient(barClientOpts)
+	defer barClient.Close()
+	require.Equal(t, "value", barClient.Get(ctx, "key").Val())
+	require.Positive(t, barClient.TTL(ctx, "temp").Val())
+
+	// the keys are copies
+	require.NoError(t, barClient.Set(ctx, "key", "changed", 0).Err())
+	require.Equal(t, "value", fooClient.Get(ctx, "key").Val())
+}
+
+func TestDragonflyAdapterResetInstance(t *testing.T) {
+	ctx := context.Background()
+	container, uri, _ := startDragonflyContainer(t)
+	defer container.Terminate(ctx)
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "01_keys.redis"), []byte("SET greeting hello\n"), 0o644))
+	adapter, err := New(uri, WithSeedDir(dir))
+	require.NoError(t, err)
+	defer adapter.Close()
+	resetter := adapter.(adapterpkg.Resetter)
+
+	_, err = resetter.ResetInstance(ctx, "foo")
+	require.ErrorIs(t, err, adapterpkg.ErrInstanceNotFound)
+	foo, err := adapter.GetOrCreateInstance(ctx, "foo")
+	require.NoError(t, err)
+	bar, err := adapter.GetOrCreateInstance(ctx, "bar")
+	require.NoError(t, err)
+	fooClientOpts, err := redis.ParseURL(foo.GetURI())
+	require.NoError(t, err)
+	fooClient := redis.NewClient(fooClientOpts)
+	defer fooClient.Close()
+	barClientOpts, err := redis.ParseURL(bar.GetURI())
+	require.NoError(t, err)
+	barClient := redis.NewClient(barClientOpts)
+	defer barClient.Close()
+	require.NoError(t, fooClient.Set(ctx, "key", "value", 0).Err())
+	require.NoError(t, fooClient.Set(ctx, "greeting", "changed", 0).Err())
+	require.NoError(t, barClient.Set(ctx, "key", "value", 0).Err())
+
+	// only the keys of the instance are gone, the seeds are applied again and the credentials are kept
+	reset, err := resetter.ResetInstance(ctx, "foo")
+	require.NoError(t, err)
+	require.Equal(t, foo.GetURI(), reset.GetURI())
+	require.Zero(t, fooClient.Exists(ctx, "key").Val())
+	require.Equal(t, "hello", fooClient.Get(ctx, "greeting").Val())
+	require.Equal(t, "value", barClient.Get(ctx, "key").Val())
+	require.NoError(t, fooClient.Set(ctx, "key", "value", 0).Err())
+
+	// a reset whose seed fails leaves the instance empty and without seeds
+	badDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(badDir, "01_bad.redis"), []byte("SET greeting hello\nINCR greeting\n"), 0o644))
+	badAdapter, err := New(uri, WithSeedDir(badDir))
+	require.NoError(t, err)
+	defer badAdapter.Close()
+	_, err = badAdapter.(adapterpkg.Resetter).ResetInstance(ctx, "foo")
+	require.ErrorContains(t, err, "01_bad.redis")
+	require.Zero(t, fooClient.Exists(ctx, "key", "greeting").Val())
+	foo, err = adapter.GetInstance(ctx, "foo")
+	require.NoError(t, err)
+	require.Empty(t, foo.GetJSON().(InstanceResponse).Seeds)
+	require.Equal(t, adapterpkg.StatusReady, foo.GetJSON().(InstanceResponse).Status)
+}
+
+func TestParseCommands(t *testing.T) {
+	commands, err := parseCommands("# fixtures\nSET greeting \"hello world\"\n\n  HSET user:1 name 'John Doe' note \"say \\\"hi\\\"\"\n")
+	require.NoError(t, err)
+	require.Equal(t, [][]any{
+		{"SET", "greeting", "hello world"},
+		{"HSET", "user:1", "name", "John Doe", "note", `say "hi"`},
+	}, commands)
+
+	_, err = parseCommands("SET key \"value")
+	require.ErrorContains(t, err, "line 1")
+}
+
+func TestDragonflyAdapterSeeds(t *testing.T) {
+	ctx := context.Background()
+	container, uri, _ := startDragonflyContainer(t)
+	defer container.Terminate(ctx)
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "01_keys.redis"), []byte("SET greeting \"hello world\"\nRPUSH queue a b\n"), 0o644))
+	adapter, err := New(uri, WithSeedDir(dir))
+	require.NoError(t, err)
+	defer adapter.Close()
+
+	foo, err := adapter.GetOrCreateInstance(ctx, "foo")
+	require.NoError(t, err)
+	require.Equal(t, []string{"01_keys.redis"}, foo.GetJSON().(InstanceResponse).Seeds)
+	fooClientOpts, err := redis.ParseURL(foo.GetURI())
+	require.NoError(t, err)
+	fooClient := redis.NewClient(fooClientOpts)
+	defer fooClient.Close()
+	require.Equal(t, "hello world", fooClient.Get(ctx, "greeting").Val())
+	require.Equal(t, int64(2), fooClient.LLen(ctx, "queue").Val())
+
+	// a failing command fails the instance
+	badDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(badDir, "01_bad.redis"), []byte("SET key value\nCONFIG SET maxmemory 1\n"), 0o644))
+	badAdapter, err := New(uri, WithSeedDir(badDir))
+	require.NoError(t, err)
+	defer badAdapter.Close()
+	_, err = badAdapter.CreateInstance(ctx, "bar", adapterpkg.CreateOptions{})
+	require.ErrorContains(t, err, "01_bad.redis")
+	bar, err := badAdapter.GetInstance(ctx, "bar")
+	require.NoError(t, err)
+	require.Equal(t, adapterpkg.StatusFailed, bar.GetJSON().(InstanceResponse).Status)
+
+	_, err = New(uri, WithSeedDir(dir), WithIsolation(KeyPrefixIsolation))
+	require.Error(t, err)
+}
+
+func TestRedisKeyPrefixIsolation(t *testing.T) {
+	ctx := context.Background()
+	container, uri, _ := startRedisContainer(t)
+	defer container.Terminate(ctx)
+
+	adapter, err := New(uri, WithIsolation(KeyPrefixIsolation), WithPlans(map[string]Plan{
+		"cache": {KeyPatterns: []string{"~cache:*"}},
+	}))
+	require.NoError(t, err)
+	defer adapter.Close()
+
+	foo, err := adapter.GetOrCreateInstance(ctx, "foo")
+	require.NoError(t, err)
+	bar, err := adapter.GetOrCreateInstance(ctx, "bar")
+	require.NoError(t, err)
+	fooResp := foo.GetJSON().(InstanceResponse)
+	require.Equal(t, "ns_foo:", fooResp.KeyPrefix)
+	require.Empty(t, fooResp.Namespace)
+	require.True(t, strings.HasSuffix(foo.GetURI(), "/0#ns_foo:"))
+
+	fooClientOpts, err := redis.ParseURL(foo.GetURI())
+	require.NoError(t, err)
+	fooClient := redis.NewClient(fooClientOpts)
+	defer fooClient.Close()
+	barClientOpts, err := redis.ParseURL(bar.GetURI())
+	require.NoError(t, err)
+	barClient := redis.NewClient(barClientOpts)
+	defer barClient.Close()
+
+	// Users are limited to the keys and channels under their prefix
+	require.NoError(t, fooClient.Set(ctx, "ns_foo:key", "foo-value", 0).Err())
+	require.Error(t, fooClient.Set(ctx, "key", "foo-value", 0).Err())
+	require.Error(t, barClient.Get(ctx, "ns_foo:key").Err())
+	require.Error(t, fooClient.Get(ctx, "instance:foo").Err())
+	require.NoError(t, fooClient.Publish(ctx, "ns_foo:events", "hello").Err())
+	require.Error(t, fooClient.Publish(ctx, "ns_bar:events", "hello").Err())
+	require.Error(t, fooClient.Do(ctx, "SELECT", "1").Err())
+
+	// Commands without key arguments can't wipe or list the keys of other instances
+	require.NoError(t, barClient.Set(ctx, "ns_bar:key", "bar-value", 0).Err())
+	require.Error(t, fooClient.FlushAll(ctx).Err())
+	require.Error(t, fooClient.FlushDB(ctx).Err())
+	require.Error(t, fooClient.Do(ctx, "SWAPDB", "0", "1").Err())
+	require.Error(t, fooClient.Keys(ctx, "*").Err())
+	require.Error(t, fooClient.Scan(ctx, 0, "*", 100).Err())
+	require.Error(t, fooClient.RandomKey(ctx).Err())
+	require.Equal(t, "bar-value", barClient.Get(ctx, "ns_bar:key").Val())
+
+	// Plan key patterns are applied under the prefix
+	baz, err := adapter.CreateInstance(ctx, "baz", adapterpkg.CreateOptions{Plan: "cache"})
+	require.NoError(t, err)
+	bazClientOpts, err := redis.ParseURL(baz.GetURI())
+	require.NoError(t, err)
+	bazClient := redis.NewClient(bazClientOpts)
+	defer bazClient.Close()
+	require.NoError(t, bazClient.Set(ctx, "ns_baz:cache:key", "value", 0).Err())
+	require.Error(t, bazClient.Set(ctx, "ns_baz:other", "value", 0).Err())
+
+	_, err = adapter.CreateInstance(ctx, "qux", adapterpkg.CreateOptions{Params: json.RawMessage(`{"database":1}`)})
+	require.ErrorIs(t, err, adapterpkg.ErrInvalidParams)
+
+	// Deletion removes the keys under the prefix only
+	adminOpts, err := redis.ParseURL(uri)
+	require.NoError(t, err)
+	adminClient := redis.NewClient(adminOpts)
+	defer adminClient.Close()
+	require.NoError(t, barClient.Set(ctx, "ns_bar:key", "bar-value", 0).Err())
+	require.NoError(t, adapter.DeleteInstance(ctx, "foo"))
+	require.Equal(t, int64(0), adminClient.Exists(ctx, "ns_foo:key").Val())
+	require.Equal(t, int64(1), adminClient.Exists(ctx, "ns_bar:key").Val())
+	require.Error(t, fooClient.Ping(ctx).Err())
+
+	_, err = New(uri, WithIsolation("database"))
+	require.Error(t, err)
+}
+
+func TestRedisKeyPrefixCloneInstance(t *testing.T) {
+	ctx := context.Background()
+	container, uri, _ := startRedisContainer(t)
+	defer container.Terminate(ctx)
+
+	adapter, err := New(uri, WithIsolation(KeyPrefixIsolation))
+	require.NoError(t, err)
+	defer adapter.Close()
+
+	foo, err := adapter.GetOrCreateInstance(ctx, "foo")
+	require.NoError(t, err)
+	fooClientOpts, err := redis.ParseURL(foo.GetURI())
+	require.NoError(t, err)
+	fooClient := redis.NewClient(fooClientOpts)
+	defer fooClient.Close()
+	require.NoError(t, fooClient.Set(ctx, "ns_foo:key", "value", 0).Err())
+
+	bar, err := adapter.(adapterpkg.Cloner).CloneInstance(ctx, "foo", "bar")
+	require.NoError(t, err)
+	barClientOpts, err := redis.ParseURL(bar.GetURI())
+	require.NoError(t, err)
+	barClient := redis.NewClient(barClientOpts)
+	defer barClient.Close()
+	require.Equal(t, "value", barClient.Get(ctx, "ns_bar:key").Val())
+}
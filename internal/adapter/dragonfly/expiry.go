@@ -0,0 +1,64 @@
+package dragonfly
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/razzie-cloud/database-broker/internal/adapter"
+)
+
+var _ adapter.Leaser = (*dragonflyAdapter)(nil)
+
+// DeleteExpiredInstances leaves the instances that are still provisioning for the next run,
+// as deleting their record would let the creation fail halfway
+func (d *dragonflyAdapter) DeleteExpiredInstances(ctx context.Context, now time.Time) ([]string, error) {
+	instanceNames, err := d.GetInstances(ctx)
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(instanceNames)
+	var deleted []string
+	var errs []error
+	for _, instanceName := range instanceNames {
+		instance, err := d.getInstance(ctx, instanceName)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if instance == nil || !instance.isExpired(now) || instance.status() == adapter.StatusProvisioning {
+			continue
+		}
+		err = d.DeleteInstance(ctx, instanceName)
+		if err == adapter.ErrInstanceNotFound {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("delete expired instance %s: %w", instanceName, err))
+			continue
+		}
+		deleted = append(deleted, instanceName)
+	}
+	return deleted, errors.Join(errs...)
+}
+
+// RenewLease goes through updateInstance, so a concurrent credential rotation doesn't get overwritten
+// with the previous password, and the renewed expiry doesn't get lost to the rotation either
+func (d *dragonflyAdapter) RenewLease(ctx context.Context, instanceName, leaseID string) (adapter.Instance, error) {
+	instance, err := d.updateInstance(ctx, instanceName, func(instance *Instance) error {
+		now := time.Now().UTC()
+		// an expired lease can't be renewed, as the instance may be getting deleted already
+		if instance.LeaseID == "" || instance.LeaseID != leaseID || instance.isExpired(now) {
+			return fmt.Errorf("%w: %s", adapter.ErrInvalidLease, leaseID)
+		}
+		expiresAt := now.Add(instance.LeasePeriod)
+		instance.ExpiresAt = &expiresAt
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return instance, nil
+}
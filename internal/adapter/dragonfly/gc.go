@@ -0,0 +1,40 @@
+package dragonfly
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/razzie-cloud/database-broker/internal/adapter"
+)
+
+var _ adapter.GarbageCollector = (*dragonflyAdapter)(nil)
+
+// DropOrphans deletes the given ACL users if no instance record refers to them.
+// Dragonfly has no databases of its own to drop, as namespaces disappear with their keys.
+func (d *dragonflyAdapter) DropOrphans(ctx context.Context, databases, users []string) error {
+	var errs []error
+	for _, name := range databases {
+		errs = append(errs, fmt.Errorf("drop %s: not an instance database", name))
+	}
+	for _, name := range users {
+		instanceName, ok := strings.CutPrefix(name, "user_")
+		if !ok {
+			errs = append(errs, fmt.Errorf("drop %s: not an instance user", name))
+			continue
+		}
+		instance, err := d.getInstance(ctx, instanceName)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("drop %s: %w", name, err))
+			continue
+		}
+		if instance != nil && instance.Username == name {
+			continue
+		}
+		if err := d.deleteUser(ctx, name); err != nil {
+			errs = append(errs, fmt.Errorf("drop %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -13,46 +13,147 @@ import (
 var _ adapter.Instance = (*Instance)(nil)
 
 type Instance struct {
-	Instance  string    `json:"-"`
-	Host      string    `json:"-"`
-	Port      int       `json:"-"`
-	Namespace string    `json:"namespace"`
-	Username  string    `json:"username"`
-	Password  string    `json:"password"`
-	URI       string    `json:"uri"`
-	CreatedAt time.Time `json:"created_at"`
+	Instance          string         `json:"-"`
+	Host              string         `json:"-"`
+	Port              int            `json:"-"`
+	Namespace         string         `json:"namespace"`
+	KeyPrefix         string         `json:"key_prefix,omitempty"`
+	Username          string         `json:"username"`
+	Password          string         `json:"password"`
+	ACLProfile        string         `json:"acl_profile"`
+	Database          int            `json:"database"`
+	Plan              string         `json:"plan,omitempty"`
+	PreviousPassword  string         `json:"previous_password,omitempty"`
+	PreviousExpiresAt *time.Time     `json:"previous_expires_at,omitempty"`
+	URI               string         `json:"uri"`
+	Status            adapter.Status `json:"status,omitempty"`
+	ExpiresAt         *time.Time     `json:"expires_at,omitempty"`
+	LeaseID           string         `json:"lease_id,omitempty"`
+	LeasePeriod       time.Duration  `json:"lease_period,omitempty"`
+	Seeds             []string       `json:"seeds,omitempty"`
+	CreatedAt         time.Time      `json:"created_at"`
 }
 
 func (i Instance) GetURI() string {
-	return buildConnURI(i.Host, i.Port, i.Username, i.Password)
+	return buildConnURI(i.Host, i.Port, i.Username, i.Password, i.Database, i.KeyPrefix)
 }
 
 func (i Instance) GetJSON() any {
 	return InstanceResponse{
-		Instance:  i.Instance,
-		Host:      i.Host,
-		Port:      i.Port,
-		Namespace: i.Namespace,
+		Instance:           i.Instance,
+		Host:               i.Host,
+		Port:               i.Port,
+		Namespace:          i.Namespace,
+		KeyPrefix:          i.KeyPrefix,
+		Username:           i.Username,
+		Password:           i.Password,
+		ACLProfile:         i.ACLProfile,
+		Database:           i.Database,
+		URI:                i.GetURI(),
+		PreviousCredential: i.previousCredential(),
+		Plan:               i.Plan,
+		Status:             i.status(),
+		ExpiresAt:          i.ExpiresAt,
+		Lease:              i.lease(),
+		Seeds:              i.Seeds,
+		CreatedAt:          i.CreatedAt,
+	}
+}
+
+// setCredentials takes over the passwords of the other record of the instance
+func (i *Instance) setCredentials(other *Instance) {
+	i.Password = other.Password
+	i.PreviousPassword = other.PreviousPassword
+	i.PreviousExpiresAt = other.PreviousExpiresAt
+}
+
+// aclProfile returns the instance's ACL profile, records created before profiles existed use the default one
+func (i Instance) aclProfile() string {
+	if i.ACLProfile == "" {
+		return defaultACLProfile
+	}
+	return i.ACLProfile
+}
+
+// status returns the instance's provisioning status, records created before statuses existed are ready
+func (i Instance) status() adapter.Status {
+	if i.Status == "" {
+		return adapter.StatusReady
+	}
+	return i.Status
+}
+
+// interrupted reports whether the instance has been provisioning for longer than a creation takes,
+// which means its creator is gone, e.g. because it crashed
+func (i Instance) interrupted() bool {
+	return i.status() == adapter.StatusProvisioning && time.Since(i.CreatedAt) > provisioningTimeout
+}
+
+func (i Instance) isExpired(now time.Time) bool {
+	return i.ExpiresAt != nil && !i.ExpiresAt.After(now)
+}
+
+func (i Instance) lease() *LeaseResponse {
+	if i.LeaseID == "" {
+		return nil
+	}
+	return &LeaseResponse{
+		ID:     i.LeaseID,
+		Period: i.LeasePeriod.String(),
+	}
+}
+
+func (i Instance) previousCredential() *CredentialResponse {
+	if i.PreviousPassword == "" || i.PreviousExpiresAt == nil || !i.PreviousExpiresAt.After(time.Now()) {
+		return nil
+	}
+	return &CredentialResponse{
 		Username:  i.Username,
-		Password:  i.Password,
-		URI:       i.GetURI(),
-		CreatedAt: i.CreatedAt,
+		Password:  i.PreviousPassword,
+		URI:       buildConnURI(i.Host, i.Port, i.Username, i.PreviousPassword, i.Database, i.KeyPrefix),
+		ExpiresAt: *i.PreviousExpiresAt,
 	}
 }
 
 type InstanceResponse struct {
-	Instance  string    `json:"instance"`
-	Host      string    `json:"host"`
-	Port      int       `json:"port"`
-	Namespace string    `json:"namespace"`
+	Instance           string              `json:"instance"`
+	Host               string              `json:"host"`
+	Port               int                 `json:"port"`
+	Namespace          string              `json:"namespace,omitempty"`
+	KeyPrefix          string              `json:"key_prefix,omitempty"`
+	Username           string              `json:"username"`
+	Password           string              `json:"password"`
+	ACLProfile         string              `json:"acl_profile"`
+	Database           int                 `json:"database"`
+	URI                string              `json:"uri"`
+	PreviousCredential *CredentialResponse `json:"previous_credential,omitempty"`
+	Plan               string              `json:"plan,omitempty"`
+	Status             adapter.Status      `json:"status"`
+	ExpiresAt          *time.Time          `json:"expires_at,omitempty"`
+	Lease              *LeaseResponse      `json:"lease,omitempty"`
+	Seeds              []string            `json:"seeds,omitempty"`
+	CreatedAt          time.Time           `json:"created_at"`
+}
+
+type CredentialResponse struct {
 	Username  string    `json:"username"`
 	Password  string    `json:"password"`
 	URI       string    `json:"uri"`
-	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
 }
 
-func buildConnURI(host string, port int, user, pass string) string {
+type LeaseResponse struct {
+	ID     string `json:"id"`
+	Period string `json:"period"`
+}
+
+// buildConnURI puts the key prefix into the URI fragment, as clients ignore it but reject unknown query parameters
+func buildConnURI(host string, port int, user, pass string, db int, keyPrefix string) string {
 	userpass := url.UserPassword(user, pass).String()
 	addr := net.JoinHostPort(host, strconv.Itoa(port))
-	return fmt.Sprintf("redis://%s@%s/0", userpass, addr)
+	uri := fmt.Sprintf("redis://%s@%s/%d", userpass, addr, db)
+	if keyPrefix != "" {
+		uri += "#" + keyPrefix
+	}
+	return uri
 }
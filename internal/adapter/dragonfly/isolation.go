@@ -0,0 +1,76 @@
+package dragonfly
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Isolation selects how the adapter keeps the data of instances apart.
+// There is no mode with a logical database per instance, because ACLs can't restrict users to a database,
+// so every user could read the other instances and the instance records.
+type Isolation string
+
+const (
+	// NamespaceIsolation gives each user its own keyspace with Dragonfly's NAMESPACE ACL extension
+	NamespaceIsolation Isolation = "namespace"
+	// KeyPrefixIsolation limits each user to the keys and channels under its instance's prefix.
+	// Works with stock Redis and Valkey.
+	KeyPrefixIsolation Isolation = "prefix"
+)
+
+// keyPrefixRules keep users in the database their keys get deleted from. Key patterns don't restrict commands
+// without key arguments, so the ones that wipe or list the keys of other instances are denied too.
+var keyPrefixRules = []string{"-SELECT", "-MOVE", "-@dangerous", "-FLUSHALL", "-FLUSHDB", "-SWAPDB", "-KEYS", "-SCAN", "-RANDOMKEY"}
+
+func (i Isolation) validate() error {
+	switch i {
+	case NamespaceIsolation, KeyPrefixIsolation:
+		return nil
+	default:
+		return fmt.Errorf("unknown isolation: %s", i)
+	}
+}
+
+// userSelectors returns the ACL rules that limit the user to the keys and channels of the instance
+func (d *dragonflyAdapter) userSelectors(instance *Instance) []string {
+	patterns := d.userKeyPatterns(instance)
+	if instance.KeyPrefix == "" {
+		return patterns
+	}
+	prefixed := make([]string, 0, len(patterns)+2)
+	for _, pattern := range patterns {
+		// the pattern may start with a permission selector like %R~
+		i := strings.IndexByte(pattern, '~')
+		prefixed = append(prefixed, pattern[:i+1]+instance.KeyPrefix+pattern[i+1:])
+	}
+	return append(prefixed, "resetchannels", "&"+instance.KeyPrefix+"*")
+}
+
+// deleteKeyPrefix deletes the keys of the instance from its database as the admin user
+func (d *dragonflyAdapter) deleteKeyPrefix(ctx context.Context, instance *Instance) error {
+	opts := d.opts
+	opts.DB = instance.Database
+	client := redis.NewClient(&opts)
+	defer client.Close()
+	iter := client.Scan(ctx, 0, instance.KeyPrefix+"*", 100).Iterator()
+	var keys []string
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+		if len(keys) == 100 {
+			if err := client.Unlink(ctx, keys...).Err(); err != nil {
+				return err
+			}
+			keys = keys[:0]
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	if len(keys) > 0 {
+		return client.Unlink(ctx, keys...).Err()
+	}
+	return nil
+}
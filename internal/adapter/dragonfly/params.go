@@ -0,0 +1,37 @@
+package dragonfly
+
+import (
+	"fmt"
+
+	"github.com/razzie-cloud/database-broker/internal/adapter"
+)
+
+const defaultACLProfile = "default"
+
+// aclProfiles maps the selectable profiles to the command rules of the instance user
+var aclProfiles = map[string][]string{
+	"default":    {"+@all", "-@admin", "-ACL", "-CONFIG", "-MODULE", "-CLUSTER"},
+	"restricted": {"+@all", "-@admin", "-@dangerous", "-ACL", "-CONFIG", "-MODULE", "-CLUSTER"},
+}
+
+type CreateParams struct {
+	ACLProfile string `json:"acl_profile,omitempty"`
+	Database   int    `json:"database,omitempty"`
+}
+
+func (p CreateParams) validate() error {
+	if _, ok := aclProfiles[p.aclProfile()]; !ok {
+		return fmt.Errorf("%w: unknown acl profile: %s", adapter.ErrInvalidParams, p.ACLProfile)
+	}
+	if p.Database < 0 {
+		return fmt.Errorf("%w: invalid database: %d", adapter.ErrInvalidParams, p.Database)
+	}
+	return nil
+}
+
+func (p CreateParams) aclProfile() string {
+	if p.ACLProfile == "" {
+		return defaultACLProfile
+	}
+	return p.ACLProfile
+}
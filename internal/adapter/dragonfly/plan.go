@@ -0,0 +1,29 @@
+package dragonfly
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Plan describes the extra ACL rules applied to the user of an instance.
+// Dragonfly has no per user memory limits, so plans can only restrict commands and keys.
+type Plan struct {
+	// Commands are ACL command rules applied after the ACL profile, e.g. "-@dangerous" or "-KEYS"
+	Commands []string `json:"commands,omitempty"`
+	// KeyPatterns are ACL key patterns the user is limited to, e.g. "~cache:*" (default: "~*")
+	KeyPatterns []string `json:"key_patterns,omitempty"`
+}
+
+func (p Plan) validate() error {
+	for _, rule := range p.Commands {
+		if len(rule) < 2 || (rule[0] != '+' && rule[0] != '-') || strings.ContainsAny(rule, " \t") {
+			return fmt.Errorf("invalid command rule: %s", rule)
+		}
+	}
+	for _, pattern := range p.KeyPatterns {
+		if len(pattern) < 2 || (pattern[0] != '~' && pattern[0] != '%') || strings.ContainsAny(pattern, " \t") {
+			return fmt.Errorf("invalid key pattern: %s", pattern)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package dragonfly
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/razzie-cloud/database-broker/internal/adapter"
+)
+
+const defaultReconcileInterval = 5 * time.Minute
+
+func (d *dragonflyAdapter) reconcileUsersLoop() {
+	ticker := time.NewTicker(d.reconcile)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C:
+			d.logReconcileUsers()
+		}
+	}
+}
+
+func (d *dragonflyAdapter) logReconcileUsers() {
+	restored, err := d.reconcileUsers(context.Background())
+	if len(restored) > 0 {
+		log.Printf("restored dragonfly users: %s", strings.Join(restored, ", "))
+	}
+	if err != nil {
+		log.Printf("reconcile dragonfly users: %v", err)
+	}
+}
+
+// reconcileUsers recreates the users of ready instances that are missing from the server, e.g. after a restart
+// without an ACL file, and returns their names. The previous password is restored too while its grace period lasts.
+func (d *dragonflyAdapter) reconcileUsers(ctx context.Context) ([]string, error) {
+	users, err := d.client.Do(ctx, "ACL", "USERS").StringSlice()
+	if err != nil {
+		return nil, fmt.Errorf("list users: %w", err)
+	}
+	existing := make(map[string]bool, len(users))
+	for _, user := range users {
+		existing[user] = true
+	}
+	instanceNames, err := d.GetInstances(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var restored []string
+	for _, instanceName := range instanceNames {
+		instance, err := d.getInstance(ctx, instanceName)
+		if err != nil {
+			return restored, err
+		}
+		// instances that aren't ready get their user on the next creation attempt
+		if instance == nil || instance.status() != adapter.StatusReady || existing[instance.Username] {
+			continue
+		}
+		if err := d.createUser(ctx, instance); err != nil {
+			return restored, fmt.Errorf("restore user of %s: %w", instanceName, err)
+		}
+		if instance.previousCredential() != nil {
+			if err := d.addUserPassword(ctx, instance.Username, instance.PreviousPassword); err != nil {
+				return restored, fmt.Errorf("restore previous password of %s: %w", instanceName, err)
+			}
+		}
+		restored = append(restored, instance.Username)
+	}
+	return restored, nil
+}
+
+var _ adapter.DriftDetector = (*dragonflyAdapter)(nil)
+
+// DetectDrift compares the instance records with the ACL users named like the ones of instances.
+// Repair restores the missing users the same way the periodic reconciliation does.
+func (d *dragonflyAdapter) DetectDrift(ctx context.Context, repair bool) (*adapter.DriftReport, error) {
+	report := &adapter.DriftReport{}
+	if repair {
+		restored, err := d.reconcileUsers(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, user := range restored {
+			report.Repaired = append(report.Repaired, strings.TrimPrefix(user, "user_"))
+		}
+	}
+	users, err := d.client.Do(ctx, "ACL", "USERS").StringSlice()
+	if err != nil {
+		return nil, fmt.Errorf("list users: %w", err)
+	}
+	unclaimed := map[string]bool{}
+	for _, user := range users {
+		if strings.HasPrefix(user, "user_") {
+			unclaimed[user] = true
+		}
+	}
+	instanceNames, err := d.GetInstances(ctx)
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(instanceNames)
+	for _, instanceName := range instanceNames {
+		instance, err := d.getInstance(ctx, instanceName)
+		if err != nil {
+			return nil, err
+		}
+		if instance == nil {
+			continue
+		}
+		if !unclaimed[instance.Username] && instance.status() == adapter.StatusReady {
+			report.MissingUsers = append(report.MissingUsers, instanceName)
+		}
+		delete(unclaimed, instance.Username)
+	}
+	report.OrphanedUsers = slices.Sorted(maps.Keys(unclaimed))
+	return report, nil
+}
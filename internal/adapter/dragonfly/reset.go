@@ -0,0 +1,50 @@
+package dragonfly
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/razzie-cloud/database-broker/internal/adapter"
+)
+
+var _ adapter.Resetter = (*dragonflyAdapter)(nil)
+
+// ResetInstance deletes the keys of the instance like its deletion does, then applies the seeds again.
+// The user is kept as it is. The seeds are cleared from the record before the keys are deleted,
+// so a reset that fails halfway doesn't leave them listed for an empty namespace.
+func (d *dragonflyAdapter) ResetInstance(ctx context.Context, instanceName string) (adapter.Instance, error) {
+	instance, err := d.getInstance(ctx, instanceName)
+	if err != nil {
+		return nil, err
+	}
+	if instance == nil {
+		return nil, adapter.ErrInstanceNotFound
+	}
+	if instance.status() != adapter.StatusReady {
+		return nil, fmt.Errorf("%w: %s is %s", adapter.ErrInstanceNotReady, instanceName, instance.status())
+	}
+	instance, err = d.updateInstance(ctx, instanceName, func(current *Instance) error {
+		current.Seeds = nil
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := d.deleteKeys(ctx, instance); err != nil {
+		return nil, fmt.Errorf("delete instance keys: %w", err)
+	}
+	if len(d.seeds) > 0 {
+		// a failed seed deletes the keys written so far, so the record keeps listing no seeds
+		if err := d.seedInstance(ctx, instance); err != nil {
+			return nil, err
+		}
+		instance, err = d.updateInstance(ctx, instanceName, func(current *Instance) error {
+			current.Seeds = instance.Seeds
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return instance, nil
+}
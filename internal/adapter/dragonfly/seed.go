@@ -0,0 +1,103 @@
+package dragonfly
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/razzie-cloud/database-broker/internal/adapter"
+)
+
+type seed struct {
+	name     string
+	commands [][]any
+}
+
+func parseSeeds(seeds []adapter.Seed) ([]seed, error) {
+	parsed := make([]seed, 0, len(seeds))
+	for _, s := range seeds {
+		commands, err := parseCommands(s.Data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid seed %s: %w", s.Name, err)
+		}
+		parsed = append(parsed, seed{name: s.Name, commands: commands})
+	}
+	return parsed, nil
+}
+
+// parseCommands splits a seed file into commands, one per line. Empty lines and lines starting with # are skipped.
+// Arguments are separated by whitespace and can be quoted, double quoted ones support backslash escapes.
+func parseCommands(data string) ([][]any, error) {
+	var commands [][]any
+	for i, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		args, err := splitArgs(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		commands = append(commands, args)
+	}
+	return commands, nil
+}
+
+func splitArgs(line string) ([]any, error) {
+	var args []any
+	var arg strings.Builder
+	var quote rune
+	inArg, escaped := false, false
+	for _, r := range line {
+		switch {
+		case escaped:
+			arg.WriteRune(r)
+			escaped = false
+		case quote == '"' && r == '\\':
+			escaped = true
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			arg.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// seedInstance runs the commands of the seed files as the instance user.
+// If a command fails, the keys written so far are deleted.
+func (d *dragonflyAdapter) seedInstance(ctx context.Context, instance *Instance) error {
+	client := d.userClient(instance)
+	defer client.Close()
+	for _, s := range d.seeds {
+		for _, command := range s.commands {
+			if err := client.Do(ctx, command...).Err(); err != nil {
+				if cleanupErr := d.flushNamespace(context.WithoutCancel(ctx), instance); cleanupErr != nil {
+					log.Printf("cleanup dragonfly instance %s: %v", instance.Instance, cleanupErr)
+				}
+				return fmt.Errorf("apply seed %s: %w", s.name, err)
+			}
+		}
+		instance.Seeds = append(instance.Seeds, s.name)
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package adapter
+
+import "context"
+
+// DriftReport lists the differences between the instance records of an adapter and its server
+type DriftReport struct {
+	// OrphanedDatabases are databases, schemas or namespaces named like the ones of instances, that belong to none
+	OrphanedDatabases []string `json:"orphaned_databases,omitempty"`
+	// OrphanedUsers are users named like the ones of instances, that belong to none
+	OrphanedUsers []string `json:"orphaned_users,omitempty"`
+	// MissingDatabases are the ready instances whose database or schema doesn't exist
+	MissingDatabases []string `json:"missing_databases,omitempty"`
+	// MissingUsers are the ready instances with a user that doesn't exist
+	MissingUsers []string `json:"missing_users,omitempty"`
+	// Repaired are the instances whose missing resources got restored, they're not listed as missing anymore
+	Repaired []string `json:"repaired,omitempty"`
+}
+
+// HasDrift reports whether anything is still out of sync
+func (r *DriftReport) HasDrift() bool {
+	return len(r.OrphanedDatabases) > 0 || len(r.OrphanedUsers) > 0 || len(r.MissingDatabases) > 0 || len(r.MissingUsers) > 0
+}
+
+// DriftDetector is implemented by the adapters that can compare their instance records with the server.
+// With repair, the missing resources that can be restored without data loss get recreated.
+type DriftDetector interface {
+	DetectDrift(ctx context.Context, repair bool) (*DriftReport, error)
+}
+
+// GarbageCollector is implemented by the adapters that can drop the orphaned resources found by drift detection.
+// Resources that got claimed by an instance in the meantime are skipped.
+type GarbageCollector interface {
+	DriftDetector
+	DropOrphans(ctx context.Context, databases, users []string) error
+}
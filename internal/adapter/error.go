@@ -0,0 +1,18 @@
+package adapter
+
+import "errors"
+
+var (
+	ErrInstanceNotFound = errors.New("instance not found")
+	ErrInstanceExists   = errors.New("instance already exists")
+	ErrInvalidParams    = errors.New("invalid params")
+	// ErrConflict means an existing resource the adapter didn't create is in the way of an instance
+	ErrConflict = errors.New("conflicting resource")
+	// ErrInstanceNotReady means the instance is still provisioning or its provisioning failed
+	ErrInstanceNotReady = errors.New("instance not ready")
+	// ErrInvalidLease means the instance has no lease with the given ID, or the lease has already expired
+	ErrInvalidLease = errors.New("invalid lease")
+
+	ErrTemplateNotFound = errors.New("template not found")
+	ErrTemplateExists   = errors.New("template already exists")
+)
@@ -0,0 +1,20 @@
+package adapter
+
+import (
+	"context"
+	"time"
+)
+
+// Expirer is implemented by the adapters that support instances with a time-to-live.
+// DeleteExpiredInstances deletes the instances that expired by now and returns their names.
+// An instance that fails to be deleted doesn't stop the others from being deleted.
+type Expirer interface {
+	DeleteExpiredInstances(ctx context.Context, now time.Time) ([]string, error)
+}
+
+// Leaser is implemented by the adapters that support instances with a lease.
+// Renewing a lease moves the expiry of its instance a lease period ahead of now.
+type Leaser interface {
+	Expirer
+	RenewLease(ctx context.Context, instanceName, leaseID string) (Instance, error)
+}
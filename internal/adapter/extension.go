@@ -0,0 +1,9 @@
+package adapter
+
+import "context"
+
+// ExtensionInstaller is implemented by the adapters that can install database extensions into existing instances.
+// Only the extensions allowed by the adapter's configuration can be installed.
+type ExtensionInstaller interface {
+	InstallExtensions(ctx context.Context, instanceName string, extensions []string) (Instance, error)
+}
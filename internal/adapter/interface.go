@@ -1,14 +1,40 @@
 package adapter
 
-import "context"
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
 
 type Instance interface {
 	GetJSON() any
 	GetURI() string
 }
 
+type CreateOptions struct {
+	// Plan selects one of the provisioning plans configured for the adapter
+	Plan string
+	// Params holds the adapter specific settings of the new instance in JSON format
+	Params json.RawMessage
+	// TTL makes the instance expire this long after its creation, zero means never.
+	// Only supported by the adapters that implement Expirer.
+	TTL time.Duration
+	// Lease makes the instance expire unless its lease gets renewed within this period, zero means no lease.
+	// Only supported by the adapters that implement Leaser.
+	Lease time.Duration
+}
+
+type RotateOptions struct {
+	// GracePeriod keeps the previous credential valid for this long after rotation
+	GracePeriod time.Duration
+}
+
 type Interface interface {
 	GetInstances(ctx context.Context) ([]string, error)
+	GetInstance(ctx context.Context, instanceName string) (Instance, error)
 	GetOrCreateInstance(ctx context.Context, instanceName string) (Instance, error)
+	CreateInstance(ctx context.Context, instanceName string, opts CreateOptions) (Instance, error)
+	DeleteInstance(ctx context.Context, instanceName string) error
+	RotateCredentials(ctx context.Context, instanceName string, opts RotateOptions) (Instance, error)
 	Close() error
 }
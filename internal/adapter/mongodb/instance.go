@@ -0,0 +1,107 @@
+package mongodb
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/razzie-cloud/database-broker/internal/adapter"
+)
+
+var _ adapter.Instance = (*Instance)(nil)
+
+type Instance struct {
+	InstanceName      string         `bson:"_id"`
+	Host              string         `bson:"-"`
+	Port              int            `bson:"-"`
+	Database          string         `bson:"db_name"`
+	Username          string         `bson:"db_user"`
+	Password          string         `bson:"db_password"`
+	PreviousUsername  string         `bson:"prev_db_user"`
+	PreviousPassword  string         `bson:"prev_db_password"`
+	PreviousExpiresAt *time.Time     `bson:"prev_expires_at"`
+	Status            adapter.Status `bson:"status"`
+	CreatedAt         time.Time      `bson:"created_at"`
+}
+
+func (i Instance) GetURI() string {
+	return buildConnURI(i.Host, i.Port, i.Database, i.Username, i.Password)
+}
+
+func (i Instance) GetJSON() any {
+	return InstanceResponse{
+		Instance:           i.InstanceName,
+		Host:               i.Host,
+		Port:               i.Port,
+		Database:           i.Database,
+		Username:           i.Username,
+		Password:           i.Password,
+		URI:                i.GetURI(),
+		PreviousCredential: i.previousCredential(),
+		Status:             i.status(),
+		CreatedAt:          i.CreatedAt,
+	}
+}
+
+// users returns every user that belongs to the instance
+func (i Instance) users() []string {
+	if i.PreviousUsername == "" {
+		return []string{i.Username}
+	}
+	return []string{i.Username, i.PreviousUsername}
+}
+
+// status returns the instance's provisioning status, records created before statuses existed are ready
+func (i Instance) status() adapter.Status {
+	if i.Status == "" {
+		return adapter.StatusReady
+	}
+	return i.Status
+}
+
+// interrupted reports whether the instance has been provisioning for longer than a creation takes,
+// which means its creator is gone, e.g. because it crashed
+func (i Instance) interrupted() bool {
+	return i.status() == adapter.StatusProvisioning && time.Since(i.CreatedAt) > provisioningTimeout
+}
+
+func (i Instance) previousCredential() *CredentialResponse {
+	if i.PreviousUsername == "" || i.PreviousExpiresAt == nil || !i.PreviousExpiresAt.After(time.Now()) {
+		return nil
+	}
+	return &CredentialResponse{
+		Username:  i.PreviousUsername,
+		Password:  i.PreviousPassword,
+		URI:       buildConnURI(i.Host, i.Port, i.Database, i.PreviousUsername, i.PreviousPassword),
+		ExpiresAt: *i.PreviousExpiresAt,
+	}
+}
+
+type InstanceResponse struct {
+	Instance           string              `json:"instance"`
+	Host               string              `json:"host"`
+	Port               int                 `json:"port"`
+	Database           string              `json:"database"`
+	Username           string              `json:"username"`
+	Password           string              `json:"password"`
+	URI                string              `json:"uri"`
+	PreviousCredential *CredentialResponse `json:"previous_credential,omitempty"`
+	Status             adapter.Status      `json:"status"`
+	CreatedAt          time.Time           `json:"created_at"`
+}
+
+type CredentialResponse struct {
+	Username  string    `json:"username"`
+	Password  string    `json:"password"`
+	URI       string    `json:"uri"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// buildConnURI returns a URI that authenticates against the instance database, where its users are defined
+func buildConnURI(host string, port int, db, user, pass string) string {
+	userpass := url.UserPassword(user, pass).String()
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	return fmt.Sprintf("mongodb://%s@%s/%s?authSource=%s", userpass, addr, db, url.QueryEscape(db))
+}
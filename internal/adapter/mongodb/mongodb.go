@@ -0,0 +1,401 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/razzie-cloud/database-broker/internal/adapter"
+	"github.com/razzie-cloud/database-broker/internal/util"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	// defaultMetadataDatabase holds the instance records if the URI doesn't name a database
+	defaultMetadataDatabase  = "database_broker"
+	credentialExpiryInterval = time.Minute
+	// provisioningTimeout is how long a creation may take, a longer one got interrupted
+	provisioningTimeout      = time.Minute
+	provisioningPollInterval = 100 * time.Millisecond
+)
+
+type mongoAdapter struct {
+	client    *mongo.Client
+	instances *mongo.Collection
+	host      string
+	port      int
+	done      chan struct{}
+}
+
+func New(mongoURI string) (adapter.Interface, error) {
+	host, port, err := util.GetURIHostPort(mongoURI, 27017)
+	if err != nil {
+		return nil, fmt.Errorf("parse mongodb uri: %w", err)
+	}
+	u, err := url.Parse(mongoURI)
+	if err != nil {
+		return nil, fmt.Errorf("parse mongodb uri: %w", err)
+	}
+	metadataDatabase := strings.TrimPrefix(u.Path, "/")
+	if metadataDatabase == "" {
+		metadataDatabase = defaultMetadataDatabase
+	}
+	client, err := mongo.Connect(options.Client().ApplyURI(mongoURI))
+	if err != nil {
+		return nil, fmt.Errorf("connect mongodb: %w", err)
+	}
+	if err := client.Ping(context.Background(), nil); err != nil {
+		client.Disconnect(context.Background())
+		return nil, fmt.Errorf("connect mongodb: %w", err)
+	}
+	m := &mongoAdapter{
+		client:    client,
+		instances: client.Database(metadataDatabase).Collection("instances"),
+		host:      host,
+		port:      port,
+		done:      make(chan struct{}),
+	}
+	go m.expireCredentialsLoop()
+	return m, nil
+}
+
+func (m *mongoAdapter) Close() error {
+	close(m.done)
+	return m.client.Disconnect(context.Background())
+}
+
+func (m *mongoAdapter) GetInstances(ctx context.Context) ([]string, error) {
+	opts := options.Find().SetProjection(bson.D{{Key: "_id", Value: 1}}).SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := m.instances.Find(ctx, bson.D{}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("find instances: %w", err)
+	}
+	var instances []Instance
+	if err := cursor.All(ctx, &instances); err != nil {
+		return nil, fmt.Errorf("iterate instances: %w", err)
+	}
+	names := make([]string, len(instances))
+	for i, inst := range instances {
+		names[i] = inst.InstanceName
+	}
+	return names, nil
+}
+
+func (m *mongoAdapter) GetInstance(ctx context.Context, instanceName string) (adapter.Instance, error) {
+	instance, err := m.getInstance(ctx, instanceName)
+	if err != nil {
+		return nil, err
+	}
+	return instance, nil
+}
+
+// GetOrCreateInstance waits for a creation in progress and resumes failed or interrupted instances
+func (m *mongoAdapter) GetOrCreateInstance(ctx context.Context, instanceName string) (adapter.Instance, error) {
+	instance, err := m.waitForInstance(ctx, instanceName)
+	switch {
+	case err == adapter.ErrInstanceNotFound:
+	case err != nil:
+		return nil, err
+	case instance.status() != adapter.StatusFailed && !instance.interrupted():
+		return instance, nil
+	}
+	created, err := m.CreateInstance(ctx, instanceName, adapter.CreateOptions{})
+	if err == adapter.ErrInstanceExists {
+		instance, err = m.waitForInstance(ctx, instanceName)
+		if err != nil {
+			return nil, err
+		}
+		return instance, nil
+	}
+	return created, err
+}
+
+// waitForInstance returns the instance once it's not provisioning anymore, or its creation got interrupted
+func (m *mongoAdapter) waitForInstance(ctx context.Context, instanceName string) (*Instance, error) {
+	for {
+		instance, err := m.getInstance(ctx, instanceName)
+		if err != nil || instance.status() != adapter.StatusProvisioning || instance.interrupted() {
+			return instance, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(provisioningPollInterval):
+		}
+	}
+}
+
+// CreateInstance reserves the instance record as provisioning first, so concurrent creations can't both create a user.
+// If creating the user fails, the instance is marked as failed, so creating it again resumes it.
+func (m *mongoAdapter) CreateInstance(ctx context.Context, instanceName string, opts adapter.CreateOptions) (adapter.Instance, error) {
+	// MongoDB instances have no params or plans yet, but unknown ones are still rejected
+	if err := adapter.DecodeParams(opts.Params, &struct{}{}); err != nil {
+		return nil, err
+	}
+	if opts.Plan != "" {
+		return nil, fmt.Errorf("%w: unknown plan: %s", adapter.ErrInvalidParams, opts.Plan)
+	}
+	instance := &Instance{
+		InstanceName: instanceName,
+		Host:         m.host,
+		Port:         m.port,
+		Database:     "db_" + instanceName,
+		Username:     "user_" + instanceName + "_" + strings.ToLower(util.RandToken(4)),
+		Password:     util.RandPassword(),
+		Status:       adapter.StatusProvisioning,
+		CreatedAt:    time.Now().UTC(),
+	}
+	if err := m.reserveInstance(ctx, instance); err != nil {
+		return nil, err
+	}
+	if err := m.createUser(ctx, instance.Database, instance.Username, instance.Password); err != nil {
+		m.failInstance(ctx, instance)
+		return nil, fmt.Errorf("create user: %w", err)
+	}
+	if err := m.setStatus(ctx, instance, adapter.StatusReady); err != nil {
+		m.failInstance(ctx, instance)
+		return nil, err
+	}
+	return instance, nil
+}
+
+// reserveInstance saves the record of a provisioning instance. A failed or interrupted instance gets taken over,
+// any other existing instance is a conflict.
+func (m *mongoAdapter) reserveInstance(ctx context.Context, instance *Instance) error {
+	_, err := m.instances.InsertOne(ctx, instance)
+	if err == nil {
+		return nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("insert instance: %w", err)
+	}
+	existing, err := m.getInstance(ctx, instance.InstanceName)
+	if err == adapter.ErrInstanceNotFound {
+		return adapter.ErrInstanceExists
+	}
+	if err != nil {
+		return err
+	}
+	if existing.status() != adapter.StatusFailed && !existing.interrupted() {
+		return adapter.ErrInstanceExists
+	}
+	// only one of the concurrent retries gets to replace the failed record
+	filter := bson.D{
+		{Key: "_id", Value: existing.InstanceName},
+		{Key: "db_user", Value: existing.Username},
+		{Key: "status", Value: existing.Status},
+	}
+	result, err := m.instances.ReplaceOne(ctx, filter, instance)
+	if err != nil {
+		return fmt.Errorf("save instance data: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return adapter.ErrInstanceExists
+	}
+	// the users of the failed attempt may have survived its cleanup
+	for _, user := range existing.users() {
+		if err := m.dropUser(ctx, existing.Database, user); err != nil {
+			return fmt.Errorf("drop user: %w", err)
+		}
+	}
+	return nil
+}
+
+// setStatus updates the status of an instance, unless its record got taken over by another creation in the meantime
+func (m *mongoAdapter) setStatus(ctx context.Context, instance *Instance, status adapter.Status) error {
+	filter := bson.D{
+		{Key: "_id", Value: instance.InstanceName},
+		{Key: "db_user", Value: instance.Username},
+	}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "status", Value: status}}}}
+	result, err := m.instances.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return fmt.Errorf("update instance status: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return adapter.ErrInstanceNotFound
+	}
+	instance.Status = status
+	return nil
+}
+
+// failInstance drops the user of an instance whose provisioning failed and marks the instance as failed
+func (m *mongoAdapter) failInstance(ctx context.Context, instance *Instance) {
+	ctx = context.WithoutCancel(ctx)
+	if err := m.dropUser(ctx, instance.Database, instance.Username); err != nil {
+		log.Printf("cleanup mongodb instance %s: %v", instance.InstanceName, err)
+	}
+	if err := m.setStatus(ctx, instance, adapter.StatusFailed); err != nil {
+		log.Printf("mark mongodb instance %s as failed: %v", instance.InstanceName, err)
+	}
+}
+
+// DeleteInstance also removes instances whose provisioning failed or got interrupted
+func (m *mongoAdapter) DeleteInstance(ctx context.Context, instanceName string) error {
+	instance, err := m.getInstance(ctx, instanceName)
+	if err != nil {
+		return err
+	}
+	for _, user := range instance.users() {
+		if err := m.dropUser(ctx, instance.Database, user); err != nil {
+			return fmt.Errorf("drop user: %w", err)
+		}
+	}
+	if err := m.client.Database(instance.Database).Drop(ctx); err != nil {
+		return fmt.Errorf("drop database: %w", err)
+	}
+	if _, err := m.instances.DeleteOne(ctx, bson.D{{Key: "_id", Value: instanceName}}); err != nil {
+		return fmt.Errorf("delete instance: %w", err)
+	}
+	return nil
+}
+
+func (m *mongoAdapter) RotateCredentials(ctx context.Context, instanceName string, opts adapter.RotateOptions) (adapter.Instance, error) {
+	instance, err := m.getInstance(ctx, instanceName)
+	if err != nil {
+		return nil, err
+	}
+	if instance.status() != adapter.StatusReady {
+		return nil, fmt.Errorf("%w: %s is %s", adapter.ErrInstanceNotReady, instanceName, instance.status())
+	}
+	if err := m.retirePreviousUser(ctx, instance); err != nil {
+		return nil, fmt.Errorf("retire previous user: %w", err)
+	}
+	dbPass := util.RandPassword()
+	if opts.GracePeriod > 0 {
+		// a user can only have one password, so a new user takes over while the current one expires
+		dbUser := "user_" + instanceName + "_" + strings.ToLower(util.RandToken(4))
+		if err := m.createUser(ctx, instance.Database, dbUser, dbPass); err != nil {
+			return nil, fmt.Errorf("create user: %w", err)
+		}
+		expiresAt := time.Now().UTC().Add(opts.GracePeriod)
+		instance.PreviousUsername = instance.Username
+		instance.PreviousPassword = instance.Password
+		instance.PreviousExpiresAt = &expiresAt
+		instance.Username = dbUser
+	} else {
+		if err := m.updateUserPassword(ctx, instance.Database, instance.Username, dbPass); err != nil {
+			return nil, fmt.Errorf("update user password: %w", err)
+		}
+	}
+	instance.Password = dbPass
+	if err := m.saveInstance(ctx, instance); err != nil {
+		return nil, err
+	}
+	return instance, nil
+}
+
+func (m *mongoAdapter) expireCredentialsLoop() {
+	ticker := time.NewTicker(credentialExpiryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+			if err := m.expireCredentials(context.Background()); err != nil {
+				log.Printf("expire mongodb credentials: %v", err)
+			}
+		}
+	}
+}
+
+// expireCredentials drops the previous users whose grace period has ended
+func (m *mongoAdapter) expireCredentials(ctx context.Context) error {
+	filter := bson.D{
+		{Key: "prev_db_user", Value: bson.D{{Key: "$ne", Value: ""}}},
+		{Key: "prev_expires_at", Value: bson.D{{Key: "$lte", Value: time.Now().UTC()}}},
+	}
+	cursor, err := m.instances.Find(ctx, filter)
+	if err != nil {
+		return fmt.Errorf("find instances: %w", err)
+	}
+	var instances []Instance
+	if err := cursor.All(ctx, &instances); err != nil {
+		return fmt.Errorf("iterate instances: %w", err)
+	}
+	for i := range instances {
+		instance := &instances[i]
+		if err := m.retirePreviousUser(ctx, instance); err != nil {
+			return fmt.Errorf("retire previous user of %s: %w", instance.InstanceName, err)
+		}
+		if err := m.saveInstance(ctx, instance); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *mongoAdapter) retirePreviousUser(ctx context.Context, instance *Instance) error {
+	if instance.PreviousUsername == "" {
+		return nil
+	}
+	if err := m.dropUser(ctx, instance.Database, instance.PreviousUsername); err != nil {
+		return err
+	}
+	instance.PreviousUsername = ""
+	instance.PreviousPassword = ""
+	instance.PreviousExpiresAt = nil
+	return nil
+}
+
+func (m *mongoAdapter) getInstance(ctx context.Context, instanceName string) (*Instance, error) {
+	instance := &Instance{
+		Host: m.host,
+		Port: m.port,
+	}
+	err := m.instances.FindOne(ctx, bson.D{{Key: "_id", Value: instanceName}}).Decode(instance)
+	if err == mongo.ErrNoDocuments {
+		return nil, adapter.ErrInstanceNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get instance data: %w", err)
+	}
+	return instance, nil
+}
+
+func (m *mongoAdapter) saveInstance(ctx context.Context, instance *Instance) error {
+	result, err := m.instances.ReplaceOne(ctx, bson.D{{Key: "_id", Value: instance.InstanceName}}, instance)
+	if err != nil {
+		return fmt.Errorf("save instance data: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return adapter.ErrInstanceNotFound
+	}
+	return nil
+}
+
+// createUser defines the user in the instance database, which is why the URI's authSource points there
+func (m *mongoAdapter) createUser(ctx context.Context, dbName, username, password string) error {
+	cmd := bson.D{
+		{Key: "createUser", Value: username},
+		{Key: "pwd", Value: password},
+		{Key: "roles", Value: bson.A{bson.D{{Key: "role", Value: "readWrite"}, {Key: "db", Value: dbName}}}},
+	}
+	return m.client.Database(dbName).RunCommand(ctx, cmd).Err()
+}
+
+func (m *mongoAdapter) updateUserPassword(ctx context.Context, dbName, username, password string) error {
+	cmd := bson.D{
+		{Key: "updateUser", Value: username},
+		{Key: "pwd", Value: password},
+	}
+	return m.client.Database(dbName).RunCommand(ctx, cmd).Err()
+}
+
+// dropUser ignores users that don't exist, e.g. after a failed rotation
+func (m *mongoAdapter) dropUser(ctx context.Context, dbName, username string) error {
+	err := m.client.Database(dbName).RunCommand(ctx, bson.D{{Key: "dropUser", Value: username}}).Err()
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && cmdErr.Code == 11 { // UserNotFound
+		return nil
+	}
+	return err
+}
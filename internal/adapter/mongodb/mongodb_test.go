@@ -0,0 +1,303 @@
+package mongodb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	adapterpkg "github.com/razzie-cloud/database-broker/internal/adapter"
+
+	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+func startMongoContainer(t *testing.T) (testcontainers.Container, string, int) {
+	req := testcontainers.ContainerRequest{
+		Image:        "mongo:7",
+		ExposedPorts: []string{"27017/tcp"},
+		Env: map[string]string{
+			"MONGO_INITDB_ROOT_USERNAME": "admin",
+			"MONGO_INITDB_ROOT_PASSWORD": "adminpass",
+		},
+		// the image restarts the server after initialization
+		WaitingFor: wait.ForAll(
+			wait.ForLog("Waiting for connections").WithOccurrence(2),
+			wait.ForListeningPort("27017/tcp"),
+		),
+	}
+	container, err := testcontainers.GenericContainer(context.Background(), testcontainers.GenericContainerRequest{
+		ContainerRequest: req,
+		Started:          true,
+	})
+	require.NoError(t, err)
+
+	host, err := container.Host(context.Background())
+	require.NoError(t, err)
+	portStr, err := container.MappedPort(context.Background(), "27017")
+	require.NoError(t, err)
+	port := portStr.Int()
+	uri := fmt.Sprintf("mongodb://admin:adminpass@%s:%d/broker?authSource=admin", host, port)
+	return container, uri, port
+}
+
+func connect(t *testing.T, uri string) *mongo.Client {
+	client, err := mongo.Connect(options.Client().ApplyURI(uri).SetServerSelectionTimeout(5 * time.Second))
+	require.NoError(t, err)
+	return client
+}
+
+func TestMongoAdapterIntegration(t *testing.T) {
+	ctx := context.Background()
+	container, uri, _ := startMongoContainer(t)
+	defer container.Terminate(ctx)
+
+	adapter, err := New(uri)
+	require.NoError(t, err)
+	defer adapter.Close()
+
+	foo, err := adapter.GetOrCreateInstance(ctx, "foo")
+	require.NoError(t, err)
+	bar, err := adapter.GetOrCreateInstance(ctx, "bar")
+	require.NoError(t, err)
+	require.Contains(t, foo.GetURI(), "authSource=db_foo")
+
+	instances, err := adapter.GetInstances(ctx)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"foo", "bar"}, instances)
+
+	fooLookup, err := adapter.GetInstance(ctx, "foo")
+	require.NoError(t, err)
+	require.Equal(t, foo.GetURI(), fooLookup.GetURI())
+	_, err = adapter.GetInstance(ctx, "missing")
+	require.ErrorIs(t, err, adapterpkg.ErrInstanceNotFound)
+
+	fooClient := connect(t, foo.GetURI())
+	defer fooClient.Disconnect(ctx)
+	barClient := connect(t, bar.GetURI())
+	defer barClient.Disconnect(ctx)
+
+	// Insert and read data in foo
+	_, err = fooClient.Database("db_foo").Collection("test").InsertOne(ctx, bson.D{{Key: "value", Value: "foo-value"}})
+	require.NoError(t, err)
+	var doc struct {
+		Value string `bson:"value"`
+	}
+	err = fooClient.Database("db_foo").Collection("test").FindOne(ctx, bson.D{}).Decode(&doc)
+	require.NoError(t, err)
+	require.Equal(t, "foo-value", doc.Value)
+
+	// Check foo's database is not accessible from bar
+	err = barClient.Database("db_foo").Collection("test").FindOne(ctx, bson.D{}).Err()
+	require.Error(t, err)
+	_, err = barClient.Database("db_foo").Collection("test").InsertOne(ctx, bson.D{{Key: "value", Value: "bar-value"}})
+	require.Error(t, err)
+
+	// Check the metadata database is not accessible either
+	err = fooClient.Database("broker").Collection("instances").FindOne(ctx, bson.D{}).Err()
+	require.Error(t, err)
+}
+
+func TestMongoAdapterDeleteInstance(t *testing.T) {
+	ctx := context.Background()
+	container, uri, _ := startMongoContainer(t)
+	defer container.Terminate(ctx)
+
+	adapter, err := New(uri)
+	require.NoError(t, err)
+	defer adapter.Close()
+
+	foo, err := adapter.GetOrCreateInstance(ctx, "foo")
+	require.NoError(t, err)
+	fooClient := connect(t, foo.GetURI())
+	defer fooClient.Disconnect(ctx)
+	_, err = fooClient.Database("db_foo").Collection("test").InsertOne(ctx, bson.D{{Key: "value", Value: "foo-value"}})
+	require.NoError(t, err)
+
+	require.NoError(t, adapter.DeleteInstance(ctx, "foo"))
+	require.ErrorIs(t, adapter.DeleteInstance(ctx, "foo"), adapterpkg.ErrInstanceNotFound)
+
+	instances, err := adapter.GetInstances(ctx)
+	require.NoError(t, err)
+	require.Empty(t, instances)
+
+	adminClient := connect(t, uri)
+	defer adminClient.Disconnect(ctx)
+	databases, err := adminClient.ListDatabaseNames(ctx, bson.D{})
+	require.NoError(t, err)
+	require.NotContains(t, databases, "db_foo")
+	var usersInfo struct {
+		Users []bson.M `bson:"users"`
+	}
+	err = adminClient.Database("db_foo").RunCommand(ctx, bson.D{{Key: "usersInfo", Value: 1}}).Decode(&usersInfo)
+	require.NoError(t, err)
+	require.Empty(t, usersInfo.Users)
+
+	// The instance can be recreated after deletion
+	_, err = adapter.GetOrCreateInstance(ctx, "foo")
+	require.NoError(t, err)
+}
+
+func TestMongoAdapterRotateCredentials(t *testing.T) {
+	ctx := context.Background()
+	container, uri, _ := startMongoContainer(t)
+	defer container.Terminate(ctx)
+
+	adapter, err := New(uri)
+	require.NoError(t, err)
+	defer adapter.Close()
+
+	foo, err := adapter.GetOrCreateInstance(ctx, "foo")
+	require.NoError(t, err)
+
+	rotated, err := adapter.RotateCredentials(ctx, "foo", adapterpkg.RotateOptions{})
+	require.NoError(t, err)
+	require.NotEqual(t, foo.GetURI(), rotated.GetURI())
+
+	// The stored instance matches the rotated one
+	stored, err := adapter.GetInstance(ctx, "foo")
+	require.NoError(t, err)
+	require.Equal(t, rotated.GetURI(), stored.GetURI())
+
+	// Old password no longer works, the new one does
+	oldClient := connect(t, foo.GetURI())
+	defer oldClient.Disconnect(ctx)
+	require.Error(t, oldClient.Ping(ctx, nil))
+	newClient := connect(t, rotated.GetURI())
+	defer newClient.Disconnect(ctx)
+	require.NoError(t, newClient.Ping(ctx, nil))
+
+	// With a grace period both credentials work until it ends
+	graceRotated, err := adapter.RotateCredentials(ctx, "foo", adapterpkg.RotateOptions{GracePeriod: time.Millisecond})
+	require.NoError(t, err)
+	require.NotNil(t, graceRotated.GetJSON().(InstanceResponse).PreviousCredential)
+	graceClient := connect(t, graceRotated.GetURI())
+	defer graceClient.Disconnect(ctx)
+	require.NoError(t, graceClient.Ping(ctx, nil))
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, adapter.(*mongoAdapter).expireCredentials(ctx))
+	expired, err := adapter.GetInstance(ctx, "foo")
+	require.NoError(t, err)
+	require.Nil(t, expired.GetJSON().(InstanceResponse).PreviousCredential)
+	expiredClient := connect(t, rotated.GetURI())
+	defer expiredClient.Disconnect(ctx)
+	require.Error(t, expiredClient.Ping(ctx, nil))
+
+	_, err = adapter.RotateCredentials(ctx, "missing", adapterpkg.RotateOptions{})
+	require.ErrorIs(t, err, adapterpkg.ErrInstanceNotFound)
+}
+
+func TestMongoAdapterCreateInstance(t *testing.T) {
+	ctx := context.Background()
+	container, uri, _ := startMongoContainer(t)
+	defer container.Terminate(ctx)
+
+	adapter, err := New(uri)
+	require.NoError(t, err)
+	defer adapter.Close()
+
+	foo, err := adapter.CreateInstance(ctx, "foo", adapterpkg.CreateOptions{})
+	require.NoError(t, err)
+	require.Equal(t, adapterpkg.StatusReady, foo.GetJSON().(InstanceResponse).Status)
+	_, err = adapter.CreateInstance(ctx, "foo", adapterpkg.CreateOptions{})
+	require.ErrorIs(t, err, adapterpkg.ErrInstanceExists)
+
+	// A creation that got interrupted is resumed once it has been provisioning for too long
+	interrupted := &Instance{
+		InstanceName: "qux",
+		Database:     "db_qux",
+		Username:     "user_qux_gone",
+		Password:     "secret",
+		Status:       adapterpkg.StatusProvisioning,
+		CreatedAt:    time.Now().UTC().Add(-2 * provisioningTimeout),
+	}
+	_, err = adapter.(*mongoAdapter).instances.InsertOne(ctx, interrupted)
+	require.NoError(t, err)
+	_, err = adapter.RotateCredentials(ctx, "qux", adapterpkg.RotateOptions{})
+	require.ErrorIs(t, err, adapterpkg.ErrInstanceNotReady)
+	qux, err := adapter.GetOrCreateInstance(ctx, "qux")
+	require.NoError(t, err)
+	require.Equal(t, adapterpkg.StatusReady, qux.GetJSON().(InstanceResponse).Status)
+	require.NotEqual(t, interrupted.Username, qux.GetJSON().(InstanceResponse).Username)
+	quxClient := connect(t, qux.GetURI())
+	defer quxClient.Disconnect(ctx)
+	_, err = quxClient.Database("db_qux").Collection("test").InsertOne(ctx, bson.D{{Key: "value", Value: "qux-value"}})
+	require.NoError(t, err)
+
+	_, err = adapter.CreateInstance(ctx, "bar", adapterpkg.CreateOptions{Params: json.RawMessage(`{"unknown":true}`)})
+	require.ErrorIs(t, err, adapterpkg.ErrInvalidParams)
+	_, err = adapter.CreateInstance(ctx, "bar", adapterpkg.CreateOptions{Plan: "small"})
+	require.ErrorIs(t, err, adapterpkg.ErrInvalidParams)
+}
+
+func TestMongoAdapterConcurrentCreate(t *testing.T) {
+	ctx := context.Background()
+	container, uri, _ := startMongoContainer(t)
+	defer container.Terminate(ctx)
+
+	adapter, err := New(uri)
+	require.NoError(t, err)
+	defer adapter.Close()
+
+	const workers = 20
+	uris := make([]string, workers)
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	for i := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			instance, err := adapter.GetOrCreateInstance(ctx, "foo")
+			errs[i] = err
+			if err == nil {
+				uris[i] = instance.GetURI()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Every caller gets the same credential, and it's the one that works
+	for i := range workers {
+		require.NoError(t, errs[i])
+		require.Equal(t, uris[0], uris[i])
+	}
+	fooClient := connect(t, uris[0])
+	defer fooClient.Disconnect(ctx)
+	_, err = fooClient.Database("db_foo").Collection("test").InsertOne(ctx, bson.D{{Key: "value", Value: "foo-value"}})
+	require.NoError(t, err)
+
+	// Concurrent explicit creations have a single winner
+	created := make([]error, workers)
+	for i := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, created[i] = adapter.CreateInstance(ctx, "bar", adapterpkg.CreateOptions{})
+		}()
+	}
+	wg.Wait()
+	var succeeded int
+	for _, err := range created {
+		if err == nil {
+			succeeded++
+		} else {
+			require.ErrorIs(t, err, adapterpkg.ErrInstanceExists)
+		}
+	}
+	require.Equal(t, 1, succeeded)
+
+	adminClient := connect(t, uri)
+	defer adminClient.Disconnect(ctx)
+	var usersInfo struct {
+		Users []bson.M `bson:"users"`
+	}
+	err = adminClient.Database("db_bar").RunCommand(ctx, bson.D{{Key: "usersInfo", Value: 1}}).Decode(&usersInfo)
+	require.NoError(t, err)
+	require.Len(t, usersInfo.Users, 1)
+}
@@ -0,0 +1,7 @@
+package mysql
+
+type process struct {
+	ID int64 `db:"id"`
+}
+
+func (process) Table() string { return "processlist" }
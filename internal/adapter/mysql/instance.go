@@ -0,0 +1,103 @@
+package mysql
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/razzie-cloud/database-broker/internal/adapter"
+)
+
+var _ adapter.Instance = (*Instance)(nil)
+
+type Instance struct {
+	InstanceName      string         `db:"instance_name,primary"`
+	Host              string         `db:"-"`
+	Port              int            `db:"-"`
+	Database          string         `db:"db_name"`
+	Username          string         `db:"db_user"`
+	Password          string         `db:"db_password"`
+	PreviousUsername  string         `db:"prev_db_user"`
+	PreviousPassword  string         `db:"prev_db_password"`
+	PreviousExpiresAt *time.Time     `db:"prev_expires_at"`
+	Plan              string         `db:"plan"`
+	Status            adapter.Status `db:"status"`
+	CreatedAt         time.Time      `db:"created_at"`
+}
+
+func (Instance) Table() string { return "instances" }
+
+func (i Instance) GetURI() string {
+	return buildConnURI(i.Host, i.Port, i.Database, i.Username, i.Password)
+}
+
+func (i Instance) GetJSON() any {
+	return InstanceResponse{
+		Instance:           i.InstanceName,
+		Host:               i.Host,
+		Port:               i.Port,
+		Database:           i.Database,
+		Username:           i.Username,
+		Password:           i.Password,
+		URI:                i.GetURI(),
+		PreviousCredential: i.previousCredential(),
+		Plan:               i.Plan,
+		Status:             i.Status,
+		CreatedAt:          i.CreatedAt,
+	}
+}
+
+// users returns every user that belongs to the instance
+func (i Instance) users() []string {
+	if i.PreviousUsername == "" {
+		return []string{i.Username}
+	}
+	return []string{i.Username, i.PreviousUsername}
+}
+
+// interrupted reports whether the instance has been provisioning for longer than a creation takes,
+// which means its creator is gone, e.g. because it crashed
+func (i Instance) interrupted() bool {
+	return i.Status == adapter.StatusProvisioning && time.Since(i.CreatedAt) > provisioningTimeout
+}
+
+func (i Instance) previousCredential() *CredentialResponse {
+	if i.PreviousUsername == "" || i.PreviousExpiresAt == nil || !i.PreviousExpiresAt.After(time.Now()) {
+		return nil
+	}
+	return &CredentialResponse{
+		Username:  i.PreviousUsername,
+		Password:  i.PreviousPassword,
+		URI:       buildConnURI(i.Host, i.Port, i.Database, i.PreviousUsername, i.PreviousPassword),
+		ExpiresAt: *i.PreviousExpiresAt,
+	}
+}
+
+type InstanceResponse struct {
+	Instance           string              `json:"instance"`
+	Host               string              `json:"host"`
+	Port               int                 `json:"port"`
+	Database           string              `json:"database"`
+	Username           string              `json:"username"`
+	Password           string              `json:"password"`
+	URI                string              `json:"uri"`
+	PreviousCredential *CredentialResponse `json:"previous_credential,omitempty"`
+	Plan               string              `json:"plan,omitempty"`
+	Status             adapter.Status      `json:"status"`
+	CreatedAt          time.Time           `json:"created_at"`
+}
+
+type CredentialResponse struct {
+	Username  string    `json:"username"`
+	Password  string    `json:"password"`
+	URI       string    `json:"uri"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func buildConnURI(host string, port int, db, user, pass string) string {
+	userpass := url.UserPassword(user, pass).String()
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	return fmt.Sprintf("mysql://%s@%s/%s", userpass, addr, db)
+}
@@ -0,0 +1,46 @@
+package mysql
+
+import (
+	"context"
+
+	"github.com/razzie-cloud/database-broker/internal/adapter"
+
+	"github.com/go-rel/migration"
+	"github.com/go-rel/rel"
+)
+
+// MySQL can't index or set defaults on TEXT columns, so the columns are VARCHARs
+
+func MigrateCreateInstances(schema *rel.Schema) {
+	schema.CreateTable("instances", func(t *rel.Table) {
+		t.String("instance_name")
+		t.PrimaryKey("instance_name")
+		t.String("db_name")
+		t.String("db_user")
+		t.String("db_password")
+		t.String("prev_db_user", rel.Default(""))
+		t.String("prev_db_password", rel.Default(""))
+		t.DateTime("prev_expires_at")
+		t.String("plan", rel.Default(""))
+		t.DateTime("created_at")
+	})
+}
+
+func RollbackCreateInstances(schema *rel.Schema) {
+	schema.DropTable("instances")
+}
+
+func MigrateAddStatus(schema *rel.Schema) {
+	schema.AddColumn("instances", "status", rel.String, rel.Default(string(adapter.StatusReady)))
+}
+
+func RollbackAddStatus(schema *rel.Schema) {
+	schema.DropColumn("instances", "status")
+}
+
+func migrate(repo rel.Repository) {
+	m := migration.New(repo)
+	m.Register(1, MigrateCreateInstances, RollbackCreateInstances)
+	m.Register(2, MigrateAddStatus, RollbackAddStatus)
+	m.Migrate(context.Background())
+}
@@ -0,0 +1,588 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/razzie-cloud/database-broker/internal/adapter"
+	"github.com/razzie-cloud/database-broker/internal/util"
+
+	"github.com/go-rel/mysql"
+	"github.com/go-rel/rel"
+	mysqldriver "github.com/go-sql-driver/mysql"
+)
+
+const (
+	// defaultMetadataDatabase holds the instance records if the URI doesn't name a database
+	defaultMetadataDatabase  = "database_broker"
+	credentialExpiryInterval = time.Minute
+	// provisioningTimeout is how long a creation may take, a longer one got interrupted
+	provisioningTimeout      = time.Minute
+	provisioningPollInterval = 100 * time.Millisecond
+)
+
+type mysqlAdapter struct {
+	adapter rel.Adapter
+	repo    rel.Repository
+	host    string
+	port    int
+	plans   map[string]Plan
+	done    chan struct{}
+}
+
+type Option func(my *mysqlAdapter)
+
+// WithPlans sets the provisioning plans callers can choose from at instance creation
+func WithPlans(plans map[string]Plan) Option {
+	return func(my *mysqlAdapter) {
+		my.plans = plans
+	}
+}
+
+func New(mysqlURI string, opts ...Option) (adapter.Interface, error) {
+	host, port, err := util.GetURIHostPort(mysqlURI, 3306)
+	if err != nil {
+		return nil, fmt.Errorf("parse mysql uri: %w", err)
+	}
+	cfg, err := parseURI(mysqlURI)
+	if err != nil {
+		return nil, fmt.Errorf("parse mysql uri: %w", err)
+	}
+	my := &mysqlAdapter{
+		host: host,
+		port: port,
+		done: make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(my)
+	}
+	for name, plan := range my.plans {
+		if err := plan.validate(); err != nil {
+			return nil, fmt.Errorf("invalid plan %s: %w", name, err)
+		}
+	}
+	if cfg.DBName == "" {
+		cfg.DBName = defaultMetadataDatabase
+	}
+	if err := createMetadataDatabase(cfg); err != nil {
+		return nil, fmt.Errorf("create metadata database: %w", err)
+	}
+	my.adapter, my.repo, err = openRepository(cfg)
+	if err != nil {
+		return nil, err
+	}
+	migrate(my.repo)
+	go my.expireCredentialsLoop()
+	return my, nil
+}
+
+// parseURI converts a mysql:// URI to a driver config. Query parameters are passed on as DSN parameters.
+func parseURI(mysqlURI string) (*mysqldriver.Config, error) {
+	u, err := url.Parse(mysqlURI)
+	if err != nil {
+		return nil, err
+	}
+	host, port, err := util.GetURIHostPort(mysqlURI, 3306)
+	if err != nil {
+		return nil, err
+	}
+	cfg := mysqldriver.NewConfig()
+	cfg.User = u.User.Username()
+	cfg.Passwd, _ = u.User.Password()
+	cfg.Net = "tcp"
+	cfg.Addr = net.JoinHostPort(host, strconv.Itoa(port))
+	cfg.DBName = strings.TrimPrefix(u.Path, "/")
+	dsn := cfg.FormatDSN()
+	if u.RawQuery != "" {
+		if strings.Contains(dsn, "?") {
+			dsn += "&" + u.RawQuery
+		} else {
+			dsn += "?" + u.RawQuery
+		}
+	}
+	return mysqldriver.ParseDSN(dsn)
+}
+
+func createMetadataDatabase(cfg *mysqldriver.Config) error {
+	serverCfg := cfg.Clone()
+	serverCfg.DBName = ""
+	connector, err := mysqldriver.NewConnector(serverCfg)
+	if err != nil {
+		return err
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	_, err = db.Exec(fmt.Sprintf("CREATE DATABASE IF NOT EXISTS %s;", mysql.Quote{}.ID(cfg.DBName)))
+	return err
+}
+
+func openRepository(cfg *mysqldriver.Config) (rel.Adapter, rel.Repository, error) {
+	cfg = cfg.Clone()
+	cfg.ParseTime = true
+	cfg.ClientFoundRows = true // required by rel to detect missing records on update and delete
+	connector, err := mysqldriver.NewConnector(cfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("open mysql: %w", err)
+	}
+	db := sql.OpenDB(connector)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, nil, fmt.Errorf("open mysql: %w", err)
+	}
+	adapter := mysql.New(db)
+	repo := rel.New(adapter)
+	repo.Instrumentation(rel.Instrumenter(func(ctx context.Context, op, message string, args ...any) func(err error) {
+		return func(err error) {
+			if err != nil && err != rel.ErrNotFound {
+				log.Printf("[op: %s] %s - %v", op, fmt.Sprintf(message, args...), err)
+			}
+		}
+	}))
+	return adapter, repo, nil
+}
+
+func (my *mysqlAdapter) Close() error {
+	close(my.done)
+	return my.adapter.Close()
+}
+
+func (my *mysqlAdapter) GetInstances(ctx context.Context) ([]string, error) {
+	var instances []Instance
+	err := my.repo.FindAll(ctx, &instances, rel.Select("instance_name"), rel.SortAsc("instance_name"))
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(instances))
+	for i, inst := range instances {
+		names[i] = inst.InstanceName
+	}
+	return names, nil
+}
+
+func (my *mysqlAdapter) GetInstance(ctx context.Context, instanceName string) (adapter.Instance, error) {
+	instance, err := my.getInstance(ctx, instanceName)
+	if err != nil {
+		return nil, err
+	}
+	return instance, nil
+}
+
+// GetOrCreateInstance waits for a creation in progress and resumes failed or interrupted instances
+func (my *mysqlAdapter) GetOrCreateInstance(ctx context.Context, instanceName string) (adapter.Instance, error) {
+	instance, err := my.waitForInstance(ctx, instanceName)
+	switch {
+	case err == adapter.ErrInstanceNotFound:
+	case err != nil:
+		return nil, err
+	case instance.Status == adapter.StatusReady:
+		return instance, nil
+	}
+	created, err := my.CreateInstance(ctx, instanceName, adapter.CreateOptions{})
+	if err == adapter.ErrInstanceExists {
+		instance, err = my.waitForInstance(ctx, instanceName)
+		if err != nil {
+			return nil, err
+		}
+		return instance, nil
+	}
+	return created, err
+}
+
+// waitForInstance returns the instance once it's not provisioning anymore, or its creation got interrupted
+func (my *mysqlAdapter) waitForInstance(ctx context.Context, instanceName string) (*Instance, error) {
+	for {
+		instance, err := my.getInstance(ctx, instanceName)
+		if err != nil || instance.Status != adapter.StatusProvisioning || instance.interrupted() {
+			return instance, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(provisioningPollInterval):
+		}
+	}
+}
+
+// CreateInstance reserves the instance record as provisioning first, as MySQL can't roll back DDL statements,
+// then creates the resources of the instance while holding the lock of the record.
+// If that fails, the instance is marked as failed, so creating it again resumes it.
+func (my *mysqlAdapter) CreateInstance(ctx context.Context, instanceName string, opts adapter.CreateOptions) (adapter.Instance, error) {
+	var params CreateParams
+	if err := adapter.DecodeParams(opts.Params, &params); err != nil {
+		return nil, err
+	}
+	if err := params.validate(); err != nil {
+		return nil, err
+	}
+	planName, plan, err := adapter.ResolvePlan(my.plans, opts.Plan)
+	if err != nil {
+		return nil, err
+	}
+	instance := &Instance{
+		InstanceName: instanceName,
+		Host:         my.host,
+		Port:         my.port,
+		Database:     "db_" + instanceName,
+		Username:     newUsername(instanceName),
+		Password:     util.RandPassword(),
+		Plan:         planName,
+		Status:       adapter.StatusProvisioning,
+		CreatedAt:    time.Now().UTC(),
+	}
+	// an existing record is checked for a takeover once it's locked
+	if err := my.repo.Insert(ctx, instance); err != nil && !errors.Is(err, rel.ErrUniqueConstraint) {
+		return nil, fmt.Errorf("insert instance: %w", err)
+	}
+	var createErr error
+	err = my.withInstanceLock(ctx, instanceName, func(txCtx context.Context, existing *Instance) error {
+		// the random user name tells apart the record inserted above
+		if existing.Username != instance.Username {
+			if err := my.takeOverInstance(ctx, txCtx, existing, instance); err != nil {
+				return err
+			}
+		}
+		createErr = my.provisionInstance(ctx, instance, params, plan)
+		if createErr != nil {
+			instance.Status = adapter.StatusFailed
+		} else {
+			instance.Status = adapter.StatusReady
+		}
+		if err := my.repo.Update(txCtx, instance); err != nil {
+			return fmt.Errorf("update instance: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if createErr != nil {
+		return nil, createErr
+	}
+	return instance, nil
+}
+
+// withInstanceLock runs fn with the record of the instance locked by a transaction, which serializes the changes
+// of the instance. MySQL commits the transaction implicitly before DDL statements, so fn has to run those on ctx,
+// and only the updates of the record on txCtx.
+func (my *mysqlAdapter) withInstanceLock(ctx context.Context, instanceName string, fn func(txCtx context.Context, instance *Instance) error) error {
+	return my.repo.Transaction(ctx, func(txCtx context.Context) error {
+		instance := &Instance{
+			Host: my.host,
+			Port: my.port,
+		}
+		err := my.repo.Find(txCtx, instance, rel.Eq("instance_name", instanceName), rel.ForUpdate())
+		if err == rel.ErrNotFound {
+			return adapter.ErrInstanceNotFound
+		}
+		if err != nil {
+			return err
+		}
+		return fn(txCtx, instance)
+	})
+}
+
+// takeOverInstance replaces the locked record of a failed or interrupted instance with a new provisioning one.
+// Any other existing instance is a conflict, including one whose creator hasn't locked its record yet.
+func (my *mysqlAdapter) takeOverInstance(ctx, txCtx context.Context, existing, instance *Instance) error {
+	switch {
+	case existing.interrupted():
+		if err := my.dropInstance(ctx, existing); err != nil {
+			return fmt.Errorf("cleanup interrupted instance: %w", err)
+		}
+	case existing.Status != adapter.StatusFailed:
+		return adapter.ErrInstanceExists
+	}
+	if err := my.repo.Update(txCtx, instance); err != nil {
+		return fmt.Errorf("update instance: %w", err)
+	}
+	return nil
+}
+
+// provisionInstance creates the database and the user of the instance. If the user can't be provisioned,
+// whatever got created is dropped. The database may exist without belonging to the instance, so it's
+// kept if it can't be created.
+func (my *mysqlAdapter) provisionInstance(ctx context.Context, instance *Instance, params CreateParams, plan Plan) error {
+	if err := createDatabase(ctx, my.repo, instance.Database, params); err != nil {
+		return fmt.Errorf("create database: %w", err)
+	}
+	if err := my.provisionUser(ctx, instance, plan); err != nil {
+		if cleanupErr := my.dropInstance(context.WithoutCancel(ctx), instance); cleanupErr != nil {
+			log.Printf("clean up mysql instance %s: %v", instance.InstanceName, cleanupErr)
+		}
+		return err
+	}
+	return nil
+}
+
+func (my *mysqlAdapter) provisionUser(ctx context.Context, instance *Instance, plan Plan) error {
+	if err := createUser(ctx, my.repo, instance.Username, instance.Password); err != nil {
+		return fmt.Errorf("create user: %w", err)
+	}
+	if err := applyPlan(ctx, my.repo, instance.Username, plan); err != nil {
+		return fmt.Errorf("apply plan: %w", err)
+	}
+	if err := grantDatabaseAccess(ctx, my.repo, instance.Database, instance.Username); err != nil {
+		return fmt.Errorf("grant db access: %w", err)
+	}
+	return nil
+}
+
+// DeleteInstance also removes instances whose provisioning failed or got interrupted
+func (my *mysqlAdapter) DeleteInstance(ctx context.Context, instanceName string) error {
+	return my.withInstanceLock(ctx, instanceName, func(txCtx context.Context, instance *Instance) error {
+		if err := my.dropInstance(ctx, instance); err != nil {
+			return err
+		}
+		if err := my.repo.Delete(txCtx, instance); err != nil {
+			return fmt.Errorf("delete instance: %w", err)
+		}
+		return nil
+	})
+}
+
+func (my *mysqlAdapter) dropInstance(ctx context.Context, instance *Instance) error {
+	for _, user := range instance.users() {
+		if err := killUserSessions(ctx, my.repo, user); err != nil {
+			return fmt.Errorf("kill user sessions: %w", err)
+		}
+	}
+	if err := dropDatabase(ctx, my.repo, instance.Database); err != nil {
+		return fmt.Errorf("drop database: %w", err)
+	}
+	for _, user := range instance.users() {
+		if err := dropUser(ctx, my.repo, user); err != nil {
+			return fmt.Errorf("drop user: %w", err)
+		}
+	}
+	return nil
+}
+
+func (my *mysqlAdapter) RotateCredentials(ctx context.Context, instanceName string, opts adapter.RotateOptions) (adapter.Instance, error) {
+	var rotated *Instance
+	err := my.withInstanceLock(ctx, instanceName, func(txCtx context.Context, instance *Instance) error {
+		if instance.Status != adapter.StatusReady {
+			return fmt.Errorf("%w: %s is %s", adapter.ErrInstanceNotReady, instanceName, instance.Status)
+		}
+		if err := my.retirePreviousUser(ctx, instance); err != nil {
+			return fmt.Errorf("retire previous user: %w", err)
+		}
+		var err error
+		if opts.GracePeriod > 0 {
+			err = my.rotateUserWithGracePeriod(ctx, instance, opts.GracePeriod)
+		} else {
+			err = my.rotateUserPassword(ctx, instance)
+		}
+		if err != nil {
+			return err
+		}
+		if err := my.repo.Update(txCtx, instance); err != nil {
+			return fmt.Errorf("update instance: %w", err)
+		}
+		rotated = instance
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rotated, nil
+}
+
+func (my *mysqlAdapter) rotateUserPassword(ctx context.Context, instance *Instance) error {
+	dbPass := util.RandPassword()
+	if err := alterUserPassword(ctx, my.repo, instance.Username, dbPass); err != nil {
+		return fmt.Errorf("alter user password: %w", err)
+	}
+	instance.Password = dbPass
+	return nil
+}
+
+// rotateUserWithGracePeriod creates a new user with the same privileges,
+// while the current one keeps working until the grace period ends
+func (my *mysqlAdapter) rotateUserWithGracePeriod(ctx context.Context, instance *Instance, gracePeriod time.Duration) error {
+	dbUser := newUsername(instance.InstanceName)
+	dbPass := util.RandPassword()
+	expiresAt := time.Now().UTC().Add(gracePeriod)
+	if err := createUser(ctx, my.repo, dbUser, dbPass); err != nil {
+		return fmt.Errorf("create user: %w", err)
+	}
+	if err := applyPlan(ctx, my.repo, dbUser, my.plans[instance.Plan]); err != nil {
+		return fmt.Errorf("apply plan: %w", err)
+	}
+	if err := grantDatabaseAccess(ctx, my.repo, instance.Database, dbUser); err != nil {
+		return fmt.Errorf("grant db access: %w", err)
+	}
+	instance.PreviousUsername = instance.Username
+	instance.PreviousPassword = instance.Password
+	instance.PreviousExpiresAt = &expiresAt
+	instance.Username = dbUser
+	instance.Password = dbPass
+	return nil
+}
+
+// retirePreviousUser drops the user kept from the last rotation.
+// MySQL has no login expiry for users, so this is also done by the expiry loop once the grace period ends.
+func (my *mysqlAdapter) retirePreviousUser(ctx context.Context, instance *Instance) error {
+	if instance.PreviousUsername == "" {
+		return nil
+	}
+	if err := killUserSessions(ctx, my.repo, instance.PreviousUsername); err != nil {
+		return err
+	}
+	if err := dropUser(ctx, my.repo, instance.PreviousUsername); err != nil {
+		return err
+	}
+	instance.PreviousUsername = ""
+	instance.PreviousPassword = ""
+	instance.PreviousExpiresAt = nil
+	return nil
+}
+
+func (my *mysqlAdapter) expireCredentialsLoop() {
+	ticker := time.NewTicker(credentialExpiryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-my.done:
+			return
+		case <-ticker.C:
+			if err := my.expireCredentials(context.Background()); err != nil {
+				log.Printf("expire mysql credentials: %v", err)
+			}
+		}
+	}
+}
+
+// expireCredentials drops the previous users whose grace period has ended
+func (my *mysqlAdapter) expireCredentials(ctx context.Context) error {
+	var instances []Instance
+	err := my.repo.FindAll(ctx, &instances, rel.Ne("prev_db_user", ""), rel.Lte("prev_expires_at", time.Now().UTC()))
+	if err != nil {
+		return err
+	}
+	for _, expired := range instances {
+		err := my.withInstanceLock(ctx, expired.InstanceName, func(txCtx context.Context, instance *Instance) error {
+			// the instance may have been rotated again since it was found
+			if instance.PreviousUsername != expired.PreviousUsername {
+				return nil
+			}
+			if err := my.retirePreviousUser(ctx, instance); err != nil {
+				return fmt.Errorf("retire previous user of %s: %w", instance.InstanceName, err)
+			}
+			if err := my.repo.Update(txCtx, instance); err != nil {
+				return fmt.Errorf("update instance: %w", err)
+			}
+			return nil
+		})
+		// the instance may have been deleted since it was found
+		if err != nil && err != adapter.ErrInstanceNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+func (my *mysqlAdapter) getInstance(ctx context.Context, instanceName string) (*Instance, error) {
+	instance := &Instance{
+		Host: my.host,
+		Port: my.port,
+	}
+	err := my.repo.Find(ctx, instance, rel.Eq("instance_name", instanceName))
+	if err == rel.ErrNotFound {
+		return nil, adapter.ErrInstanceNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return instance, nil
+}
+
+// maxUsernameLength is the longest user name MySQL accepts
+const maxUsernameLength = 32
+
+// newUsername returns a new user name of the instance. The instance name gets truncated to fit the user name limit,
+// the random suffix keeps the names of instances with the same beginning apart.
+func newUsername(instanceName string) string {
+	suffix := "_" + strings.ToLower(util.RandToken(4))
+	if maxLen := maxUsernameLength - len("user_") - len(suffix); len(instanceName) > maxLen {
+		instanceName = instanceName[:maxLen]
+	}
+	return "user_" + instanceName + suffix
+}
+
+// userID returns the quoted account name of a user that can connect from any host
+func userID(name string) string {
+	return mysql.Quote{}.Value(name) + "@'%'"
+}
+
+func createDatabase(ctx context.Context, repo rel.Repository, name string, params CreateParams) error {
+	sql := fmt.Sprintf("CREATE DATABASE %s", mysql.Quote{}.ID(name))
+	if params.CharacterSet != "" {
+		sql += " CHARACTER SET " + params.CharacterSet
+	}
+	if params.Collation != "" {
+		sql += " COLLATE " + params.Collation
+	}
+	_, _, err := repo.Exec(ctx, sql)
+	return err
+}
+
+func createUser(ctx context.Context, repo rel.Repository, name, password string) error {
+	sql := fmt.Sprintf("CREATE USER %s IDENTIFIED BY %s;", userID(name), mysql.Quote{}.Value(password))
+	_, _, err := repo.Exec(ctx, sql)
+	return err
+}
+
+func alterUserPassword(ctx context.Context, repo rel.Repository, name, password string) error {
+	sql := fmt.Sprintf("ALTER USER %s IDENTIFIED BY %s;", userID(name), mysql.Quote{}.Value(password))
+	_, _, err := repo.Exec(ctx, sql)
+	return err
+}
+
+// grantDatabaseAccess escapes the wildcard characters of the database name,
+// as GRANT treats "_" and "%" in database names as patterns
+func grantDatabaseAccess(ctx context.Context, repo rel.Repository, dbName, dbUser string) error {
+	pattern := strings.NewReplacer("_", `\_`, "%", `\%`).Replace(dbName)
+	sql := fmt.Sprintf("GRANT ALL PRIVILEGES ON %s.* TO %s;", mysql.Quote{}.ID(pattern), userID(dbUser))
+	_, _, err := repo.Exec(ctx, sql)
+	return err
+}
+
+func killUserSessions(ctx context.Context, repo rel.Repository, name string) error {
+	var processes []process
+	err := repo.FindAll(ctx, &processes, rel.SQL("SELECT ID AS id FROM information_schema.PROCESSLIST WHERE USER = ?;", name))
+	if err != nil {
+		return err
+	}
+	for _, p := range processes {
+		if _, _, err := repo.Exec(ctx, fmt.Sprintf("KILL %d;", p.ID)); err != nil && !isUnknownThread(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// isUnknownThread reports whether a session ended on its own before it could be killed
+func isUnknownThread(err error) bool {
+	var myErr *mysqldriver.MySQLError
+	return errors.As(err, &myErr) && myErr.Number == 1094
+}
+
+func dropDatabase(ctx context.Context, repo rel.Repository, name string) error {
+	sql := fmt.Sprintf("DROP DATABASE IF EXISTS %s;", mysql.Quote{}.ID(name))
+	_, _, err := repo.Exec(ctx, sql)
+	return err
+}
+
+func dropUser(ctx context.Context, repo rel.Repository, name string) error {
+	sql := fmt.Sprintf("DROP USER IF EXISTS %s;", userID(name))
+	_, _, err := repo.Exec(ctx, sql)
+	return err
+}
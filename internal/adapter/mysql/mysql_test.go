@@ -0,0 +1,396 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	adapterpkg "github.com/razzie-cloud/database-broker/internal/adapter"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+func startMySQLContainer(t *testing.T) (testcontainers.Container, string, int) {
+	req := testcontainers.ContainerRequest{
+		Image:        "mysql:8",
+		ExposedPorts: []string{"3306/tcp"},
+		Env: map[string]string{
+			"MYSQL_ROOT_PASSWORD": "adminpass",
+		},
+		// the image restarts the server after initialization
+		WaitingFor: wait.ForAll(
+			wait.ForLog("ready for connections").WithOccurrence(2),
+			wait.ForListeningPort("3306/tcp"),
+		).WithDeadline(2 * time.Minute),
+	}
+	container, err := testcontainers.GenericContainer(context.Background(), testcontainers.GenericContainerRequest{
+		ContainerRequest: req,
+		Started:          true,
+	})
+	require.NoError(t, err)
+
+	host, err := container.Host(context.Background())
+	require.NoError(t, err)
+	portStr, err := container.MappedPort(context.Background(), "3306")
+	require.NoError(t, err)
+	port := portStr.Int()
+	uri := fmt.Sprintf("mysql://root:adminpass@%s:%d/broker", host, port)
+	return container, uri, port
+}
+
+func openDB(t *testing.T, uri string) *sql.DB {
+	cfg, err := parseURI(uri)
+	require.NoError(t, err)
+	connector, err := mysqldriver.NewConnector(cfg)
+	require.NoError(t, err)
+	return sql.OpenDB(connector)
+}
+
+func TestMySQLAdapterIntegration(t *testing.T) {
+	ctx := context.Background()
+	container, uri, _ := startMySQLContainer(t)
+	defer container.Terminate(ctx)
+
+	adapter, err := New(uri)
+	require.NoError(t, err)
+	defer adapter.Close()
+
+	foo, err := adapter.GetOrCreateInstance(ctx, "foo")
+	require.NoError(t, err)
+	bar, err := adapter.GetOrCreateInstance(ctx, "bar")
+	require.NoError(t, err)
+
+	instances, err := adapter.GetInstances(ctx)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"foo", "bar"}, instances)
+
+	fooLookup, err := adapter.GetInstance(ctx, "foo")
+	require.NoError(t, err)
+	require.Equal(t, foo.GetURI(), fooLookup.GetURI())
+	_, err = adapter.GetInstance(ctx, "missing")
+	require.ErrorIs(t, err, adapterpkg.ErrInstanceNotFound)
+
+	fooDB := openDB(t, foo.GetURI())
+	defer fooDB.Close()
+	barDB := openDB(t, bar.GetURI())
+	defer barDB.Close()
+
+	// Create table and insert data in foo
+	_, err = fooDB.ExecContext(ctx, `CREATE TABLE test (id INT AUTO_INCREMENT PRIMARY KEY, value TEXT)`)
+	require.NoError(t, err)
+	_, err = fooDB.ExecContext(ctx, `INSERT INTO test (value) VALUES ('foo-value')`)
+	require.NoError(t, err)
+
+	// Check data in foo
+	var fooVal string
+	err = fooDB.QueryRowContext(ctx, `SELECT value FROM test WHERE value = 'foo-value'`).Scan(&fooVal)
+	require.NoError(t, err)
+	require.Equal(t, "foo-value", fooVal)
+
+	// Check foo's database is not accessible from bar
+	var barVal string
+	err = barDB.QueryRowContext(ctx, `SELECT value FROM db_foo.test`).Scan(&barVal)
+	require.Error(t, err)
+	_, err = barDB.ExecContext(ctx, `CREATE TABLE db_foo.other (value TEXT)`)
+	require.Error(t, err)
+
+	// Check the metadata database is not accessible either
+	_, err = fooDB.ExecContext(ctx, `SELECT * FROM broker.instances`)
+	require.Error(t, err)
+}
+
+func TestMySQLAdapterDeleteInstance(t *testing.T) {
+	ctx := context.Background()
+	container, uri, _ := startMySQLContainer(t)
+	defer container.Terminate(ctx)
+
+	adapter, err := New(uri)
+	require.NoError(t, err)
+	defer adapter.Close()
+
+	foo, err := adapter.GetOrCreateInstance(ctx, "foo")
+	require.NoError(t, err)
+
+	// Keep a session open to check that it gets killed
+	fooDB := openDB(t, foo.GetURI())
+	defer fooDB.Close()
+	require.NoError(t, fooDB.PingContext(ctx))
+
+	require.NoError(t, adapter.DeleteInstance(ctx, "foo"))
+	require.ErrorIs(t, adapter.DeleteInstance(ctx, "foo"), adapterpkg.ErrInstanceNotFound)
+
+	instances, err := adapter.GetInstances(ctx)
+	require.NoError(t, err)
+	require.Empty(t, instances)
+
+	adminDB := openDB(t, uri)
+	defer adminDB.Close()
+	var count int
+	err = adminDB.QueryRowContext(ctx, `SELECT COUNT(*) FROM information_schema.SCHEMATA WHERE SCHEMA_NAME = 'db_foo'`).Scan(&count)
+	require.NoError(t, err)
+	require.Zero(t, count)
+	err = adminDB.QueryRowContext(ctx, `SELECT COUNT(*) FROM mysql.user WHERE user LIKE 'user\_foo\_%'`).Scan(&count)
+	require.NoError(t, err)
+	require.Zero(t, count)
+
+	// The instance can be recreated after deletion
+	_, err = adapter.GetOrCreateInstance(ctx, "foo")
+	require.NoError(t, err)
+}
+
+func TestMySQLAdapterRotateCredentials(t *testing.T) {
+	ctx := context.Background()
+	container, uri, _ := startMySQLContainer(t)
+	defer container.Terminate(ctx)
+
+	adapter, err := New(uri)
+	require.NoError(t, err)
+	defer adapter.Close()
+
+	foo, err := adapter.GetOrCreateInstance(ctx, "foo")
+	require.NoError(t, err)
+	fooDB := openDB(t, foo.GetURI())
+	defer fooDB.Close()
+	_, err = fooDB.ExecContext(ctx, `CREATE TABLE test (value TEXT)`)
+	require.NoError(t, err)
+	_, err = fooDB.ExecContext(ctx, `INSERT INTO test (value) VALUES ('foo-value')`)
+	require.NoError(t, err)
+
+	rotated, err := adapter.RotateCredentials(ctx, "foo", adapterpkg.RotateOptions{})
+	require.NoError(t, err)
+	require.NotEqual(t, foo.GetURI(), rotated.GetURI())
+
+	// The stored instance matches the rotated one
+	stored, err := adapter.GetInstance(ctx, "foo")
+	require.NoError(t, err)
+	require.Equal(t, rotated.GetURI(), stored.GetURI())
+
+	// Old password no longer works
+	oldDB := openDB(t, foo.GetURI())
+	defer oldDB.Close()
+	require.Error(t, oldDB.PingContext(ctx))
+
+	// New password works and data is kept
+	newDB := openDB(t, rotated.GetURI())
+	defer newDB.Close()
+	var val string
+	err = newDB.QueryRowContext(ctx, `SELECT value FROM test`).Scan(&val)
+	require.NoError(t, err)
+	require.Equal(t, "foo-value", val)
+
+	_, err = adapter.RotateCredentials(ctx, "missing", adapterpkg.RotateOptions{})
+	require.ErrorIs(t, err, adapterpkg.ErrInstanceNotFound)
+}
+
+func TestMySQLAdapterRotateCredentialsWithGracePeriod(t *testing.T) {
+	ctx := context.Background()
+	container, uri, _ := startMySQLContainer(t)
+	defer container.Terminate(ctx)
+
+	adapter, err := New(uri)
+	require.NoError(t, err)
+	defer adapter.Close()
+
+	foo, err := adapter.GetOrCreateInstance(ctx, "foo")
+	require.NoError(t, err)
+
+	rotated, err := adapter.RotateCredentials(ctx, "foo", adapterpkg.RotateOptions{GracePeriod: time.Hour})
+	require.NoError(t, err)
+	resp := rotated.GetJSON().(InstanceResponse)
+	require.NotNil(t, resp.PreviousCredential)
+	require.Equal(t, foo.GetURI(), resp.PreviousCredential.URI)
+
+	// Both credentials work during the grace period and see the same tables
+	oldDB := openDB(t, foo.GetURI())
+	defer oldDB.Close()
+	newDB := openDB(t, rotated.GetURI())
+	defer newDB.Close()
+	_, err = newDB.ExecContext(ctx, `CREATE TABLE test (value TEXT)`)
+	require.NoError(t, err)
+	_, err = oldDB.ExecContext(ctx, `INSERT INTO test (value) VALUES ('foo-value')`)
+	require.NoError(t, err)
+	var val string
+	err = newDB.QueryRowContext(ctx, `SELECT value FROM test`).Scan(&val)
+	require.NoError(t, err)
+	require.Equal(t, "foo-value", val)
+
+	// The previous user is dropped once the grace period ends
+	_, err = adapter.RotateCredentials(ctx, "foo", adapterpkg.RotateOptions{GracePeriod: time.Millisecond})
+	require.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, adapter.(*mysqlAdapter).expireCredentials(ctx))
+	expired, err := adapter.GetInstance(ctx, "foo")
+	require.NoError(t, err)
+	require.Nil(t, expired.GetJSON().(InstanceResponse).PreviousCredential)
+	expiredDB := openDB(t, rotated.GetURI())
+	defer expiredDB.Close()
+	require.Error(t, expiredDB.PingContext(ctx))
+	latestDB := openDB(t, expired.GetURI())
+	defer latestDB.Close()
+	err = latestDB.QueryRowContext(ctx, `SELECT value FROM test`).Scan(&val)
+	require.NoError(t, err)
+}
+
+func TestMySQLAdapterCreateInstance(t *testing.T) {
+	ctx := context.Background()
+	container, uri, _ := startMySQLContainer(t)
+	defer container.Terminate(ctx)
+
+	adapter, err := New(uri)
+	require.NoError(t, err)
+	defer adapter.Close()
+
+	params := json.RawMessage(`{"character_set":"latin1","collation":"latin1_swedish_ci"}`)
+	foo, err := adapter.CreateInstance(ctx, "foo", adapterpkg.CreateOptions{Params: params})
+	require.NoError(t, err)
+	require.Equal(t, adapterpkg.StatusReady, foo.GetJSON().(InstanceResponse).Status)
+
+	_, err = adapter.CreateInstance(ctx, "foo", adapterpkg.CreateOptions{})
+	require.ErrorIs(t, err, adapterpkg.ErrInstanceExists)
+
+	fooDB := openDB(t, foo.GetURI())
+	defer fooDB.Close()
+	var charset, collation string
+	err = fooDB.QueryRowContext(ctx, `SELECT @@character_set_database, @@collation_database`).Scan(&charset, &collation)
+	require.NoError(t, err)
+	require.Equal(t, "latin1", charset)
+	require.Equal(t, "latin1_swedish_ci", collation)
+
+	// A failed creation is kept as failed, and creating it again resumes it
+	_, err = adapter.CreateInstance(ctx, "bar", adapterpkg.CreateOptions{Params: json.RawMessage(`{"character_set":"nonexistent"}`)})
+	require.Error(t, err)
+	bar, err := adapter.GetInstance(ctx, "bar")
+	require.NoError(t, err)
+	require.Equal(t, adapterpkg.StatusFailed, bar.GetJSON().(InstanceResponse).Status)
+	_, err = adapter.RotateCredentials(ctx, "bar", adapterpkg.RotateOptions{})
+	require.ErrorIs(t, err, adapterpkg.ErrInstanceNotReady)
+	bar, err = adapter.GetOrCreateInstance(ctx, "bar")
+	require.NoError(t, err)
+	require.Equal(t, adapterpkg.StatusReady, bar.GetJSON().(InstanceResponse).Status)
+	barDB := openDB(t, bar.GetURI())
+	defer barDB.Close()
+	require.NoError(t, barDB.PingContext(ctx))
+
+	_, err = adapter.CreateInstance(ctx, "baz", adapterpkg.CreateOptions{Params: json.RawMessage(`{"collation":"x; DROP"}`)})
+	require.ErrorIs(t, err, adapterpkg.ErrInvalidParams)
+}
+
+func TestMySQLAdapterConcurrentCreate(t *testing.T) {
+	ctx := context.Background()
+	container, uri, _ := startMySQLContainer(t)
+	defer container.Terminate(ctx)
+
+	adapter, err := New(uri)
+	require.NoError(t, err)
+	defer adapter.Close()
+
+	const workers = 20
+	uris := make([]string, workers)
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	for i := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			instance, err := adapter.GetOrCreateInstance(ctx, "foo")
+			errs[i] = err
+			if err == nil {
+				uris[i] = instance.GetURI()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Every caller gets the same credential, and it's the one that works
+	for i := range workers {
+		require.NoError(t, errs[i])
+		require.Equal(t, uris[0], uris[i])
+	}
+	fooDB := openDB(t, uris[0])
+	defer fooDB.Close()
+	require.NoError(t, fooDB.PingContext(ctx))
+
+	// Concurrent explicit creations have a single winner
+	created := make([]error, workers)
+	for i := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, created[i] = adapter.CreateInstance(ctx, "bar", adapterpkg.CreateOptions{})
+		}()
+	}
+	wg.Wait()
+	var succeeded int
+	for _, err := range created {
+		if err == nil {
+			succeeded++
+		} else {
+			require.ErrorIs(t, err, adapterpkg.ErrInstanceExists)
+		}
+	}
+	require.Equal(t, 1, succeeded)
+
+	adminDB := openDB(t, uri)
+	defer adminDB.Close()
+	var users int
+	err = adminDB.QueryRowContext(ctx, `SELECT COUNT(*) FROM mysql.user WHERE user LIKE 'user\_bar\_%'`).Scan(&users)
+	require.NoError(t, err)
+	require.Equal(t, 1, users)
+}
+
+func TestMySQLAdapterPlans(t *testing.T) {
+	ctx := context.Background()
+	container, uri, _ := startMySQLContainer(t)
+	defer container.Terminate(ctx)
+
+	adapter, err := New(uri, WithPlans(map[string]Plan{
+		"small": {MaxUserConnections: 3, MaxQueriesPerHour: 1000},
+	}))
+	require.NoError(t, err)
+	defer adapter.Close()
+
+	foo, err := adapter.CreateInstance(ctx, "foo", adapterpkg.CreateOptions{Plan: "small"})
+	require.NoError(t, err)
+	resp := foo.GetJSON().(InstanceResponse)
+	require.Equal(t, "small", resp.Plan)
+
+	adminDB := openDB(t, uri)
+	defer adminDB.Close()
+	var maxUserConnections, maxQuestions int
+	err = adminDB.QueryRowContext(ctx, `SELECT max_user_connections, max_questions FROM mysql.user WHERE user = ?`, resp.Username).Scan(&maxUserConnections, &maxQuestions)
+	require.NoError(t, err)
+	require.Equal(t, 3, maxUserConnections)
+	require.Equal(t, 1000, maxQuestions)
+
+	_, err = adapter.CreateInstance(ctx, "bar", adapterpkg.CreateOptions{Plan: "huge"})
+	require.ErrorIs(t, err, adapterpkg.ErrInvalidParams)
+
+	_, err = New(uri, WithPlans(map[string]Plan{"bad": {MaxUserConnections: -1}}))
+	require.Error(t, err)
+}
+
+func TestMySQLAdapterLongInstanceName(t *testing.T) {
+	ctx := context.Background()
+	container, uri, _ := startMySQLContainer(t)
+	defer container.Terminate(ctx)
+
+	adapter, err := New(uri)
+	require.NoError(t, err)
+	defer adapter.Close()
+
+	instanceName := strings.Repeat("long_instance_name_", 3)
+	instance, err := adapter.GetOrCreateInstance(ctx, instanceName)
+	require.NoError(t, err)
+	require.LessOrEqual(t, len(instance.GetJSON().(InstanceResponse).Username), maxUsernameLength)
+	db := openDB(t, instance.GetURI())
+	defer db.Close()
+	require.NoError(t, db.PingContext(ctx))
+}
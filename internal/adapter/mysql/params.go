@@ -0,0 +1,25 @@
+package mysql
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/razzie-cloud/database-broker/internal/adapter"
+)
+
+var validIdentifier = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+type CreateParams struct {
+	CharacterSet string `json:"character_set,omitempty"`
+	Collation    string `json:"collation,omitempty"`
+}
+
+func (p CreateParams) validate() error {
+	if p.CharacterSet != "" && !validIdentifier.MatchString(p.CharacterSet) {
+		return fmt.Errorf("%w: invalid character set: %s", adapter.ErrInvalidParams, p.CharacterSet)
+	}
+	if p.Collation != "" && !validIdentifier.MatchString(p.Collation) {
+		return fmt.Errorf("%w: invalid collation: %s", adapter.ErrInvalidParams, p.Collation)
+	}
+	return nil
+}
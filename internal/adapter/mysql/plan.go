@@ -0,0 +1,33 @@
+package mysql
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-rel/rel"
+)
+
+// Plan describes the resource limits applied to the users of an instance
+type Plan struct {
+	MaxUserConnections    int `json:"max_user_connections,omitempty"`
+	MaxConnectionsPerHour int `json:"max_connections_per_hour,omitempty"`
+	MaxQueriesPerHour     int `json:"max_queries_per_hour,omitempty"`
+	MaxUpdatesPerHour     int `json:"max_updates_per_hour,omitempty"`
+}
+
+func (p Plan) validate() error {
+	if p.MaxUserConnections < 0 || p.MaxConnectionsPerHour < 0 || p.MaxQueriesPerHour < 0 || p.MaxUpdatesPerHour < 0 {
+		return fmt.Errorf("limits can't be negative")
+	}
+	return nil
+}
+
+func applyPlan(ctx context.Context, repo rel.Repository, dbUser string, plan Plan) error {
+	if plan == (Plan{}) {
+		return nil
+	}
+	sql := fmt.Sprintf("ALTER USER %s WITH MAX_USER_CONNECTIONS %d MAX_CONNECTIONS_PER_HOUR %d MAX_QUERIES_PER_HOUR %d MAX_UPDATES_PER_HOUR %d;",
+		userID(dbUser), plan.MaxUserConnections, plan.MaxConnectionsPerHour, plan.MaxQueriesPerHour, plan.MaxUpdatesPerHour)
+	_, _, err := repo.Exec(ctx, sql)
+	return err
+}
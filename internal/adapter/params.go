@@ -0,0 +1,21 @@
+package adapter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// DecodeParams decodes adapter specific params into v, rejecting unknown fields.
+// Empty params leave v untouched.
+func DecodeParams(params json.RawMessage, v any) error {
+	if len(params) == 0 {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(params))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidParams, err)
+	}
+	return nil
+}
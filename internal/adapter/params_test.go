@@ -0,0 +1,62 @@
+package adapter
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeParams(t *testing.T) {
+	type params struct {
+		Value string `json:"value"`
+	}
+
+	t.Run("empty params", func(t *testing.T) {
+		p := params{Value: "default"}
+		assert.NoError(t, DecodeParams(nil, &p))
+		assert.Equal(t, "default", p.Value)
+	})
+
+	t.Run("known fields", func(t *testing.T) {
+		var p params
+		assert.NoError(t, DecodeParams(json.RawMessage(`{"value":"x"}`), &p))
+		assert.Equal(t, "x", p.Value)
+	})
+
+	t.Run("unknown fields", func(t *testing.T) {
+		var p params
+		err := DecodeParams(json.RawMessage(`{"other":"x"}`), &p)
+		assert.ErrorIs(t, err, ErrInvalidParams)
+	})
+}
+
+func TestResolvePlan(t *testing.T) {
+	plans := map[string]int{"small": 1, "large": 3}
+
+	t.Run("requested plan", func(t *testing.T) {
+		name, plan, err := ResolvePlan(plans, "large")
+		assert.NoError(t, err)
+		assert.Equal(t, "large", name)
+		assert.Equal(t, 3, plan)
+	})
+
+	t.Run("unknown plan", func(t *testing.T) {
+		_, _, err := ResolvePlan(plans, "medium")
+		assert.ErrorIs(t, err, ErrInvalidParams)
+	})
+
+	t.Run("no plan without default", func(t *testing.T) {
+		name, plan, err := ResolvePlan(plans, "")
+		assert.NoError(t, err)
+		assert.Empty(t, name)
+		assert.Zero(t, plan)
+	})
+
+	t.Run("no plan with default", func(t *testing.T) {
+		name, plan, err := ResolvePlan(map[string]int{"default": 2}, "")
+		assert.NoError(t, err)
+		assert.Equal(t, "default", name)
+		assert.Equal(t, 2, plan)
+	})
+}
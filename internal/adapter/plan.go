@@ -0,0 +1,22 @@
+package adapter
+
+import "fmt"
+
+const DefaultPlan = "default"
+
+// ResolvePlan looks up the requested plan. Without a requested plan it falls back to
+// the "default" plan if there is one, otherwise it returns an empty name and a zero plan.
+func ResolvePlan[P any](plans map[string]P, name string) (string, P, error) {
+	var zero P
+	if name == "" {
+		if _, ok := plans[DefaultPlan]; !ok {
+			return "", zero, nil
+		}
+		name = DefaultPlan
+	}
+	plan, ok := plans[name]
+	if !ok {
+		return "", zero, fmt.Errorf("%w: unknown plan: %s", ErrInvalidParams, name)
+	}
+	return name, plan, nil
+}
@@ -0,0 +1,10 @@
+package adapter
+
+import "context"
+
+// Claimer is implemented by the adapters that can hand out a pre-provisioned instance under a new name.
+// ClaimInstance renames the pooled instance and applies the expiry of opts, its plan and params have to be empty.
+// Returns ErrInstanceExists if the instance name is taken, leaving the pooled instance untouched.
+type Claimer interface {
+	ClaimInstance(ctx context.Context, pooledName, instanceName string, opts CreateOptions) (Instance, error)
+}
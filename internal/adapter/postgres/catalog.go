@@ -0,0 +1,20 @@
+package postgres
+
+type pgDatabase struct {
+	Name       string `db:"datname"`
+	IsTemplate bool   `db:"datistemplate"`
+}
+
+func (pgDatabase) Table() string { return "pg_database" }
+
+type pgRole struct {
+	Name string `db:"rolname"`
+}
+
+func (pgRole) Table() string { return "pg_roles" }
+
+type pgNamespace struct {
+	Name string `db:"nspname"`
+}
+
+func (pgNamespace) Table() string { return "pg_namespace" }
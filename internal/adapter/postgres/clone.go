@@ -0,0 +1,121 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/razzie-cloud/database-broker/internal/adapter"
+	"github.com/razzie-cloud/database-broker/internal/util"
+
+	"github.com/go-rel/postgres"
+	"github.com/go-rel/rel"
+)
+
+var _ adapter.Cloner = (*postgresAdapter)(nil)
+
+// CloneInstance copies the database of the instance with CREATE DATABASE ... TEMPLATE, which needs the
+// source database to have no sessions, so they get terminated. Schemas can't be copied that way.
+func (pg *postgresAdapter) CloneInstance(ctx context.Context, instanceName, cloneName string) (adapter.Instance, error) {
+	if pg.schemaMode {
+		return nil, fmt.Errorf("%w: cloning is not supported in schema mode", adapter.ErrInvalidParams)
+	}
+	dbUser := "user_" + cloneName + "_" + strings.ToLower(util.RandToken(4))
+	clone := &Instance{
+		InstanceName: cloneName,
+		Host:         pg.host,
+		Port:         pg.port,
+		Database:     "db_" + cloneName,
+		Owner:        dbUser,
+		Username:     dbUser,
+		Password:     util.RandPassword(),
+		Status:       adapter.StatusProvisioning,
+		CreatedAt:    time.Now().UTC(),
+	}
+	// the source is locked too, so it can't be deleted while it's being copied
+	err := pg.withInstanceLock(ctx, cloneName, func() error {
+		return pg.withInstanceLock(ctx, instanceName, func() error {
+			source, err := pg.getInstance(ctx, instanceName)
+			if err != nil {
+				return err
+			}
+			if source.Status != adapter.StatusReady {
+				return fmt.Errorf("%w: %s is %s", adapter.ErrInstanceNotReady, instanceName, source.Status)
+			}
+			if source.Schema != "" {
+				return fmt.Errorf("%w: schema instances can't be cloned", adapter.ErrInvalidParams)
+			}
+			clone.Plan = source.Plan
+			clone.Seeds = source.Seeds
+			clone.Extensions = source.Extensions
+			if err := pg.reserveInstance(ctx, clone); err != nil {
+				return err
+			}
+			if err := pg.createCloneDatabase(ctx, source, clone); err != nil {
+				pg.failInstance(ctx, clone, err)
+				return err
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return clone, nil
+}
+
+func (pg *postgresAdapter) createCloneDatabase(ctx context.Context, source, clone *Instance) error {
+	if err := terminateDatabaseSessions(ctx, pg.repo, source.Database); err != nil {
+		return fmt.Errorf("terminate db sessions: %w", err)
+	}
+	createdDatabase, err := createDatabase(ctx, pg.repo, clone.Database, CreateParams{Template: source.Database})
+	if err != nil {
+		return err
+	}
+	err = pg.repo.Transaction(ctx, func(txCtx context.Context) error {
+		return setupDatabaseOwner(txCtx, pg.repo, clone, pg.plans[clone.Plan])
+	})
+	if err == nil {
+		err = pg.reassignClonedObjects(ctx, source, clone)
+	}
+	if err == nil {
+		clone.Status = adapter.StatusReady
+		if err = pg.repo.Update(ctx, clone); err != nil {
+			err = fmt.Errorf("update instance: %w", err)
+		}
+	}
+	// the roles got committed before the objects could be reassigned to them, so they're dropped too
+	if err != nil && createdDatabase {
+		if cleanupErr := dropDatabaseInstance(context.WithoutCancel(ctx), pg.repo, clone); cleanupErr != nil {
+			log.Printf("cleanup instance %s: %v", clone.InstanceName, cleanupErr)
+		}
+	}
+	return err
+}
+
+// reassignClonedObjects hands the objects of the copied database over to the clone's owner role.
+// REASSIGN OWNED also hands over the databases owned by the source role, so its database is given back.
+func (pg *postgresAdapter) reassignClonedObjects(ctx context.Context, source, clone *Instance) error {
+	cloneAdapter, cloneRepo, err := pg.openDatabase(clone.Database)
+	if err != nil {
+		return err
+	}
+	defer cloneAdapter.Close()
+	return cloneRepo.Transaction(ctx, func(txCtx context.Context) error {
+		if err := reassignOwned(txCtx, cloneRepo, source.Owner, clone.Owner); err != nil {
+			return fmt.Errorf("reassign owned objects: %w", err)
+		}
+		if err := transferDatabaseOwnership(txCtx, cloneRepo, source.Database, source.Owner); err != nil {
+			return fmt.Errorf("transfer db ownership: %w", err)
+		}
+		return nil
+	})
+}
+
+func reassignOwned(ctx context.Context, repo rel.Repository, oldOwner, newOwner string) error {
+	sql := fmt.Sprintf("REASSIGN OWNED BY %s TO %s;", postgres.Quote{}.ID(oldOwner), postgres.Quote{}.ID(newOwner))
+	_, _, err := repo.Exec(ctx, sql)
+	return err
+}
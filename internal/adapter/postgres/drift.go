@@ -0,0 +1,153 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/razzie-cloud/database-broker/internal/adapter"
+
+	"github.com/go-rel/rel"
+)
+
+var _ adapter.DriftDetector = (*postgresAdapter)(nil)
+
+// DetectDrift compares the instance records with the databases, schemas and roles named like the ones of instances.
+// Repair only restores missing login roles, as a missing owner role or database means the data is gone.
+// It covers the instances of both modes, as the broker only runs it once for the shared records.
+func (pg *postgresAdapter) DetectDrift(ctx context.Context, repair bool) (*adapter.DriftReport, error) {
+	var instances []Instance
+	if err := pg.repo.FindAll(ctx, &instances, rel.SortAsc("instance_name")); err != nil {
+		return nil, fmt.Errorf("find instances: %w", err)
+	}
+	databases, err := findDatabaseNames(ctx, pg.repo, `db\_%`)
+	if err != nil {
+		return nil, fmt.Errorf("find databases: %w", err)
+	}
+	roles, err := findRoleNames(ctx, pg.repo, `user\_%`)
+	if err != nil {
+		return nil, fmt.Errorf("find roles: %w", err)
+	}
+	delete(databases, pg.sharedDatabase)
+	schemas := map[string]bool{}
+	if pg.sharedRepo != nil {
+		if schemas, err = findSchemaNames(ctx, pg.sharedRepo, `schema\_%`); err != nil {
+			return nil, fmt.Errorf("find schemas: %w", err)
+		}
+	}
+
+	report := &adapter.DriftReport{}
+	for i := range instances {
+		instance := &instances[i]
+		instance.Host = pg.host
+		instance.Port = pg.port
+		var hasDatabase bool
+		if instance.Schema != "" {
+			hasDatabase = schemas[instance.Schema] || pg.sharedRepo == nil
+			delete(schemas, instance.Schema)
+		} else {
+			hasDatabase = databases[instance.Database]
+			delete(databases, instance.Database)
+		}
+		var missingRoles []string
+		for _, role := range instance.roles() {
+			if !roles[role] {
+				missingRoles = append(missingRoles, role)
+			}
+			delete(roles, role)
+		}
+		// the resources of instances that aren't ready were cleaned up or are still being created
+		if instance.Status != adapter.StatusReady {
+			continue
+		}
+		if !hasDatabase {
+			report.MissingDatabases = append(report.MissingDatabases, instance.InstanceName)
+		}
+		if len(missingRoles) == 0 {
+			continue
+		}
+		if repair && hasDatabase && !slices.Contains(missingRoles, instance.Owner) {
+			if err := pg.restoreLoginRoles(ctx, instance, missingRoles); err != nil {
+				return nil, fmt.Errorf("restore roles of %s: %w", instance.InstanceName, err)
+			}
+			report.Repaired = append(report.Repaired, instance.InstanceName)
+			continue
+		}
+		report.MissingUsers = append(report.MissingUsers, instance.InstanceName)
+	}
+	report.OrphanedDatabases = append(slices.Sorted(maps.Keys(databases)), slices.Sorted(maps.Keys(schemas))...)
+	report.OrphanedUsers = slices.Sorted(maps.Keys(roles))
+	return report, nil
+}
+
+// restoreLoginRoles recreates the login roles that act as the owner role with their stored passwords
+func (pg *postgresAdapter) restoreLoginRoles(ctx context.Context, instance *Instance, roles []string) error {
+	return pg.repo.Transaction(ctx, func(txCtx context.Context) error {
+		for _, role := range roles {
+			password := instance.Password
+			if role == instance.PreviousUsername {
+				password = instance.PreviousPassword
+			}
+			if err := createUser(txCtx, pg.repo, role, password); err != nil {
+				return fmt.Errorf("create role: %w", err)
+			}
+			if err := applyPlan(txCtx, pg.repo, role, pg.plans[instance.Plan]); err != nil {
+				return fmt.Errorf("apply plan: %w", err)
+			}
+			if err := grantOwnerRole(txCtx, pg.repo, instance.Owner, role); err != nil {
+				return fmt.Errorf("grant owner role: %w", err)
+			}
+			if err := grantDatabaseAccess(txCtx, pg.repo, instance.Database, role); err != nil {
+				return fmt.Errorf("grant db connect access: %w", err)
+			}
+			if instance.Schema != "" {
+				if err := setUserSearchPath(txCtx, pg.repo, role, instance.Schema); err != nil {
+					return fmt.Errorf("set role search path: %w", err)
+				}
+			}
+			if role == instance.PreviousUsername && instance.PreviousExpiresAt != nil {
+				if err := expireUserPassword(txCtx, pg.repo, role, *instance.PreviousExpiresAt); err != nil {
+					return fmt.Errorf("expire role password: %w", err)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func findDatabaseNames(ctx context.Context, repo rel.Repository, pattern string) (map[string]bool, error) {
+	var databases []pgDatabase
+	if err := repo.FindAll(ctx, &databases, rel.Like("datname", pattern)); err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool, len(databases))
+	for _, db := range databases {
+		names[db.Name] = true
+	}
+	return names, nil
+}
+
+func findRoleNames(ctx context.Context, repo rel.Repository, pattern string) (map[string]bool, error) {
+	var roles []pgRole
+	if err := repo.FindAll(ctx, &roles, rel.Like("rolname", pattern)); err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool, len(roles))
+	for _, role := range roles {
+		names[role.Name] = true
+	}
+	return names, nil
+}
+
+func findSchemaNames(ctx context.Context, repo rel.Repository, pattern string) (map[string]bool, error) {
+	var schemas []pgNamespace
+	if err := repo.FindAll(ctx, &schemas, rel.Like("nspname", pattern)); err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool, len(schemas))
+	for _, schema := range schemas {
+		names[schema.Name] = true
+	}
+	return names, nil
+}
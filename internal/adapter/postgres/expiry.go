@@ -0,0 +1,81 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/razzie-cloud/database-broker/internal/adapter"
+
+	"github.com/go-rel/rel"
+)
+
+var _ adapter.Leaser = (*postgresAdapter)(nil)
+
+// DeleteExpiredInstances checks the expiry again while holding the lock of the instance name,
+// so instances being created or deleted in the meantime are left alone.
+// It covers the instances of both modes, as the broker only runs it once for the shared records.
+func (pg *postgresAdapter) DeleteExpiredInstances(ctx context.Context, now time.Time) ([]string, error) {
+	var instances []Instance
+	err := pg.repo.FindAll(ctx, &instances, rel.Select("instance_name"), rel.Lte("expires_at", now), rel.SortAsc("instance_name"))
+	if err != nil {
+		return nil, fmt.Errorf("find expired instances: %w", err)
+	}
+	var deleted []string
+	var errs []error
+	for _, expired := range instances {
+		err := pg.withInstanceLock(ctx, expired.InstanceName, func() error {
+			instance, err := pg.findInstance(ctx, rel.Eq("instance_name", expired.InstanceName))
+			if err == adapter.ErrInstanceNotFound {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if !instance.isExpired(now) {
+				return nil
+			}
+			if err := pg.deleteInstance(ctx, instance); err != nil {
+				return err
+			}
+			deleted = append(deleted, instance.InstanceName)
+			return nil
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("delete expired instance %s: %w", expired.InstanceName, err))
+		}
+	}
+	return deleted, errors.Join(errs...)
+}
+
+func (pg *postgresAdapter) RenewLease(ctx context.Context, instanceName, leaseID string) (adapter.Instance, error) {
+	instance := &Instance{
+		Host: pg.host,
+		Port: pg.port,
+	}
+	err := pg.repo.Transaction(ctx, func(txCtx context.Context) error {
+		err := pg.repo.Find(txCtx, instance, rel.Eq("instance_name", instanceName), pg.modeFilter(), rel.ForUpdate())
+		if err == rel.ErrNotFound {
+			return adapter.ErrInstanceNotFound
+		}
+		if err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		// an expired lease can't be renewed, as the instance may be getting deleted already
+		if instance.LeaseID == "" || instance.LeaseID != leaseID || instance.isExpired(now) {
+			return fmt.Errorf("%w: %s", adapter.ErrInvalidLease, leaseID)
+		}
+		expiresAt := now.Add(instance.LeasePeriod)
+		instance.ExpiresAt = &expiresAt
+		if err := pg.repo.Update(txCtx, instance); err != nil {
+			return fmt.Errorf("update instance: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return instance, nil
+}
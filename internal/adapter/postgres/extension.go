@@ -0,0 +1,93 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/razzie-cloud/database-broker/internal/adapter"
+
+	"github.com/go-rel/postgres"
+	"github.com/go-rel/rel"
+	"github.com/jackc/pgerrcode"
+)
+
+var _ adapter.ExtensionInstaller = (*postgresAdapter)(nil)
+
+// InstallExtensions installs the extensions into the database of a ready instance.
+// Extensions are database-wide, so schema mode doesn't support them.
+func (pg *postgresAdapter) InstallExtensions(ctx context.Context, instanceName string, extensions []string) (adapter.Instance, error) {
+	if pg.schemaMode {
+		return nil, fmt.Errorf("%w: extensions are not supported in schema mode", adapter.ErrInvalidParams)
+	}
+	if len(extensions) == 0 {
+		return nil, fmt.Errorf("%w: no extensions", adapter.ErrInvalidParams)
+	}
+	if err := pg.checkExtensions(extensions); err != nil {
+		return nil, err
+	}
+	var instance *Instance
+	err := pg.withInstanceLock(ctx, instanceName, func() error {
+		var err error
+		instance, err = pg.getInstance(ctx, instanceName)
+		if err != nil {
+			return err
+		}
+		if instance.Status != adapter.StatusReady {
+			return fmt.Errorf("%w: %s is %s", adapter.ErrInstanceNotReady, instanceName, instance.Status)
+		}
+		if instance.Schema != "" {
+			return fmt.Errorf("%w: extensions are not supported by schema instances", adapter.ErrInvalidParams)
+		}
+		if err := pg.createExtensions(ctx, instance, extensions); err != nil {
+			return err
+		}
+		if err := pg.repo.Update(ctx, instance); err != nil {
+			return fmt.Errorf("update instance: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return instance, nil
+}
+
+func (pg *postgresAdapter) checkExtensions(extensions []string) error {
+	for _, extension := range extensions {
+		if !slices.Contains(pg.extensions, extension) {
+			return fmt.Errorf("%w: extension not allowed: %s", adapter.ErrInvalidParams, extension)
+		}
+	}
+	return nil
+}
+
+// createExtensions runs CREATE EXTENSION as the admin user inside the instance database,
+// as the instance roles aren't allowed to, and adds the extensions to the instance
+func (pg *postgresAdapter) createExtensions(ctx context.Context, instance *Instance, extensions []string) error {
+	dbAdapter, dbRepo, err := pg.openDatabase(instance.Database)
+	if err != nil {
+		return err
+	}
+	defer dbAdapter.Close()
+	for _, extension := range extensions {
+		err := createExtension(ctx, dbRepo, extension)
+		if isPgError(err, pgerrcode.UndefinedFile) {
+			return fmt.Errorf("%w: extension is not available on the server: %s", adapter.ErrInvalidParams, extension)
+		}
+		if err != nil {
+			return fmt.Errorf("create extension %s: %w", extension, err)
+		}
+	}
+	installed := append(instance.extensions(), extensions...)
+	slices.Sort(installed)
+	instance.Extensions = strings.Join(slices.Compact(installed), ",")
+	return nil
+}
+
+func createExtension(ctx context.Context, repo rel.Repository, name string) error {
+	sql := fmt.Sprintf("CREATE EXTENSION IF NOT EXISTS %s;", postgres.Quote{}.ID(name))
+	_, _, err := repo.Exec(ctx, sql)
+	return err
+}
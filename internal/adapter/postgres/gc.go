@@ -0,0 +1,98 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/razzie-cloud/database-broker/internal/adapter"
+
+	"github.com/go-rel/rel"
+)
+
+var _ adapter.GarbageCollector = (*postgresAdapter)(nil)
+
+// DropOrphans drops the given databases, schemas and roles if no instance record refers to them.
+// Databases and schemas are checked while holding the lock of the instance name, so an instance being created can't claim them.
+func (pg *postgresAdapter) DropOrphans(ctx context.Context, databases, users []string) error {
+	var errs []error
+	for _, name := range databases {
+		var err error
+		if instanceName, ok := strings.CutPrefix(name, "schema_"); ok {
+			err = pg.withInstanceLock(ctx, instanceName, func() error {
+				return pg.dropOrphanedSchema(ctx, name)
+			})
+		} else if instanceName, ok := strings.CutPrefix(name, "db_"); ok {
+			err = pg.withInstanceLock(ctx, instanceName, func() error {
+				return pg.dropOrphanedDatabase(ctx, name)
+			})
+		} else {
+			err = fmt.Errorf("not an instance database: %s", name)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("drop %s: %w", name, err))
+		}
+	}
+	for _, name := range users {
+		if err := pg.dropOrphanedRole(ctx, name); err != nil {
+			errs = append(errs, fmt.Errorf("drop %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (pg *postgresAdapter) dropOrphanedDatabase(ctx context.Context, name string) error {
+	if claimed, err := pg.isClaimed(ctx, rel.Eq("db_name", name).AndEq("db_schema", "")); claimed || err != nil {
+		return err
+	}
+	if err := terminateDatabaseSessions(ctx, pg.repo, name); err != nil {
+		return fmt.Errorf("terminate db sessions: %w", err)
+	}
+	return dropDatabase(ctx, pg.repo, name)
+}
+
+func (pg *postgresAdapter) dropOrphanedSchema(ctx context.Context, name string) error {
+	if pg.sharedRepo == nil {
+		return fmt.Errorf("schemas need schema mode")
+	}
+	if claimed, err := pg.isClaimed(ctx, rel.Eq("db_schema", name)); claimed || err != nil {
+		return err
+	}
+	return dropSchema(ctx, pg.sharedRepo, name)
+}
+
+// dropOrphanedRole drops a role and the objects it owns. Roles are created in the same transaction that saves
+// or updates their instance record, so a role without a record can't be claimed later.
+func (pg *postgresAdapter) dropOrphanedRole(ctx context.Context, name string) error {
+	claimed, err := pg.isClaimed(ctx, rel.Or(rel.Eq("db_user", name), rel.Eq("db_owner", name), rel.Eq("prev_db_user", name)))
+	if claimed || err != nil {
+		return err
+	}
+	return pg.dropRole(ctx, name)
+}
+
+// dropRole drops a role after the objects it owns and its privileges in the admin and shared databases
+func (pg *postgresAdapter) dropRole(ctx context.Context, name string) error {
+	if err := terminateUserSessions(ctx, pg.repo, name); err != nil {
+		return fmt.Errorf("terminate role sessions: %w", err)
+	}
+	// revokes the privileges the role got in the admin database, like CONNECT on instance databases
+	if err := dropOwned(ctx, pg.repo, []string{name}); err != nil {
+		return fmt.Errorf("drop owned objects: %w", err)
+	}
+	if pg.sharedRepo != nil {
+		if err := dropOwned(ctx, pg.sharedRepo, []string{name}); err != nil {
+			return fmt.Errorf("drop owned objects: %w", err)
+		}
+	}
+	return dropUser(ctx, pg.repo, name)
+}
+
+func (pg *postgresAdapter) isClaimed(ctx context.Context, filter rel.FilterQuery) (bool, error) {
+	count, err := pg.repo.Count(ctx, "instances", filter)
+	if err != nil {
+		return false, fmt.Errorf("count instances: %w", err)
+	}
+	return count > 0, nil
+}
@@ -5,55 +5,173 @@ import (
 	"net"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/razzie-cloud/database-broker/internal/adapter"
+	"github.com/razzie-cloud/database-broker/internal/util"
 )
 
 var _ adapter.Instance = (*Instance)(nil)
 
 type Instance struct {
-	InstanceName string    `db:"instance_name,primary"`
-	Host         string    `db:"-"`
-	Port         int       `db:"-"`
-	Database     string    `db:"db_name"`
-	Username     string    `db:"db_user"`
-	Password     string    `db:"db_password"`
-	CreatedAt    time.Time `db:"created_at"`
+	InstanceName      string         `db:"instance_name,primary"`
+	Host              string         `db:"-"`
+	Port              int            `db:"-"`
+	Database          string         `db:"db_name"`
+	Schema            string         `db:"db_schema"`
+	Owner             string         `db:"db_owner"`
+	Username          string         `db:"db_user"`
+	Password          string         `db:"db_password"`
+	PreviousUsername  string         `db:"prev_db_user"`
+	PreviousPassword  string         `db:"prev_db_password"`
+	PreviousExpiresAt *time.Time     `db:"prev_expires_at"`
+	Plan              string         `db:"plan"`
+	Status            adapter.Status `db:"status"`
+	ExpiresAt         *time.Time     `db:"expires_at"`
+	LeaseID           string         `db:"lease_id"`
+	LeasePeriod       time.Duration  `db:"lease_period"`
+	Seeds             string         `db:"seeds"`
+	Extensions        string         `db:"extensions"`
+	Adopted           bool           `db:"adopted"`
+	CreatedAt         time.Time      `db:"created_at"`
 }
 
 func (Instance) Table() string { return "instances" }
 
 func (i Instance) GetURI() string {
-	return buildConnURI(i.Host, i.Port, i.Database, i.Username, i.Password)
+	return buildConnURI(i.Host, i.Port, i.Database, i.Schema, i.Username, i.Password)
 }
 
 func (i Instance) GetJSON() any {
 	return InstanceResponse{
-		Instance:  i.InstanceName,
-		Host:      i.Host,
-		Port:      i.Port,
-		Database:  i.Database,
-		Username:  i.Username,
-		Password:  i.Password,
-		URI:       i.GetURI(),
-		CreatedAt: i.CreatedAt,
+		Instance:           i.InstanceName,
+		Host:               i.Host,
+		Port:               i.Port,
+		Database:           i.Database,
+		Schema:             i.Schema,
+		Username:           i.Username,
+		Password:           i.Password,
+		URI:                i.GetURI(),
+		PreviousCredential: i.previousCredential(),
+		Plan:               i.Plan,
+		Status:             i.Status,
+		ExpiresAt:          i.ExpiresAt,
+		Lease:              i.lease(),
+		Seeds:              i.seeds(),
+		Extensions:         i.extensions(),
+		CreatedAt:          i.CreatedAt,
+	}
+}
+
+// roles returns every role that belongs to the instance, with the owner role last
+func (i Instance) roles() []string {
+	var roles []string
+	for _, role := range []string{i.Username, i.PreviousUsername} {
+		if role != "" && role != i.Owner {
+			roles = append(roles, role)
+		}
+	}
+	return append(roles, i.Owner)
+}
+
+// setExpiry sets the time-to-live or the lease of opts, counted from the creation time
+func (i *Instance) setExpiry(opts adapter.CreateOptions) error {
+	if opts.TTL > 0 && opts.Lease > 0 {
+		return fmt.Errorf("%w: ttl and lease can't be combined", adapter.ErrInvalidParams)
+	}
+	if opts.TTL > 0 {
+		expiresAt := i.CreatedAt.Add(opts.TTL)
+		i.ExpiresAt = &expiresAt
+	}
+	if opts.Lease > 0 {
+		expiresAt := i.CreatedAt.Add(opts.Lease)
+		i.ExpiresAt = &expiresAt
+		i.LeaseID = strings.ToLower(util.RandToken(8))
+		i.LeasePeriod = opts.Lease
+	}
+	return nil
+}
+
+func (i Instance) isExpired(now time.Time) bool {
+	return i.ExpiresAt != nil && !i.ExpiresAt.After(now)
+}
+
+func (i Instance) lease() *LeaseResponse {
+	if i.LeaseID == "" {
+		return nil
+	}
+	return &LeaseResponse{
+		ID:     i.LeaseID,
+		Period: i.LeasePeriod.String(),
+	}
+}
+
+// seeds returns the names of the seed files the instance got populated with
+func (i Instance) seeds() []string {
+	if i.Seeds == "" {
+		return nil
+	}
+	return strings.Split(i.Seeds, ",")
+}
+
+// extensions returns the names of the extensions installed into the instance's database
+func (i Instance) extensions() []string {
+	if i.Extensions == "" {
+		return nil
+	}
+	return strings.Split(i.Extensions, ",")
+}
+
+func (i Instance) previousCredential() *CredentialResponse {
+	if i.PreviousUsername == "" || i.PreviousExpiresAt == nil || !i.PreviousExpiresAt.After(time.Now()) {
+		return nil
+	}
+	return &CredentialResponse{
+		Username:  i.PreviousUsername,
+		Password:  i.PreviousPassword,
+		URI:       buildConnURI(i.Host, i.Port, i.Database, i.Schema, i.PreviousUsername, i.PreviousPassword),
+		ExpiresAt: *i.PreviousExpiresAt,
 	}
 }
 
 type InstanceResponse struct {
-	Instance  string    `json:"instance"`
-	Host      string    `json:"host"`
-	Port      int       `json:"port"`
-	Database  string    `json:"database"`
+	Instance           string              `json:"instance"`
+	Host               string              `json:"host"`
+	Port               int                 `json:"port"`
+	Database           string              `json:"database"`
+	Schema             string              `json:"schema,omitempty"`
+	Username           string              `json:"username"`
+	Password           string              `json:"password"`
+	URI                string              `json:"uri"`
+	PreviousCredential *CredentialResponse `json:"previous_credential,omitempty"`
+	Plan               string              `json:"plan,omitempty"`
+	Status             adapter.Status      `json:"status"`
+	ExpiresAt          *time.Time          `json:"expires_at,omitempty"`
+	Lease              *LeaseResponse      `json:"lease,omitempty"`
+	Seeds              []string            `json:"seeds,omitempty"`
+	Extensions         []string            `json:"extensions,omitempty"`
+	CreatedAt          time.Time           `json:"created_at"`
+}
+
+type CredentialResponse struct {
 	Username  string    `json:"username"`
 	Password  string    `json:"password"`
 	URI       string    `json:"uri"`
-	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
 }
 
-func buildConnURI(host string, port int, db, user, pass string) string {
+type LeaseResponse struct {
+	ID     string `json:"id"`
+	Period string `json:"period"`
+}
+
+func buildConnURI(host string, port int, db, schema, user, pass string) string {
 	userpass := url.UserPassword(user, pass).String()
 	addr := net.JoinHostPort(host, strconv.Itoa(port))
-	return fmt.Sprintf("postgres://%s@%s/%s?sslmode=prefer", userpass, addr, db)
+	uri := fmt.Sprintf("postgres://%s@%s/%s?sslmode=prefer", userpass, addr, db)
+	if schema != "" {
+		uri += "&options=" + url.QueryEscape("-csearch_path="+schema)
+	}
+	return uri
 }
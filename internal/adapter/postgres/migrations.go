@@ -3,6 +3,8 @@ package postgres
 import (
 	"context"
 
+	"github.com/razzie-cloud/database-broker/internal/adapter"
+
 	"github.com/go-rel/migration"
 	"github.com/go-rel/rel"
 )
@@ -22,8 +24,112 @@ func RollbackCreateInstances(schema *rel.Schema) {
 	schema.DropTable("instances")
 }
 
+func MigrateAddCredentialRotation(schema *rel.Schema) {
+	schema.AddColumn("instances", "db_owner", rel.Text, rel.Default(""))
+	schema.AddColumn("instances", "prev_db_user", rel.Text, rel.Default(""))
+	schema.AddColumn("instances", "prev_db_password", rel.Text, rel.Default(""))
+	schema.AddColumn("instances", "prev_expires_at", rel.DateTime)
+	schema.Exec("UPDATE instances SET db_owner = db_user;")
+}
+
+func RollbackAddCredentialRotation(schema *rel.Schema) {
+	schema.DropColumn("instances", "prev_expires_at")
+	schema.DropColumn("instances", "prev_db_password")
+	schema.DropColumn("instances", "prev_db_user")
+	schema.DropColumn("instances", "db_owner")
+}
+
+func MigrateAddPlan(schema *rel.Schema) {
+	schema.AddColumn("instances", "plan", rel.Text, rel.Default(""))
+}
+
+func RollbackAddPlan(schema *rel.Schema) {
+	schema.DropColumn("instances", "plan")
+}
+
+func MigrateAddSchema(schema *rel.Schema) {
+	schema.AddColumn("instances", "db_schema", rel.Text, rel.Default(""))
+}
+
+func RollbackAddSchema(schema *rel.Schema) {
+	schema.DropColumn("instances", "db_schema")
+}
+
+func MigrateAddStatus(schema *rel.Schema) {
+	schema.AddColumn("instances", "status", rel.Text, rel.Default(string(adapter.StatusReady)))
+}
+
+func RollbackAddStatus(schema *rel.Schema) {
+	schema.DropColumn("instances", "status")
+}
+
+func MigrateAddExpiry(schema *rel.Schema) {
+	schema.AddColumn("instances", "expires_at", rel.DateTime)
+}
+
+func RollbackAddExpiry(schema *rel.Schema) {
+	schema.DropColumn("instances", "expires_at")
+}
+
+func MigrateAddLease(schema *rel.Schema) {
+	schema.AddColumn("instances", "lease_id", rel.Text, rel.Default(""))
+	schema.AddColumn("instances", "lease_period", rel.BigInt, rel.Default(0))
+}
+
+func RollbackAddLease(schema *rel.Schema) {
+	schema.DropColumn("instances", "lease_period")
+	schema.DropColumn("instances", "lease_id")
+}
+
+func MigrateCreateTemplates(schema *rel.Schema) {
+	schema.CreateTable("templates", func(t *rel.Table) {
+		t.Text("template_name")
+		t.PrimaryKey("template_name")
+		t.Text("db_name")
+		t.DateTime("created_at", rel.Default("NOW()"))
+	})
+}
+
+func RollbackCreateTemplates(schema *rel.Schema) {
+	schema.DropTable("templates")
+}
+
+func MigrateAddSeeds(schema *rel.Schema) {
+	schema.AddColumn("instances", "seeds", rel.Text, rel.Default(""))
+}
+
+func RollbackAddSeeds(schema *rel.Schema) {
+	schema.DropColumn("instances", "seeds")
+}
+
+func MigrateAddExtensions(schema *rel.Schema) {
+	schema.AddColumn("instances", "extensions", rel.Text, rel.Default(""))
+}
+
+func RollbackAddExtensions(schema *rel.Schema) {
+	schema.DropColumn("instances", "extensions")
+}
+
+func MigrateAddAdopted(schema *rel.Schema) {
+	schema.AddColumn("instances", "adopted", rel.Bool, rel.Default(false))
+}
+
+func RollbackAddAdopted(schema *rel.Schema) {
+	schema.DropColumn("instances", "adopted")
+}
+
 func migrate(repo rel.Repository) {
 	m := migration.New(repo)
 	m.Register(1, MigrateCreateInstances, RollbackCreateInstances)
+	m.Register(2, MigrateAddCredentialRotation, RollbackAddCredentialRotation)
+	m.Register(3, MigrateAddPlan, RollbackAddPlan)
+	m.Register(4, MigrateAddSchema, RollbackAddSchema)
+	m.Register(5, MigrateAddStatus, RollbackAddStatus)
+	m.Register(6, MigrateAddExpiry, RollbackAddExpiry)
+	m.Register(7, MigrateAddLease, RollbackAddLease)
+	m.Register(8, MigrateCreateTemplates, RollbackCreateTemplates)
+	m.Register(9, MigrateAddSeeds, RollbackAddSeeds)
+	m.Register(10, MigrateAddExtensions, RollbackAddExtensions)
+	m.Register(11, MigrateAddAdopted, RollbackAddAdopted)
 	m.Migrate(context.Background())
 }
@@ -0,0 +1,44 @@
+package postgres
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/razzie-cloud/database-broker/internal/adapter"
+)
+
+var (
+	validSettingValue = regexp.MustCompile(`^[A-Za-z0-9_.@-]+$`)
+	validIdentifier   = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+)
+
+type CreateParams struct {
+	Encoding        string `json:"encoding,omitempty"`
+	Locale          string `json:"locale,omitempty"`
+	ConnectionLimit int    `json:"connection_limit,omitempty"`
+	Template        string `json:"template,omitempty"`
+	// AdoptExisting allows taking over a database with the instance's name that already exists
+	AdoptExisting bool `json:"adopt_existing,omitempty"`
+	// Extensions are installed into the database, they have to be allowed by the adapter
+	Extensions []string `json:"extensions,omitempty"`
+}
+
+func (p CreateParams) validate() error {
+	if p.Encoding != "" && !validSettingValue.MatchString(p.Encoding) {
+		return fmt.Errorf("%w: invalid encoding: %s", adapter.ErrInvalidParams, p.Encoding)
+	}
+	if p.Locale != "" && !validSettingValue.MatchString(p.Locale) {
+		return fmt.Errorf("%w: invalid locale: %s", adapter.ErrInvalidParams, p.Locale)
+	}
+	if p.ConnectionLimit < 0 {
+		return fmt.Errorf("%w: invalid connection limit: %d", adapter.ErrInvalidParams, p.ConnectionLimit)
+	}
+	if p.Template != "" && !validIdentifier.MatchString(p.Template) {
+		return fmt.Errorf("%w: invalid template: %s", adapter.ErrInvalidParams, p.Template)
+	}
+	return nil
+}
+
+func (p CreateParams) isEmpty() bool {
+	return p.Encoding == "" && p.Locale == "" && p.ConnectionLimit == 0 && p.Template == "" && !p.AdoptExisting && len(p.Extensions) == 0
+}
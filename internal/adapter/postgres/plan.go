@@ -0,0 +1,46 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/go-rel/postgres"
+	"github.com/go-rel/rel"
+)
+
+var validSettingName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+
+// Plan describes the limits applied to the login roles of an instance
+type Plan struct {
+	ConnectionLimit int               `json:"connection_limit,omitempty"`
+	Settings        map[string]string `json:"settings,omitempty"`
+}
+
+func (p Plan) validate() error {
+	if p.ConnectionLimit < 0 {
+		return fmt.Errorf("invalid connection limit: %d", p.ConnectionLimit)
+	}
+	for name := range p.Settings {
+		if !validSettingName.MatchString(name) {
+			return fmt.Errorf("invalid setting name: %s", name)
+		}
+	}
+	return nil
+}
+
+func applyPlan(ctx context.Context, repo rel.Repository, dbUser string, plan Plan) error {
+	if plan.ConnectionLimit > 0 {
+		sql := fmt.Sprintf("ALTER ROLE %s CONNECTION LIMIT %d;", postgres.Quote{}.ID(dbUser), plan.ConnectionLimit)
+		if _, _, err := repo.Exec(ctx, sql); err != nil {
+			return err
+		}
+	}
+	for name, value := range plan.Settings {
+		sql := fmt.Sprintf("ALTER ROLE %s SET %s = %s;", postgres.Quote{}.ID(dbUser), name, postgres.Quote{}.Value(value))
+		if _, _, err := repo.Exec(ctx, sql); err != nil {
+			return err
+		}
+	}
+	return nil
+}
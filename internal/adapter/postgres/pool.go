@@ -0,0 +1,129 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/razzie-cloud/database-broker/internal/adapter"
+
+	"github.com/go-rel/postgres"
+	"github.com/go-rel/rel"
+	"github.com/jackc/pgerrcode"
+)
+
+var _ adapter.Claimer = (*postgresAdapter)(nil)
+
+// ClaimInstance renames the database or schema of the pooled instance and moves its record to the new name.
+// The roles keep their names, as renaming a role clears its MD5 password.
+func (pg *postgresAdapter) ClaimInstance(ctx context.Context, pooledName, instanceName string, opts adapter.CreateOptions) (adapter.Instance, error) {
+	if opts.Plan != "" || len(opts.Params) > 0 {
+		return nil, fmt.Errorf("%w: pooled instances have no plan or params", adapter.ErrInvalidParams)
+	}
+	var instance *Instance
+	err := pg.withInstanceLock(ctx, instanceName, func() error {
+		return pg.withInstanceLock(ctx, pooledName, func() error {
+			// the name may be taken by an instance of the other mode too
+			if _, err := pg.findInstance(ctx, rel.Eq("instance_name", instanceName)); err != adapter.ErrInstanceNotFound {
+				if err == nil {
+					return adapter.ErrInstanceExists
+				}
+				return err
+			}
+			pooled, err := pg.getInstance(ctx, pooledName)
+			if err != nil {
+				return err
+			}
+			if pooled.Status != adapter.StatusReady {
+				return fmt.Errorf("%w: %s is %s", adapter.ErrInstanceNotReady, pooledName, pooled.Status)
+			}
+			claimed := *pooled
+			claimed.InstanceName = instanceName
+			claimed.CreatedAt = time.Now().UTC()
+			if err := claimed.setExpiry(opts); err != nil {
+				return err
+			}
+			if claimed.Schema != "" {
+				claimed.Schema = "schema_" + instanceName
+				err = pg.renameSchemaInstance(ctx, pooled, &claimed)
+			} else {
+				claimed.Database = "db_" + instanceName
+				err = pg.renameDatabaseInstance(ctx, pooled, &claimed)
+			}
+			if err != nil {
+				return err
+			}
+			instance = &claimed
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return instance, nil
+}
+
+func (pg *postgresAdapter) renameDatabaseInstance(ctx context.Context, pooled, claimed *Instance) error {
+	if err := terminateDatabaseSessions(ctx, pg.repo, pooled.Database); err != nil {
+		return fmt.Errorf("terminate db sessions: %w", err)
+	}
+	return pg.repo.Transaction(ctx, func(txCtx context.Context) error {
+		if err := renameDatabase(txCtx, pg.repo, pooled.Database, claimed.Database); err != nil {
+			if isPgError(err, pgerrcode.DuplicateDatabase) {
+				return fmt.Errorf("%w: database %s already exists", adapter.ErrConflict, claimed.Database)
+			}
+			return fmt.Errorf("rename database: %w", err)
+		}
+		return pg.moveInstanceRecord(txCtx, pooled, claimed)
+	})
+}
+
+// renameSchemaInstance renames the schema in the shared database, which is a connection of its own,
+// so the schema gets renamed back by hand if the record can't be moved
+func (pg *postgresAdapter) renameSchemaInstance(ctx context.Context, pooled, claimed *Instance) error {
+	if pg.sharedRepo == nil {
+		return fmt.Errorf("instance %s needs schema mode", pooled.InstanceName)
+	}
+	if err := renameSchema(ctx, pg.sharedRepo, pooled.Schema, claimed.Schema); err != nil {
+		if isPgError(err, pgerrcode.DuplicateSchema) {
+			return fmt.Errorf("%w: schema %s already exists", adapter.ErrConflict, claimed.Schema)
+		}
+		return fmt.Errorf("rename schema: %w", err)
+	}
+	err := pg.repo.Transaction(ctx, func(txCtx context.Context) error {
+		for _, role := range claimed.roles() {
+			if err := setUserSearchPath(txCtx, pg.repo, role, claimed.Schema); err != nil {
+				return fmt.Errorf("set role search path: %w", err)
+			}
+		}
+		return pg.moveInstanceRecord(txCtx, pooled, claimed)
+	})
+	if err != nil {
+		if renameErr := renameSchema(context.WithoutCancel(ctx), pg.sharedRepo, claimed.Schema, pooled.Schema); renameErr != nil {
+			return fmt.Errorf("%w (rename schema back: %v)", err, renameErr)
+		}
+	}
+	return err
+}
+
+func (pg *postgresAdapter) moveInstanceRecord(ctx context.Context, pooled, claimed *Instance) error {
+	if err := pg.repo.Delete(ctx, pooled); err != nil {
+		return fmt.Errorf("delete pooled instance: %w", err)
+	}
+	if err := pg.repo.Insert(ctx, claimed); err != nil {
+		return fmt.Errorf("save instance: %w", err)
+	}
+	return nil
+}
+
+func renameDatabase(ctx context.Context, repo rel.Repository, name, newName string) error {
+	sql := fmt.Sprintf("ALTER DATABASE %s RENAME TO %s;", postgres.Quote{}.ID(name), postgres.Quote{}.ID(newName))
+	_, _, err := repo.Exec(ctx, sql)
+	return err
+}
+
+func renameSchema(ctx context.Context, repo rel.Repository, name, newName string) error {
+	sql := fmt.Sprintf("ALTER SCHEMA %s RENAME TO %s;", postgres.Quote{}.ID(name), postgres.Quote{}.ID(newName))
+	_, _, err := repo.Exec(ctx, sql)
+	return err
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net/url"
 	"strings"
 	"time"
 
@@ -20,20 +21,112 @@ import (
 )
 
 type postgresAdapter struct {
-	adapter rel.Adapter
-	repo    rel.Repository
-	host    string
-	port    int
+	adapter        rel.Adapter
+	repo           rel.Repository
+	uri            string
+	host           string
+	port           int
+	plans          map[string]Plan
+	sharedDatabase string
+	schemaMode     bool
+	sharedAdapter  rel.Adapter
+	sharedRepo     rel.Repository
+	seedDir        string
+	seeds          []adapter.Seed
+	extensions     []string
 }
 
-func New(postgresUri string) (adapter.Interface, error) {
+type Option func(pg *postgresAdapter)
+
+// WithPlans sets the provisioning plans callers can choose from at instance creation
+func WithPlans(plans map[string]Plan) Option {
+	return func(pg *postgresAdapter) {
+		pg.plans = plans
+	}
+}
+
+// WithSchemaMode makes the adapter provision a schema and a role per instance inside
+// the given shared database, instead of a whole database per instance
+func WithSchemaMode(sharedDatabase string) Option {
+	return func(pg *postgresAdapter) {
+		pg.sharedDatabase = sharedDatabase
+		pg.schemaMode = true
+	}
+}
+
+// WithSharedDatabase lets the adapter expire and garbage collect the schema instances of the shared database,
+// which a schema mode adapter of the same server provisions, while it keeps serving the whole database instances
+func WithSharedDatabase(sharedDatabase string) Option {
+	return func(pg *postgresAdapter) {
+		pg.sharedDatabase = sharedDatabase
+	}
+}
+
+// modeFilter matches the instance records of the adapter's mode. Both modes of a server share the records,
+// so instance names are unique across them, but each adapter only serves the instances of its own mode.
+func (pg *postgresAdapter) modeFilter() rel.FilterQuery {
+	if pg.schemaMode {
+		return rel.Ne("db_schema", "")
+	}
+	return rel.Eq("db_schema", "")
+}
+
+// WithExtensions sets the database extensions callers can have installed into their instances
+func WithExtensions(extensions []string) Option {
+	return func(pg *postgresAdapter) {
+		pg.extensions = extensions
+	}
+}
+
+// WithSeedDir sets the directory of the SQL files (*.sql) that new instances get populated with, in the order of their names.
+// They run as the instance user right after its creation.
+func WithSeedDir(dir string) Option {
+	return func(pg *postgresAdapter) {
+		pg.seedDir = dir
+	}
+}
+
+func New(postgresUri string, opts ...Option) (adapter.Interface, error) {
 	host, port, err := util.GetURIHostPort(postgresUri, 5432)
 	if err != nil {
 		return nil, fmt.Errorf("parse postgres uri: %w", err)
 	}
+	pg := &postgresAdapter{
+		uri:  postgresUri,
+		host: host,
+		port: port,
+	}
+	for _, opt := range opts {
+		opt(pg)
+	}
+	for name, plan := range pg.plans {
+		if err := plan.validate(); err != nil {
+			return nil, fmt.Errorf("invalid plan %s: %w", name, err)
+		}
+	}
+	if pg.seedDir != "" {
+		if pg.seeds, err = adapter.LoadSeeds(pg.seedDir, "*.sql"); err != nil {
+			return nil, err
+		}
+	}
+	pg.adapter, pg.repo, err = openRepository(postgresUri)
+	if err != nil {
+		return nil, err
+	}
+	migrate(pg.repo)
+	if pg.sharedDatabase != "" {
+		if err := pg.setupSharedDatabase(context.Background()); err != nil {
+			pg.Close()
+			return nil, fmt.Errorf("setup shared database: %w", err)
+		}
+	}
+	return pg, nil
+}
+
+func openRepository(postgresUri string) (rel.Adapter, rel.Repository, error) {
 	db, err := sql.Open("pgx", postgresUri)
 	if err != nil {
-		return nil, fmt.Errorf("open postgres: %w", err)
+		return nil, nil, fmt.Errorf("open postgres: %w", err)
 	}
 	adapter := postgres.New(db)
 	repo := rel.New(adapter)
@@ -44,22 +137,40 @@ func New(postgresUri string) (adapter.Interface, error) {
 			}
 		}
 	}))
-	migrate(repo)
-	return &postgresAdapter{
-		adapter: adapter,
-		repo:    repo,
-		host:    host,
-		port:    port,
-	}, nil
+	return adapter, repo, nil
+}
+
+// openDatabase connects to another database of the server as the admin user
+func (pg *postgresAdapter) openDatabase(name string) (rel.Adapter, rel.Repository, error) {
+	uri, err := url.Parse(pg.uri)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse postgres uri: %w", err)
+	}
+	uri.Path = "/" + name
+	return openRepository(uri.String())
+}
+
+var _ adapter.SharedRecords = (*postgresAdapter)(nil)
+
+// RecordsID identifies the instance records by the server and database holding them, leaving out the credentials
+func (pg *postgresAdapter) RecordsID() string {
+	uri, err := url.Parse(pg.uri)
+	if err != nil {
+		return ""
+	}
+	return "postgres://" + uri.Host + uri.Path
 }
 
 func (pg *postgresAdapter) Close() error {
+	if pg.sharedAdapter != nil {
+		pg.sharedAdapter.Close()
+	}
 	return pg.adapter.Close()
 }
 
 func (pg *postgresAdapter) GetInstances(ctx context.Context) ([]string, error) {
 	var instances []Instance
-	err := pg.repo.FindAll(ctx, &instances, rel.Select("instance_name"), rel.SortAsc("instance_name"))
+	err := pg.repo.FindAll(ctx, &instances, rel.Select("instance_name"), pg.modeFilter(), rel.SortAsc("instance_name"))
 	if err != nil {
 		return nil, err
 	}
@@ -71,77 +182,489 @@ func (pg *postgresAdapter) GetInstances(ctx context.Context) ([]string, error) {
 }
 
 func (pg *postgresAdapter) GetInstance(ctx context.Context, instanceName string) (adapter.Instance, error) {
-	instance := Instance{
-		Host: pg.host,
-		Port: pg.port,
-	}
-	err := pg.repo.Find(ctx, &instance, rel.Eq("instance_name", instanceName))
+	instance, err := pg.getInstance(ctx, instanceName)
 	if err != nil {
 		return nil, err
 	}
-	return &instance, nil
+	return instance, nil
 }
 
+// GetOrCreateInstance waits for a creation in progress and resumes failed instances
 func (pg *postgresAdapter) GetOrCreateInstance(ctx context.Context, instanceName string) (adapter.Instance, error) {
-	instance, err := pg.GetInstance(ctx, instanceName)
-	if err == nil {
+	instance, err := pg.getInstance(ctx, instanceName)
+	if err == nil && instance.Status == adapter.StatusProvisioning {
+		err = pg.withInstanceLock(ctx, instanceName, func() error {
+			instance, err = pg.getInstance(ctx, instanceName)
+			return err
+		})
+	}
+	// an instance still provisioning once the lock is free got interrupted, so it's resumed like a failed one
+	switch {
+	case err == adapter.ErrInstanceNotFound:
+	case err != nil:
+		return nil, err
+	case instance.Status == adapter.StatusReady:
+		return instance, nil
+	}
+	created, err := pg.CreateInstance(ctx, instanceName, adapter.CreateOptions{})
+	if err == adapter.ErrInstanceExists {
+		// the name may be taken by an instance of the other mode
+		instance, err = pg.getInstance(ctx, instanceName)
+		if err == adapter.ErrInstanceNotFound {
+			return nil, adapter.ErrInstanceExists
+		}
+		if err != nil {
+			return nil, err
+		}
 		return instance, nil
 	}
-	if err != rel.ErrNotFound {
+	return created, err
+}
+
+// CreateInstance reserves the instance record as provisioning, then creates the resources of the instance.
+// If that fails, the created resources are dropped and the instance is marked as failed, so creating it again resumes it.
+func (pg *postgresAdapter) CreateInstance(ctx context.Context, instanceName string, opts adapter.CreateOptions) (adapter.Instance, error) {
+	var params CreateParams
+	if err := adapter.DecodeParams(opts.Params, &params); err != nil {
+		return nil, err
+	}
+	if err := params.validate(); err != nil {
+		return nil, err
+	}
+	planName, plan, err := adapter.ResolvePlan(pg.plans, opts.Plan)
+	if err != nil {
+		return nil, err
+	}
+	if err := pg.checkExtensions(params.Extensions); err != nil {
 		return nil, err
 	}
-	dbName := "db_" + instanceName
+	if params.Template != "" {
+		if params.Template, err = pg.resolveTemplate(ctx, params.Template); err != nil {
+			return nil, err
+		}
+	}
 	dbUser := "user_" + instanceName + "_" + strings.ToLower(util.RandToken(4))
-	dbPass := util.RandPassword()
-	if err := createDatabase(ctx, pg.repo, dbName); err != nil {
+	instance := &Instance{
+		InstanceName: instanceName,
+		Host:         pg.host,
+		Port:         pg.port,
+		Database:     "db_" + instanceName,
+		Owner:        dbUser,
+		Username:     dbUser,
+		Password:     util.RandPassword(),
+		Plan:         planName,
+		Status:       adapter.StatusProvisioning,
+		CreatedAt:    time.Now().UTC(),
+	}
+	if err := instance.setExpiry(opts); err != nil {
 		return nil, err
 	}
-	err = pg.repo.Transaction(ctx, func(txCtx context.Context) error {
-		if err := createUser(txCtx, pg.repo, dbUser, dbPass); err != nil {
-			return fmt.Errorf("create role: %w", err)
+	// the database may exist already, so it's kept if the creation gets interrupted
+	instance.Adopted = params.AdoptExisting
+	if pg.schemaMode {
+		if !params.isEmpty() {
+			return nil, fmt.Errorf("%w: database params are not supported in schema mode", adapter.ErrInvalidParams)
 		}
-		instance = &Instance{
-			InstanceName: instanceName,
-			Host:         pg.host,
-			Port:         pg.port,
-			Database:     dbName,
-			Username:     dbUser,
-			Password:     dbPass,
-			CreatedAt:    time.Now().UTC(),
+		instance.Database = pg.sharedDatabase
+		instance.Schema = "schema_" + instanceName
+	}
+	err = pg.withInstanceLock(ctx, instanceName, func() error {
+		if err := pg.reserveInstance(ctx, instance); err != nil {
+			return err
 		}
-		if err := pg.repo.Insert(txCtx, instance); err != nil {
-			return fmt.Errorf("insert instance: %w", err)
+		var err error
+		if instance.Schema != "" {
+			err = pg.createSchemaInstance(ctx, instance, plan)
+		} else {
+			err = pg.createDatabaseInstance(ctx, instance, params, plan)
 		}
-		if err := transferDatabaseOwnership(txCtx, pg.repo, dbName, dbUser); err != nil {
-			return fmt.Errorf("transfer db ownership: %w", err)
+		if err != nil {
+			pg.failInstance(ctx, instance, err)
 		}
-		if err := revokePublicDatabaseAccess(txCtx, pg.repo, dbName); err != nil {
-			return fmt.Errorf("revoke db public access: %w", err)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return instance, nil
+}
+
+// withInstanceLock runs fn while holding the lock of the instance name, which serializes its creation and deletion.
+// The lock is held by a transaction of its own, so fn has to use ctx and its changes get committed as it goes.
+func (pg *postgresAdapter) withInstanceLock(ctx context.Context, instanceName string, fn func() error) error {
+	return pg.repo.Transaction(ctx, func(txCtx context.Context) error {
+		if _, _, err := pg.repo.Exec(txCtx, "SELECT pg_advisory_xact_lock(hashtext($1));", "instance:"+instanceName); err != nil {
+			return fmt.Errorf("lock instance name: %w", err)
+		}
+		return fn()
+	})
+}
+
+// reserveInstance saves the record of a provisioning instance while holding the lock of its name.
+// A failed instance gets taken over, and so does one still provisioning, as its creator would hold the lock,
+// so its creation got interrupted, e.g. by a crash. Any other existing instance is a conflict.
+func (pg *postgresAdapter) reserveInstance(ctx context.Context, instance *Instance) error {
+	existing, err := pg.getInstance(ctx, instance.InstanceName)
+	switch {
+	case err == adapter.ErrInstanceNotFound:
+		err = pg.repo.Insert(ctx, instance)
+	case err != nil:
+		return err
+	case existing.Status == adapter.StatusProvisioning:
+		if err := pg.dropInterruptedInstance(ctx, existing); err != nil {
+			return fmt.Errorf("cleanup interrupted instance: %w", err)
+		}
+		err = pg.repo.Update(ctx, instance)
+	case existing.Status != adapter.StatusFailed:
+		return adapter.ErrInstanceExists
+	default:
+		err = pg.repo.Update(ctx, instance)
+	}
+	if errors.Is(err, rel.ErrUniqueConstraint) {
+		return adapter.ErrInstanceExists
+	}
+	if err != nil {
+		return fmt.Errorf("save instance: %w", err)
+	}
+	return nil
+}
+
+// createDatabaseInstance commits the roles before the instance is marked as ready, as the objects copied from a template
+// are handed over to them and the seeds run over connections of their own, which don't see uncommitted roles.
+func (pg *postgresAdapter) createDatabaseInstance(ctx context.Context, instance *Instance, params CreateParams, plan Plan) error {
+	createdDatabase, err := createDatabase(ctx, pg.repo, instance.Database, params)
+	if err != nil {
+		return err
+	}
+	if len(params.Extensions) > 0 {
+		err = pg.createExtensions(ctx, instance, params.Extensions)
+	}
+	if err == nil {
+		err = pg.repo.Transaction(ctx, func(txCtx context.Context) error {
+			return setupDatabaseOwner(txCtx, pg.repo, instance, plan)
+		})
+	}
+	// an adopted database already existed, so nothing got copied into it
+	if err == nil && createdDatabase && params.Template != "" {
+		err = pg.reassignTemplateObjects(ctx, params.Template, instance)
+	}
+	// adopted databases already hold data of their own
+	if err == nil && len(pg.seeds) > 0 && !params.AdoptExisting {
+		err = pg.seedInstance(ctx, instance)
+	}
+	if err == nil {
+		instance.Status = adapter.StatusReady
+		if err = pg.repo.Update(ctx, instance); err != nil {
+			err = fmt.Errorf("update instance: %w", err)
 		}
-		if err := grantDatabaseAccess(txCtx, pg.repo, dbName, dbUser); err != nil {
-			return fmt.Errorf("grant db connect access: %w", err)
+	}
+	// the roles may have been committed, so they're dropped along with the database. The roles owning
+	// an adopted database are kept, garbage collection drops them once the database got a new owner.
+	if err != nil && createdDatabase {
+		if cleanupErr := dropDatabaseInstance(context.WithoutCancel(ctx), pg.repo, instance); cleanupErr != nil {
+			log.Printf("cleanup instance %s: %v", instance.InstanceName, cleanupErr)
 		}
+	}
+	return err
+}
+
+// setupDatabaseOwner creates the owner role of the instance and hands the database over to it
+func setupDatabaseOwner(ctx context.Context, repo rel.Repository, instance *Instance, plan Plan) error {
+	if err := createUser(ctx, repo, instance.Owner, instance.Password); err != nil {
+		return fmt.Errorf("create role: %w", err)
+	}
+	if err := applyPlan(ctx, repo, instance.Owner, plan); err != nil {
+		return fmt.Errorf("apply plan: %w", err)
+	}
+	if err := transferDatabaseOwnership(ctx, repo, instance.Database, instance.Owner); err != nil {
+		return fmt.Errorf("transfer db ownership: %w", err)
+	}
+	if err := revokePublicDatabaseAccess(ctx, repo, instance.Database); err != nil {
+		return fmt.Errorf("revoke db public access: %w", err)
+	}
+	if err := grantDatabaseAccess(ctx, repo, instance.Database, instance.Owner); err != nil {
+		return fmt.Errorf("grant db connect access: %w", err)
+	}
+	return nil
+}
+
+// failInstance marks the instance as failed after its resources got cleaned up.
+// A conflict leaves nothing to resume, so the record gets deleted instead.
+func (pg *postgresAdapter) failInstance(ctx context.Context, instance *Instance, cause error) {
+	ctx = context.WithoutCancel(ctx)
+	var err error
+	if errors.Is(cause, adapter.ErrConflict) {
+		err = pg.repo.Delete(ctx, instance)
+	} else {
+		instance.Status = adapter.StatusFailed
+		err = pg.repo.Update(ctx, instance)
+	}
+	if err != nil {
+		log.Printf("mark instance %s as failed: %v", instance.InstanceName, err)
+	}
+}
+
+// dropInterruptedInstance drops whatever an interrupted creation left behind. A database that may have been adopted
+// is kept along with the roles owning it, garbage collection drops them once the database got a new owner.
+func (pg *postgresAdapter) dropInterruptedInstance(ctx context.Context, instance *Instance) error {
+	if instance.Adopted {
 		return nil
+	}
+	if instance.Schema != "" {
+		if pg.sharedRepo == nil {
+			return fmt.Errorf("instance %s needs schema mode", instance.InstanceName)
+		}
+		if err := dropSchema(ctx, pg.sharedRepo, instance.Schema); err != nil {
+			return fmt.Errorf("drop schema: %w", err)
+		}
+	} else {
+		if err := terminateDatabaseSessions(ctx, pg.repo, instance.Database); err != nil {
+			return fmt.Errorf("terminate db sessions: %w", err)
+		}
+		if err := dropDatabase(ctx, pg.repo, instance.Database); err != nil {
+			return fmt.Errorf("drop database: %w", err)
+		}
+	}
+	// the roles may not have been created yet
+	var names []any
+	for _, role := range instance.roles() {
+		names = append(names, role)
+	}
+	var roles []pgRole
+	if err := pg.repo.FindAll(ctx, &roles, rel.In("rolname", names...)); err != nil {
+		return fmt.Errorf("find roles: %w", err)
+	}
+	for _, role := range roles {
+		if err := pg.dropRole(ctx, role.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteInstance also removes instances whose provisioning failed or got interrupted
+func (pg *postgresAdapter) DeleteInstance(ctx context.Context, instanceName string) error {
+	return pg.withInstanceLock(ctx, instanceName, func() error {
+		instance, err := pg.getInstance(ctx, instanceName)
+		if err != nil {
+			return err
+		}
+		return pg.deleteInstance(ctx, instance)
 	})
+}
+
+func (pg *postgresAdapter) deleteInstance(ctx context.Context, instance *Instance) error {
+	if err := pg.dropInstance(ctx, instance); err != nil {
+		return err
+	}
+	if err := pg.repo.Delete(ctx, instance); err != nil {
+		return fmt.Errorf("delete instance: %w", err)
+	}
+	return nil
+}
+
+// dropInstance drops the database or schema of the instance along with its roles
+func (pg *postgresAdapter) dropInstance(ctx context.Context, instance *Instance) error {
+	if instance.Schema != "" {
+		return pg.dropSchemaInstance(ctx, instance)
+	}
+	return dropDatabaseInstance(ctx, pg.repo, instance)
+}
+
+// getInstance only finds the instances of the adapter's mode
+func (pg *postgresAdapter) getInstance(ctx context.Context, instanceName string) (*Instance, error) {
+	return pg.findInstance(ctx, rel.Eq("instance_name", instanceName), pg.modeFilter())
+}
+
+// findInstance returns the instance matching the filters, whichever adapter of the server created it
+func (pg *postgresAdapter) findInstance(ctx context.Context, filters ...rel.FilterQuery) (*Instance, error) {
+	instance := &Instance{
+		Host: pg.host,
+		Port: pg.port,
+	}
+	err := pg.repo.Find(ctx, instance, rel.And(filters...))
+	if err == rel.ErrNotFound {
+		return nil, adapter.ErrInstanceNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
 	return instance, nil
 }
 
-func createDatabase(ctx context.Context, repo rel.Repository, name string) error {
-	sql := fmt.Sprintf("CREATE DATABASE %s", postgres.Quote{}.ID(name))
-	_, _, err := repo.Exec(ctx, sql)
-	if err == nil {
+func dropDatabaseInstance(ctx context.Context, repo rel.Repository, instance *Instance) error {
+	if err := terminateDatabaseSessions(ctx, repo, instance.Database); err != nil {
+		return fmt.Errorf("terminate db sessions: %w", err)
+	}
+	if err := dropDatabase(ctx, repo, instance.Database); err != nil {
+		return fmt.Errorf("drop database: %w", err)
+	}
+	for _, role := range instance.roles() {
+		if err := dropUser(ctx, repo, role); err != nil {
+			return fmt.Errorf("drop role: %w", err)
+		}
+	}
+	return nil
+}
+
+func (pg *postgresAdapter) RotateCredentials(ctx context.Context, instanceName string, opts adapter.RotateOptions) (adapter.Instance, error) {
+	instance := &Instance{
+		Host: pg.host,
+		Port: pg.port,
+	}
+	err := pg.repo.Transaction(ctx, func(txCtx context.Context) error {
+		err := pg.repo.Find(txCtx, instance, rel.Eq("instance_name", instanceName), pg.modeFilter(), rel.ForUpdate())
+		if err == rel.ErrNotFound {
+			return adapter.ErrInstanceNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if instance.Status != adapter.StatusReady {
+			return fmt.Errorf("%w: %s is %s", adapter.ErrInstanceNotReady, instanceName, instance.Status)
+		}
+		if err := retirePreviousUser(txCtx, pg.repo, instance); err != nil {
+			return fmt.Errorf("retire previous role: %w", err)
+		}
+		if opts.GracePeriod > 0 {
+			err = rotateUserWithGracePeriod(txCtx, pg.repo, instance, pg.plans[instance.Plan], opts.GracePeriod)
+		} else {
+			err = rotateUserPassword(txCtx, pg.repo, instance)
+		}
+		if err != nil {
+			return err
+		}
+		if err := pg.repo.Update(txCtx, instance); err != nil {
+			return fmt.Errorf("update instance: %w", err)
+		}
 		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	var pgErr *pgconn.PgError
-	if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.DuplicateDatabase {
+	return instance, nil
+}
+
+func rotateUserPassword(ctx context.Context, repo rel.Repository, instance *Instance) error {
+	dbPass := util.RandPassword()
+	if err := alterUserPassword(ctx, repo, instance.Username, dbPass); err != nil {
+		return fmt.Errorf("alter role password: %w", err)
+	}
+	instance.Password = dbPass
+	return nil
+}
+
+// rotateUserWithGracePeriod creates a new login role that acts as the owner role,
+// while the current one keeps working until the grace period ends
+func rotateUserWithGracePeriod(ctx context.Context, repo rel.Repository, instance *Instance, plan Plan, gracePeriod time.Duration) error {
+	dbUser := "user_" + instance.InstanceName + "_" + strings.ToLower(util.RandToken(4))
+	dbPass := util.RandPassword()
+	expiresAt := time.Now().UTC().Add(gracePeriod)
+	if err := createUser(ctx, repo, dbUser, dbPass); err != nil {
+		return fmt.Errorf("create role: %w", err)
+	}
+	if err := applyPlan(ctx, repo, dbUser, plan); err != nil {
+		return fmt.Errorf("apply plan: %w", err)
+	}
+	if err := grantOwnerRole(ctx, repo, instance.Owner, dbUser); err != nil {
+		return fmt.Errorf("grant owner role: %w", err)
+	}
+	if err := grantDatabaseAccess(ctx, repo, instance.Database, dbUser); err != nil {
+		return fmt.Errorf("grant db connect access: %w", err)
+	}
+	if instance.Schema != "" {
+		if err := setUserSearchPath(ctx, repo, dbUser, instance.Schema); err != nil {
+			return fmt.Errorf("set role search path: %w", err)
+		}
+	}
+	if err := expireUserPassword(ctx, repo, instance.Username, expiresAt); err != nil {
+		return fmt.Errorf("expire role password: %w", err)
+	}
+	instance.PreviousUsername = instance.Username
+	instance.PreviousPassword = instance.Password
+	instance.PreviousExpiresAt = &expiresAt
+	instance.Username = dbUser
+	instance.Password = dbPass
+	return nil
+}
+
+// retirePreviousUser makes sure the credential kept from the last rotation stops working.
+// The owner role can't be dropped, so only its password gets removed.
+func retirePreviousUser(ctx context.Context, repo rel.Repository, instance *Instance) error {
+	if instance.PreviousUsername == "" {
 		return nil
 	}
+	if instance.PreviousUsername == instance.Owner {
+		if err := removeUserPassword(ctx, repo, instance.PreviousUsername); err != nil {
+			return err
+		}
+	} else {
+		if err := revokeDatabaseAccess(ctx, repo, instance.Database, instance.PreviousUsername); err != nil {
+			return err
+		}
+		if err := dropUser(ctx, repo, instance.PreviousUsername); err != nil {
+			return err
+		}
+	}
+	instance.PreviousUsername = ""
+	instance.PreviousPassword = ""
+	instance.PreviousExpiresAt = nil
+	return nil
+}
+
+// checkTemplateDatabase makes sure only databases marked as templates get copied,
+// as the admin connection could use any database as a template
+func checkTemplateDatabase(ctx context.Context, repo rel.Repository, name string) error {
+	var db pgDatabase
+	err := repo.Find(ctx, &db, rel.Eq("datname", name))
+	if err == rel.ErrNotFound || (err == nil && !db.IsTemplate) {
+		return fmt.Errorf("%w: not a template database: %s", adapter.ErrInvalidParams, name)
+	}
 	return err
 }
 
+// createDatabase reports whether the database got created. An existing database is only adopted if the params ask for it,
+// otherwise it's a conflict, as it may belong to someone else.
+func createDatabase(ctx context.Context, repo rel.Repository, name string, params CreateParams) (bool, error) {
+	sql := fmt.Sprintf("CREATE DATABASE %s", postgres.Quote{}.ID(name))
+	template := params.Template
+	if template == "" && (params.Encoding != "" || params.Locale != "") {
+		// the default template1 may not be compatible with other encodings/locales
+		template = "template0"
+	}
+	if template != "" {
+		sql += " TEMPLATE " + postgres.Quote{}.ID(template)
+	}
+	if params.Encoding != "" {
+		sql += " ENCODING " + postgres.Quote{}.Value(params.Encoding)
+	}
+	if params.Locale != "" {
+		sql += " LOCALE " + postgres.Quote{}.Value(params.Locale)
+	}
+	if params.ConnectionLimit > 0 {
+		sql += fmt.Sprintf(" CONNECTION LIMIT %d", params.ConnectionLimit)
+	}
+	_, _, err := repo.Exec(ctx, sql)
+	switch {
+	case err == nil:
+		return true, nil
+	case isPgError(err, pgerrcode.ObjectInUse):
+		// a database can only be copied while nobody is connected to it
+		return false, fmt.Errorf("%w: template database %s is being accessed by other sessions", adapter.ErrConflict, template)
+	case !isPgError(err, pgerrcode.DuplicateDatabase):
+		return false, fmt.Errorf("create database: %w", err)
+	case params.AdoptExisting:
+		return false, nil
+	default:
+		return false, fmt.Errorf("%w: database %s already exists, set adopt_existing to use it", adapter.ErrConflict, name)
+	}
+}
+
+func isPgError(err error, code string) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == code
+}
+
 func createUser(ctx context.Context, repo rel.Repository, name, password string) error {
 	sql := fmt.Sprintf("CREATE ROLE %s LOGIN PASSWORD %s NOSUPERUSER NOCREATEDB NOCREATEROLE NOINHERIT;",
 		postgres.Quote{}.ID(name), postgres.Quote{}.Value(password))
@@ -149,6 +672,37 @@ func createUser(ctx context.Context, repo rel.Repository, name, password string)
 	return err
 }
 
+func alterUserPassword(ctx context.Context, repo rel.Repository, name, password string) error {
+	sql := fmt.Sprintf("ALTER ROLE %s PASSWORD %s;",
+		postgres.Quote{}.ID(name), postgres.Quote{}.Value(password))
+	_, _, err := repo.Exec(ctx, sql)
+	return err
+}
+
+func expireUserPassword(ctx context.Context, repo rel.Repository, name string, validUntil time.Time) error {
+	sql := fmt.Sprintf("ALTER ROLE %s VALID UNTIL %s;",
+		postgres.Quote{}.ID(name), postgres.Quote{}.Value(validUntil.Format(time.RFC3339)))
+	_, _, err := repo.Exec(ctx, sql)
+	return err
+}
+
+func removeUserPassword(ctx context.Context, repo rel.Repository, name string) error {
+	sql := fmt.Sprintf("ALTER ROLE %s PASSWORD NULL;", postgres.Quote{}.ID(name))
+	_, _, err := repo.Exec(ctx, sql)
+	return err
+}
+
+// grantOwnerRole lets a login role act as the owner role, so objects keep a single owner across rotations
+func grantOwnerRole(ctx context.Context, repo rel.Repository, owner, dbUser string) error {
+	sql := fmt.Sprintf("GRANT %s TO %s;", postgres.Quote{}.ID(owner), postgres.Quote{}.ID(dbUser))
+	if _, _, err := repo.Exec(ctx, sql); err != nil {
+		return err
+	}
+	sql = fmt.Sprintf("ALTER ROLE %s SET role = %s;", postgres.Quote{}.ID(dbUser), postgres.Quote{}.Value(owner))
+	_, _, err := repo.Exec(ctx, sql)
+	return err
+}
+
 func transferDatabaseOwnership(ctx context.Context, repo rel.Repository, dbName, dbUser string) error {
 	sql := fmt.Sprintf("ALTER DATABASE %s OWNER TO %s;",
 		postgres.Quote{}.ID(dbName), postgres.Quote{}.ID(dbUser))
@@ -169,3 +723,28 @@ func grantDatabaseAccess(ctx context.Context, repo rel.Repository, dbName, dbUse
 	_, _, err := repo.Exec(ctx, sql)
 	return err
 }
+
+func revokeDatabaseAccess(ctx context.Context, repo rel.Repository, dbName, dbUser string) error {
+	sql := fmt.Sprintf("REVOKE CONNECT ON DATABASE %s FROM %s;",
+		postgres.Quote{}.ID(dbName), postgres.Quote{}.ID(dbUser))
+	_, _, err := repo.Exec(ctx, sql)
+	return err
+}
+
+func terminateDatabaseSessions(ctx context.Context, repo rel.Repository, dbName string) error {
+	sql := "SELECT pg_terminate_backend(pid) FROM pg_stat_activity WHERE datname = $1 AND pid <> pg_backend_pid();"
+	_, _, err := repo.Exec(ctx, sql, dbName)
+	return err
+}
+
+func dropDatabase(ctx context.Context, repo rel.Repository, name string) error {
+	sql := fmt.Sprintf("DROP DATABASE IF EXISTS %s;", postgres.Quote{}.ID(name))
+	_, _, err := repo.Exec(ctx, sql)
+	return err
+}
+
+func dropUser(ctx context.Context, repo rel.Repository, name string) error {
+	sql := fmt.Sprintf("DROP ROLE IF EXISTS %s;", postgres.Quote{}.ID(name))
+	_, _, err := repo.Exec(ctx, sql)
+	return err
+}
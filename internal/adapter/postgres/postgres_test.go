@@ -3,8 +3,16 @@ package postgres
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	adapterpkg "github.com/razzie-cloud/database-broker/internal/adapter"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/stretchr/testify/require"
@@ -55,6 +63,12 @@ func TestPostgresAdapterIntegration(t *testing.T) {
 	require.NoError(t, err)
 	require.ElementsMatch(t, []string{"foo", "bar"}, instances)
 
+	fooLookup, err := adapter.GetInstance(ctx, "foo")
+	require.NoError(t, err)
+	require.Equal(t, foo.GetURI(), fooLookup.GetURI())
+	_, err = adapter.GetInstance(ctx, "missing")
+	require.ErrorIs(t, err, adapterpkg.ErrInstanceNotFound)
+
 	fooDB, err := sql.Open("pgx", foo.GetURI())
 	require.NoError(t, err)
 	defer fooDB.Close()
@@ -94,3 +108,1019 @@ func TestPostgresAdapterIntegration(t *testing.T) {
 	err = fooDB.QueryRowContext(ctx, `SELECT value FROM test WHERE value = 'bar-value'`).Scan(&fooVal)
 	require.Error(t, err)
 }
+
+func TestPostgresAdapterDeleteInstance(t *testing.T) {
+	ctx := context.Background()
+	container, uri, _ := startPostgresContainer(t)
+	defer container.Terminate(ctx)
+
+	adapter, err := New(uri)
+	require.NoError(t, err)
+
+	foo, err := adapter.GetOrCreateInstance(ctx, "foo")
+	require.NoError(t, err)
+
+	// Keep a session open to check that it gets terminated
+	fooDB, err := sql.Open("pgx", foo.GetURI())
+	require.NoError(t, err)
+	defer fooDB.Close()
+	require.NoError(t, fooDB.PingContext(ctx))
+
+	require.NoError(t, adapter.DeleteInstance(ctx, "foo"))
+	require.ErrorIs(t, adapter.DeleteInstance(ctx, "foo"), adapterpkg.ErrInstanceNotFound)
+
+	instances, err := adapter.GetInstances(ctx)
+	require.NoError(t, err)
+	require.Empty(t, instances)
+
+	adminDB, err := sql.Open("pgx", uri)
+	require.NoError(t, err)
+	defer adminDB.Close()
+	var exists bool
+	err = adminDB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM pg_database WHERE datname = 'db_foo')`).Scan(&exists)
+	require.NoError(t, err)
+	require.False(t, exists)
+	err = adminDB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM pg_roles WHERE rolname LIKE 'user_foo_%')`).Scan(&exists)
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	// The instance can be recreated after deletion
+	_, err = adapter.GetOrCreateInstance(ctx, "foo")
+	require.NoError(t, err)
+}
+
+func TestPostgresAdapterRotateCredentials(t *testing.T) {
+	ctx := context.Background()
+	container, uri, _ := startPostgresContainer(t)
+	defer container.Terminate(ctx)
+
+	adapter, err := New(uri)
+	require.NoError(t, err)
+
+	foo, err := adapter.GetOrCreateInstance(ctx, "foo")
+	require.NoError(t, err)
+	fooDB, err := sql.Open("pgx", foo.GetURI())
+	require.NoError(t, err)
+	defer fooDB.Close()
+	_, err = fooDB.ExecContext(ctx, `CREATE TABLE test (value TEXT)`)
+	require.NoError(t, err)
+	_, err = fooDB.ExecContext(ctx, `INSERT INTO test (value) VALUES ('foo-value')`)
+	require.NoError(t, err)
+
+	rotated, err := adapter.RotateCredentials(ctx, "foo", adapterpkg.RotateOptions{})
+	require.NoError(t, err)
+	require.NotEqual(t, foo.GetURI(), rotated.GetURI())
+
+	// The stored instance matches the rotated one
+	stored, err := adapter.GetOrCreateInstance(ctx, "foo")
+	require.NoError(t, err)
+	require.Equal(t, rotated.GetURI(), stored.GetURI())
+
+	// Old password no longer works
+	oldDB, err := sql.Open("pgx", foo.GetURI())
+	require.NoError(t, err)
+	defer oldDB.Close()
+	require.Error(t, oldDB.PingContext(ctx))
+
+	// New password works and data is kept
+	newDB, err := sql.Open("pgx", rotated.GetURI())
+	require.NoError(t, err)
+	defer newDB.Close()
+	var val string
+	err = newDB.QueryRowContext(ctx, `SELECT value FROM test`).Scan(&val)
+	require.NoError(t, err)
+	require.Equal(t, "foo-value", val)
+
+	_, err = adapter.RotateCredentials(ctx, "missing", adapterpkg.RotateOptions{})
+	require.ErrorIs(t, err, adapterpkg.ErrInstanceNotFound)
+}
+
+func TestPostgresAdapterRotateCredentialsWithGracePeriod(t *testing.T) {
+	ctx := context.Background()
+	container, uri, _ := startPostgresContainer(t)
+	defer container.Terminate(ctx)
+
+	adapter, err := New(uri)
+	require.NoError(t, err)
+
+	foo, err := adapter.GetOrCreateInstance(ctx, "foo")
+	require.NoError(t, err)
+
+	rotated, err := adapter.RotateCredentials(ctx, "foo", adapterpkg.RotateOptions{GracePeriod: time.Hour})
+	require.NoError(t, err)
+	resp := rotated.GetJSON().(InstanceResponse)
+	require.NotNil(t, resp.PreviousCredential)
+	require.Equal(t, foo.GetURI(), resp.PreviousCredential.URI)
+	require.WithinDuration(t, time.Now().Add(time.Hour), resp.PreviousCredential.ExpiresAt, time.Minute)
+
+	// Both credentials work during the grace period and see the same objects
+	oldDB, err := sql.Open("pgx", foo.GetURI())
+	require.NoError(t, err)
+	defer oldDB.Close()
+	newDB, err := sql.Open("pgx", rotated.GetURI())
+	require.NoError(t, err)
+	defer newDB.Close()
+	_, err = newDB.ExecContext(ctx, `CREATE TABLE test (value TEXT)`)
+	require.NoError(t, err)
+	_, err = oldDB.ExecContext(ctx, `INSERT INTO test (value) VALUES ('foo-value')`)
+	require.NoError(t, err)
+	var val string
+	err = newDB.QueryRowContext(ctx, `SELECT value FROM test`).Scan(&val)
+	require.NoError(t, err)
+	require.Equal(t, "foo-value", val)
+
+	// Rotating again retires the expiring credential right away
+	rotatedAgain, err := adapter.RotateCredentials(ctx, "foo", adapterpkg.RotateOptions{})
+	require.NoError(t, err)
+	require.Nil(t, rotatedAgain.GetJSON().(InstanceResponse).PreviousCredential)
+	retiredDB, err := sql.Open("pgx", foo.GetURI())
+	require.NoError(t, err)
+	defer retiredDB.Close()
+	require.Error(t, retiredDB.PingContext(ctx))
+	latestDB, err := sql.Open("pgx", rotatedAgain.GetURI())
+	require.NoError(t, err)
+	defer latestDB.Close()
+	err = latestDB.QueryRowContext(ctx, `SELECT value FROM test`).Scan(&val)
+	require.NoError(t, err)
+
+	// Deletion removes every role of the instance
+	require.NoError(t, adapter.DeleteInstance(ctx, "foo"))
+	adminDB, err := sql.Open("pgx", uri)
+	require.NoError(t, err)
+	defer adminDB.Close()
+	var exists bool
+	err = adminDB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM pg_roles WHERE rolname LIKE 'user_foo_%')`).Scan(&exists)
+	require.NoError(t, err)
+	require.False(t, exists)
+}
+
+func TestPostgresAdapterCreateInstance(t *testing.T) {
+	ctx := context.Background()
+	container, uri, _ := startPostgresContainer(t)
+	defer container.Terminate(ctx)
+
+	adapter, err := New(uri)
+	require.NoError(t, err)
+
+	params := json.RawMessage(`{"encoding":"UTF8","locale":"C","connection_limit":5}`)
+	foo, err := adapter.CreateInstance(ctx, "foo", adapterpkg.CreateOptions{Params: params})
+	require.NoError(t, err)
+
+	_, err = adapter.CreateInstance(ctx, "foo", adapterpkg.CreateOptions{})
+	require.ErrorIs(t, err, adapterpkg.ErrInstanceExists)
+
+	fooDB, err := sql.Open("pgx", foo.GetURI())
+	require.NoError(t, err)
+	defer fooDB.Close()
+	var encoding, collate string
+	var connLimit int
+	err = fooDB.QueryRowContext(ctx, `SELECT pg_encoding_to_char(encoding), datcollate, datconnlimit FROM pg_database WHERE datname = current_database()`).Scan(&encoding, &collate, &connLimit)
+	require.NoError(t, err)
+	require.Equal(t, "UTF8", encoding)
+	require.Equal(t, "C", collate)
+	require.Equal(t, 5, connLimit)
+
+	// Only template databases can be copied
+	_, err = adapter.CreateInstance(ctx, "bar", adapterpkg.CreateOptions{Params: json.RawMessage(`{"template":"db_foo"}`)})
+	require.ErrorIs(t, err, adapterpkg.ErrInvalidParams)
+	_, err = adapter.CreateInstance(ctx, "bar", adapterpkg.CreateOptions{Params: json.RawMessage(`{"template":"template0"}`)})
+	require.NoError(t, err)
+
+	_, err = adapter.CreateInstance(ctx, "baz", adapterpkg.CreateOptions{Params: json.RawMessage(`{"unknown":true}`)})
+	require.ErrorIs(t, err, adapterpkg.ErrInvalidParams)
+}
+
+func TestPostgresAdapterTemplates(t *testing.T) {
+	ctx := context.Background()
+	container, uri, _ := startPostgresContainer(t)
+	defer container.Terminate(ctx)
+
+	adapter, err := New(uri)
+	require.NoError(t, err)
+	defer adapter.Close()
+	tm := adapter.(adapterpkg.TemplateManager)
+
+	adminDB, err := sql.Open("pgx", uri)
+	require.NoError(t, err)
+	defer adminDB.Close()
+	_, err = adminDB.ExecContext(ctx, `CREATE DATABASE base`)
+	require.NoError(t, err)
+	baseDB, err := sql.Open("pgx", strings.Replace(uri, "/postgres?", "/base?", 1))
+	require.NoError(t, err)
+	_, err = baseDB.ExecContext(ctx, `CREATE TABLE countries (id SERIAL, code TEXT); INSERT INTO countries (code) VALUES ('hu');
+		CREATE FUNCTION country_count() RETURNS bigint LANGUAGE sql AS 'SELECT count(*) FROM countries'`)
+	require.NoError(t, err)
+	baseDB.Close()
+
+	_, err = tm.RegisterTemplate(ctx, "reference", "missing")
+	require.ErrorIs(t, err, adapterpkg.ErrInvalidParams)
+	template, err := tm.RegisterTemplate(ctx, "reference", "base")
+	require.NoError(t, err)
+	require.Equal(t, "base", template.Database)
+	_, err = tm.RegisterTemplate(ctx, "reference", "base")
+	require.ErrorIs(t, err, adapterpkg.ErrTemplateExists)
+	templates, err := tm.GetTemplates(ctx)
+	require.NoError(t, err)
+	require.Len(t, templates, 1)
+
+	foo, err := adapter.CreateInstance(ctx, "foo", adapterpkg.CreateOptions{Params: json.RawMessage(`{"template":"reference"}`)})
+	require.NoError(t, err)
+	fooDB, err := sql.Open("pgx", foo.GetURI())
+	require.NoError(t, err)
+	defer fooDB.Close()
+	var code string
+	require.NoError(t, fooDB.QueryRowContext(ctx, `SELECT code FROM countries`).Scan(&code))
+	require.Equal(t, "hu", code)
+	// the copied objects belong to the instance
+	_, err = fooDB.ExecContext(ctx, `INSERT INTO countries (code) VALUES ('de'); ALTER TABLE countries ADD COLUMN name TEXT`)
+	require.NoError(t, err)
+	_, err = fooDB.ExecContext(ctx, `ALTER FUNCTION country_count() RENAME TO countries_count`)
+	require.NoError(t, err)
+	var owner string
+	require.NoError(t, fooDB.QueryRowContext(ctx, `SELECT tableowner FROM pg_tables WHERE tablename = 'countries'`).Scan(&owner))
+	require.Equal(t, foo.GetJSON().(InstanceResponse).Username, owner)
+
+	// Instance databases can't become templates
+	_, err = tm.RegisterTemplate(ctx, "foo", "db_foo")
+	require.ErrorIs(t, err, adapterpkg.ErrInvalidParams)
+
+	require.NoError(t, tm.UnregisterTemplate(ctx, "reference"))
+	require.ErrorIs(t, tm.UnregisterTemplate(ctx, "reference"), adapterpkg.ErrTemplateNotFound)
+	templates, err = tm.GetTemplates(ctx)
+	require.NoError(t, err)
+	require.Empty(t, templates)
+}
+
+func TestPostgresAdapterExtensions(t *testing.T) {
+	ctx := context.Background()
+	container, uri, _ := startPostgresContainer(t)
+	defer container.Terminate(ctx)
+
+	adapter, err := New(uri, WithExtensions([]string{"pgcrypto", "uuid-ossp", "pg_trgm", "missing"}))
+	require.NoError(t, err)
+	defer adapter.Close()
+	installer := adapter.(adapterpkg.ExtensionInstaller)
+
+	_, err = adapter.CreateInstance(ctx, "foo", adapterpkg.CreateOptions{Params: json.RawMessage(`{"extensions":["plpgsql","hstore"]}`)})
+	require.ErrorIs(t, err, adapterpkg.ErrInvalidParams)
+	foo, err := adapter.CreateInstance(ctx, "foo", adapterpkg.CreateOptions{Params: json.RawMessage(`{"extensions":["pgcrypto"]}`)})
+	require.NoError(t, err)
+	require.Equal(t, []string{"pgcrypto"}, foo.GetJSON().(InstanceResponse).Extensions)
+
+	fooDB, err := sql.Open("pgx", foo.GetURI())
+	require.NoError(t, err)
+	defer fooDB.Close()
+	var digest string
+	require.NoError(t, fooDB.QueryRowContext(ctx, `SELECT encode(digest('abc', 'sha1'), 'hex')`).Scan(&digest))
+	require.Equal(t, "a9993e364706816aba3e25717850c26c9cd0d89d", digest)
+
+	foo, err = installer.InstallExtensions(ctx, "foo", []string{"uuid-ossp", "pgcrypto"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"pgcrypto", "uuid-ossp"}, foo.GetJSON().(InstanceResponse).Extensions)
+	var uuid string
+	require.NoError(t, fooDB.QueryRowContext(ctx, `SELECT uuid_generate_v4()::text`).Scan(&uuid))
+	foo, err = adapter.GetInstance(ctx, "foo")
+	require.NoError(t, err)
+	require.Equal(t, []string{"pgcrypto", "uuid-ossp"}, foo.GetJSON().(InstanceResponse).Extensions)
+
+	_, err = installer.InstallExtensions(ctx, "foo", []string{"hstore"})
+	require.ErrorIs(t, err, adapterpkg.ErrInvalidParams)
+	_, err = installer.InstallExtensions(ctx, "foo", []string{"missing"})
+	require.ErrorIs(t, err, adapterpkg.ErrInvalidParams)
+	_, err = installer.InstallExtensions(ctx, "bar", []string{"pg_trgm"})
+	require.ErrorIs(t, err, adapterpkg.ErrInstanceNotFound)
+}
+
+func TestPostgresAdapterConcurrentCreate(t *testing.T) {
+	ctx := context.Background()
+	container, uri, _ := startPostgresContainer(t)
+	defer container.Terminate(ctx)
+
+	adapter, err := New(uri)
+	require.NoError(t, err)
+	defer adapter.Close()
+
+	const workers = 20
+	uris := make([]string, workers)
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	for i := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			instance, err := adapter.GetOrCreateInstance(ctx, "foo")
+			errs[i] = err
+			if err == nil {
+				uris[i] = instance.GetURI()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Every caller gets the same credential, and it's the one that works
+	for i := range workers {
+		require.NoError(t, errs[i])
+		require.Equal(t, uris[0], uris[i])
+	}
+	fooDB, err := sql.Open("pgx", uris[0])
+	require.NoError(t, err)
+	defer fooDB.Close()
+	require.NoError(t, fooDB.PingContext(ctx))
+
+	// Concurrent explicit creations have a single winner
+	created := make([]error, workers)
+	for i := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, created[i] = adapter.CreateInstance(ctx, "bar", adapterpkg.CreateOptions{})
+		}()
+	}
+	wg.Wait()
+	var succeeded int
+	for _, err := range created {
+		if err == nil {
+			succeeded++
+		} else {
+			require.ErrorIs(t, err, adapterpkg.ErrInstanceExists)
+		}
+	}
+	require.Equal(t, 1, succeeded)
+
+	adminDB, err := sql.Open("pgx", uri)
+	require.NoError(t, err)
+	defer adminDB.Close()
+	var roles int
+	err = adminDB.QueryRowContext(ctx, `SELECT COUNT(*) FROM pg_roles WHERE rolname LIKE 'user_bar_%'`).Scan(&roles)
+	require.NoError(t, err)
+	require.Equal(t, 1, roles)
+}
+
+func TestPostgresAdapterExistingDatabase(t *testing.T) {
+	ctx := context.Background()
+	container, uri, _ := startPostgresContainer(t)
+	defer container.Terminate(ctx)
+
+	adapter, err := New(uri)
+	require.NoError(t, err)
+	defer adapter.Close()
+
+	adminDB, err := sql.Open("pgx", uri)
+	require.NoError(t, err)
+	defer adminDB.Close()
+	_, err = adminDB.ExecContext(ctx, `CREATE DATABASE db_foo`)
+	require.NoError(t, err)
+
+	// A database the adapter didn't create is not handed out
+	_, err = adapter.GetOrCreateInstance(ctx, "foo")
+	require.ErrorIs(t, err, adapterpkg.ErrConflict)
+	_, err = adapter.GetInstance(ctx, "foo")
+	require.ErrorIs(t, err, adapterpkg.ErrInstanceNotFound)
+	var roles int
+	err = adminDB.QueryRowContext(ctx, `SELECT COUNT(*) FROM pg_roles WHERE rolname LIKE 'user_foo_%'`).Scan(&roles)
+	require.NoError(t, err)
+	require.Zero(t, roles)
+
+	// Unless adopting it is requested
+	foo, err := adapter.CreateInstance(ctx, "foo", adapterpkg.CreateOptions{Params: json.RawMessage(`{"adopt_existing":true}`)})
+	require.NoError(t, err)
+	fooDB, err := sql.Open("pgx", foo.GetURI())
+	require.NoError(t, err)
+	defer fooDB.Close()
+	var owner string
+	err = fooDB.QueryRowContext(ctx, `SELECT pg_get_userbyid(datdba) FROM pg_database WHERE datname = current_database()`).Scan(&owner)
+	require.NoError(t, err)
+	require.Equal(t, foo.GetJSON().(InstanceResponse).Username, owner)
+
+	// A failed creation drops the database it created
+	badAdapter, err := New(uri, WithPlans(map[string]Plan{
+		"bad": {Settings: map[string]string{"statement_timeout": "forever"}},
+	}))
+	require.NoError(t, err)
+	defer badAdapter.Close()
+	_, err = badAdapter.CreateInstance(ctx, "bar", adapterpkg.CreateOptions{Plan: "bad"})
+	require.Error(t, err)
+	var exists bool
+	err = adminDB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM pg_database WHERE datname = 'db_bar')`).Scan(&exists)
+	require.NoError(t, err)
+	require.False(t, exists)
+	_, err = adapter.CreateInstance(ctx, "bar", adapterpkg.CreateOptions{})
+	require.NoError(t, err)
+}
+
+func TestPostgresAdapterExpiry(t *testing.T) {
+	ctx := context.Background()
+	container, uri, _ := startPostgresContainer(t)
+	defer container.Terminate(ctx)
+
+	adapter, err := New(uri)
+	require.NoError(t, err)
+	defer adapter.Close()
+	expirer := adapter.(adapterpkg.Expirer)
+
+	foo, err := adapter.CreateInstance(ctx, "foo", adapterpkg.CreateOptions{TTL: time.Hour})
+	require.NoError(t, err)
+	expiresAt := foo.GetJSON().(InstanceResponse).ExpiresAt
+	require.NotNil(t, expiresAt)
+	_, err = adapter.CreateInstance(ctx, "bar", adapterpkg.CreateOptions{})
+	require.NoError(t, err)
+
+	deleted, err := expirer.DeleteExpiredInstances(ctx, time.Now())
+	require.NoError(t, err)
+	require.Empty(t, deleted)
+
+	deleted, err = expirer.DeleteExpiredInstances(ctx, expiresAt.Add(time.Second))
+	require.NoError(t, err)
+	require.Equal(t, []string{"foo"}, deleted)
+	_, err = adapter.GetInstance(ctx, "foo")
+	require.ErrorIs(t, err, adapterpkg.ErrInstanceNotFound)
+	instances, err := adapter.GetInstances(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"bar"}, instances)
+
+	// An instance that can't be deleted doesn't keep the others from expiring
+	schemaAdapter, err := New(uri, WithSchemaMode("shared"))
+	require.NoError(t, err)
+	defer schemaAdapter.Close()
+	_, err = schemaAdapter.CreateInstance(ctx, "aaa", adapterpkg.CreateOptions{TTL: time.Hour})
+	require.NoError(t, err)
+	_, err = adapter.CreateInstance(ctx, "baz", adapterpkg.CreateOptions{TTL: time.Hour})
+	require.NoError(t, err)
+	deleted, err = expirer.DeleteExpiredInstances(ctx, time.Now().Add(2*time.Hour))
+	require.ErrorContains(t, err, "aaa")
+	require.Equal(t, []string{"baz"}, deleted)
+}
+
+func TestPostgresAdapterLease(t *testing.T) {
+	ctx := context.Background()
+	container, uri, _ := startPostgresContainer(t)
+	defer container.Terminate(ctx)
+
+	adapter, err := New(uri)
+	require.NoError(t, err)
+	defer adapter.Close()
+	leaser := adapter.(adapterpkg.Leaser)
+
+	_, err = adapter.CreateInstance(ctx, "foo", adapterpkg.CreateOptions{TTL: time.Hour, Lease: time.Minute})
+	require.ErrorIs(t, err, adapterpkg.ErrInvalidParams)
+	foo, err := adapter.CreateInstance(ctx, "foo", adapterpkg.CreateOptions{Lease: time.Minute})
+	require.NoError(t, err)
+	lease := foo.GetJSON().(InstanceResponse).Lease
+	require.NotNil(t, lease)
+	require.Equal(t, "1m0s", lease.Period)
+	expiresAt := foo.GetJSON().(InstanceResponse).ExpiresAt
+
+	_, err = leaser.RenewLease(ctx, "foo", "wrong")
+	require.ErrorIs(t, err, adapterpkg.ErrInvalidLease)
+	time.Sleep(10 * time.Millisecond)
+	renewed, err := leaser.RenewLease(ctx, "foo", lease.ID)
+	require.NoError(t, err)
+	require.True(t, renewed.GetJSON().(InstanceResponse).ExpiresAt.After(*expiresAt))
+
+	// The lease survives a restart and the instance is reclaimed once it's not renewed
+	restarted, err := New(uri)
+	require.NoError(t, err)
+	defer restarted.Close()
+	leaser = restarted.(adapterpkg.Leaser)
+	deleted, err := leaser.DeleteExpiredInstances(ctx, time.Now().Add(2*time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, []string{"foo"}, deleted)
+	_, err = leaser.RenewLease(ctx, "foo", lease.ID)
+	require.ErrorIs(t, err, adapterpkg.ErrInstanceNotFound)
+}
+
+func TestPostgresAdapterFailedInstance(t *testing.T) {
+	ctx := context.Background()
+	container, uri, _ := startPostgresContainer(t)
+	defer container.Terminate(ctx)
+
+	adapter, err := New(uri, WithPlans(map[string]Plan{
+		"bad": {Settings: map[string]string{"statement_timeout": "forever"}},
+	}))
+	require.NoError(t, err)
+	defer adapter.Close()
+
+	adminDB, err := sql.Open("pgx", uri)
+	require.NoError(t, err)
+	defer adminDB.Close()
+
+	// A failed creation is cleaned up and kept as failed
+	_, err = adapter.CreateInstance(ctx, "foo", adapterpkg.CreateOptions{Plan: "bad"})
+	require.Error(t, err)
+	failed, err := adapter.GetInstance(ctx, "foo")
+	require.NoError(t, err)
+	require.Equal(t, adapterpkg.StatusFailed, failed.GetJSON().(InstanceResponse).Status)
+	var exists bool
+	err = adminDB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM pg_database WHERE datname = 'db_foo')`).Scan(&exists)
+	require.NoError(t, err)
+	require.False(t, exists)
+	err = adminDB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM pg_roles WHERE rolname LIKE 'user_foo_%')`).Scan(&exists)
+	require.NoError(t, err)
+	require.False(t, exists)
+	_, err = adapter.RotateCredentials(ctx, "foo", adapterpkg.RotateOptions{})
+	require.ErrorIs(t, err, adapterpkg.ErrInstanceNotReady)
+
+	// Creating it again resumes it
+	foo, err := adapter.GetOrCreateInstance(ctx, "foo")
+	require.NoError(t, err)
+	require.Equal(t, adapterpkg.StatusReady, foo.GetJSON().(InstanceResponse).Status)
+	fooDB, err := sql.Open("pgx", foo.GetURI())
+	require.NoError(t, err)
+	defer fooDB.Close()
+	require.NoError(t, fooDB.PingContext(ctx))
+	_, err = adapter.CreateInstance(ctx, "foo", adapterpkg.CreateOptions{})
+	require.ErrorIs(t, err, adapterpkg.ErrInstanceExists)
+
+	// Failed instances can be deleted
+	_, err = adapter.CreateInstance(ctx, "bar", adapterpkg.CreateOptions{Plan: "bad"})
+	require.Error(t, err)
+	require.NoError(t, adapter.DeleteInstance(ctx, "bar"))
+	_, err = adapter.GetInstance(ctx, "bar")
+	require.ErrorIs(t, err, adapterpkg.ErrInstanceNotFound)
+
+	// An instance left provisioning by a crashed creator is resumed from scratch
+	baz, err := adapter.GetOrCreateInstance(ctx, "baz")
+	require.NoError(t, err)
+	_, err = adminDB.ExecContext(ctx, `UPDATE instances SET status = 'provisioning' WHERE instance_name = 'baz'`)
+	require.NoError(t, err)
+	resumed, err := adapter.GetOrCreateInstance(ctx, "baz")
+	require.NoError(t, err)
+	require.Equal(t, adapterpkg.StatusReady, resumed.GetJSON().(InstanceResponse).Status)
+	require.NotEqual(t, baz.GetURI(), resumed.GetURI())
+	bazDB, err := sql.Open("pgx", resumed.GetURI())
+	require.NoError(t, err)
+	defer bazDB.Close()
+	require.NoError(t, bazDB.PingContext(ctx))
+	report, err := adapter.(adapterpkg.DriftDetector).DetectDrift(ctx, false)
+	require.NoError(t, err)
+	require.False(t, report.HasDrift())
+
+	// An interrupted adoption keeps the database
+	_, err = adminDB.ExecContext(ctx, `CREATE DATABASE db_qux`)
+	require.NoError(t, err)
+	_, err = adapter.CreateInstance(ctx, "qux", adapterpkg.CreateOptions{Params: json.RawMessage(`{"adopt_existing":true}`)})
+	require.NoError(t, err)
+	_, err = adminDB.ExecContext(ctx, `UPDATE instances SET status = 'provisioning' WHERE instance_name = 'qux'`)
+	require.NoError(t, err)
+	_, err = adapter.CreateInstance(ctx, "qux", adapterpkg.CreateOptions{Params: json.RawMessage(`{"adopt_existing":true}`)})
+	require.NoError(t, err)
+	err = adminDB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM pg_database WHERE datname = 'db_qux')`).Scan(&exists)
+	require.NoError(t, err)
+	require.True(t, exists)
+}
+
+func TestPostgresAdapterDetectDrift(t *testing.T) {
+	ctx := context.Background()
+	container, uri, _ := startPostgresContainer(t)
+	defer container.Terminate(ctx)
+
+	adapter, err := New(uri)
+	require.NoError(t, err)
+	defer adapter.Close()
+	detector := adapter.(adapterpkg.DriftDetector)
+
+	_, err = adapter.GetOrCreateInstance(ctx, "foo")
+	require.NoError(t, err)
+	_, err = adapter.GetOrCreateInstance(ctx, "bar")
+	require.NoError(t, err)
+	report, err := detector.DetectDrift(ctx, false)
+	require.NoError(t, err)
+	require.False(t, report.HasDrift())
+
+	// Leftovers without records, a dropped database and a dropped login role
+	foo, err := adapter.RotateCredentials(ctx, "foo", adapterpkg.RotateOptions{GracePeriod: time.Hour})
+	require.NoError(t, err)
+	fooUser := foo.GetJSON().(InstanceResponse).Username
+	adminDB, err := sql.Open("pgx", uri)
+	require.NoError(t, err)
+	defer adminDB.Close()
+	for _, stmt := range []string{
+		`CREATE DATABASE db_orphan`,
+		`CREATE ROLE user_orphan_abcd`,
+		`DROP DATABASE db_bar`,
+		fmt.Sprintf(`REVOKE CONNECT ON DATABASE db_foo FROM %s`, fooUser),
+		fmt.Sprintf(`DROP ROLE %s`, fooUser),
+	} {
+		_, err = adminDB.ExecContext(ctx, stmt)
+		require.NoError(t, err)
+	}
+
+	report, err = detector.DetectDrift(ctx, false)
+	require.NoError(t, err)
+	require.Equal(t, []string{"db_orphan"}, report.OrphanedDatabases)
+	require.Equal(t, []string{"user_orphan_abcd"}, report.OrphanedUsers)
+	require.Equal(t, []string{"bar"}, report.MissingDatabases)
+	require.Equal(t, []string{"foo"}, report.MissingUsers)
+
+	// Repair restores the login role with its stored password
+	report, err = detector.DetectDrift(ctx, true)
+	require.NoError(t, err)
+	require.Equal(t, []string{"foo"}, report.Repaired)
+	require.Empty(t, report.MissingUsers)
+	fooDB, err := sql.Open("pgx", foo.GetURI())
+	require.NoError(t, err)
+	defer fooDB.Close()
+	require.NoError(t, fooDB.PingContext(ctx))
+}
+
+func TestPostgresAdapterDropOrphans(t *testing.T) {
+	ctx := context.Background()
+	container, uri, _ := startPostgresContainer(t)
+	defer container.Terminate(ctx)
+
+	adapter, err := New(uri)
+	require.NoError(t, err)
+	defer adapter.Close()
+	gc := adapter.(adapterpkg.GarbageCollector)
+
+	foo, err := adapter.GetOrCreateInstance(ctx, "foo")
+	require.NoError(t, err)
+	fooUser := foo.GetJSON().(InstanceResponse).Username
+	adminDB, err := sql.Open("pgx", uri)
+	require.NoError(t, err)
+	defer adminDB.Close()
+	for _, stmt := range []string{
+		`CREATE DATABASE db_orphan`,
+		`CREATE ROLE user_orphan_abcd`,
+		`GRANT CONNECT ON DATABASE db_foo TO user_orphan_abcd`,
+	} {
+		_, err = adminDB.ExecContext(ctx, stmt)
+		require.NoError(t, err)
+	}
+
+	// The resources of instances are skipped
+	require.NoError(t, gc.DropOrphans(ctx, []string{"db_orphan", "db_foo"}, []string{"user_orphan_abcd", fooUser}))
+	report, err := gc.DetectDrift(ctx, false)
+	require.NoError(t, err)
+	require.False(t, report.HasDrift())
+	fooDB, err := sql.Open("pgx", foo.GetURI())
+	require.NoError(t, err)
+	defer fooDB.Close()
+	require.NoError(t, fooDB.PingContext(ctx))
+}
+
+func TestPostgresAdapterPlans(t *testing.T) {
+	ctx := context.Background()
+	container, uri, _ := startPostgresContainer(t)
+	defer container.Terminate(ctx)
+
+	adapter, err := New(uri, WithPlans(map[string]Plan{
+		"small": {
+			ConnectionLimit: 3,
+			Settings:        map[string]string{"statement_timeout": "30s", "work_mem": "4MB"},
+		},
+	}))
+	require.NoError(t, err)
+
+	foo, err := adapter.CreateInstance(ctx, "foo", adapterpkg.CreateOptions{Plan: "small"})
+	require.NoError(t, err)
+	require.Equal(t, "small", foo.GetJSON().(InstanceResponse).Plan)
+
+	fooDB, err := sql.Open("pgx", foo.GetURI())
+	require.NoError(t, err)
+	defer fooDB.Close()
+	var statementTimeout, workMem string
+	require.NoError(t, fooDB.QueryRowContext(ctx, `SHOW statement_timeout`).Scan(&statementTimeout))
+	require.NoError(t, fooDB.QueryRowContext(ctx, `SHOW work_mem`).Scan(&workMem))
+	require.Equal(t, "30s", statementTimeout)
+	require.Equal(t, "4MB", workMem)
+	var connLimit int
+	err = fooDB.QueryRowContext(ctx, `SELECT rolconnlimit FROM pg_roles WHERE rolname = current_user`).Scan(&connLimit)
+	require.NoError(t, err)
+	require.Equal(t, 3, connLimit)
+
+	// Roles created by rotation get the same plan
+	rotated, err := adapter.RotateCredentials(ctx, "foo", adapterpkg.RotateOptions{GracePeriod: time.Hour})
+	require.NoError(t, err)
+	rotatedDB, err := sql.Open("pgx", rotated.GetURI())
+	require.NoError(t, err)
+	defer rotatedDB.Close()
+	require.NoError(t, rotatedDB.QueryRowContext(ctx, `SHOW statement_timeout`).Scan(&statementTimeout))
+	require.Equal(t, "30s", statementTimeout)
+
+	_, err = adapter.CreateInstance(ctx, "bar", adapterpkg.CreateOptions{Plan: "huge"})
+	require.ErrorIs(t, err, adapterpkg.ErrInvalidParams)
+
+	_, err = New(uri, WithPlans(map[string]Plan{"bad": {Settings: map[string]string{"x; DROP": "1"}}}))
+	require.Error(t, err)
+}
+
+func TestPostgresAdapterClaimInstance(t *testing.T) {
+	ctx := context.Background()
+	container, uri, _ := startPostgresContainer(t)
+	defer container.Terminate(ctx)
+
+	for _, opts := range [][]Option{nil, {WithSchemaMode("shared")}} {
+		adapter, err := New(uri, opts...)
+		require.NoError(t, err)
+		defer adapter.Close()
+		claimer := adapter.(adapterpkg.Claimer)
+
+		_, err = adapter.CreateInstance(ctx, "POOL_abcd", adapterpkg.CreateOptions{})
+		require.NoError(t, err)
+		_, err = adapter.CreateInstance(ctx, "foo", adapterpkg.CreateOptions{})
+		require.NoError(t, err)
+		_, err = claimer.ClaimInstance(ctx, "POOL_abcd", "foo", adapterpkg.CreateOptions{})
+		require.ErrorIs(t, err, adapterpkg.ErrInstanceExists)
+
+		bar, err := claimer.ClaimInstance(ctx, "POOL_abcd", "bar", adapterpkg.CreateOptions{TTL: time.Hour})
+		require.NoError(t, err)
+		barResp := bar.GetJSON().(InstanceResponse)
+		require.Equal(t, "bar", barResp.Instance)
+		require.NotNil(t, barResp.ExpiresAt)
+		_, err = adapter.GetInstance(ctx, "POOL_abcd")
+		require.ErrorIs(t, err, adapterpkg.ErrInstanceNotFound)
+
+		barDB, err := sql.Open("pgx", bar.GetURI())
+		require.NoError(t, err)
+		defer barDB.Close()
+		_, err = barDB.ExecContext(ctx, `CREATE TABLE test (value TEXT)`)
+		require.NoError(t, err)
+		report, err := adapter.(adapterpkg.DriftDetector).DetectDrift(ctx, false)
+		require.NoError(t, err)
+		require.False(t, report.HasDrift())
+
+		barDB.Close()
+		require.NoError(t, adapter.DeleteInstance(ctx, "foo"))
+		require.NoError(t, adapter.DeleteInstance(ctx, "bar"))
+	}
+}
+
+func TestPostgresAdapterCloneInstance(t *testing.T) {
+	ctx := context.Background()
+	container, uri, _ := startPostgresContainer(t)
+	defer container.Terminate(ctx)
+
+	adapter, err := New(uri)
+	require.NoError(t, err)
+	defer adapter.Close()
+	cloner := adapter.(adapterpkg.Cloner)
+
+	_, err = cloner.CloneInstance(ctx, "foo", "bar")
+	require.ErrorIs(t, err, adapterpkg.ErrInstanceNotFound)
+	foo, err := adapter.GetOrCreateInstance(ctx, "foo")
+	require.NoError(t, err)
+	fooDB, err := sql.Open("pgx", foo.GetURI())
+	require.NoError(t, err)
+	defer fooDB.Close()
+	_, err = fooDB.ExecContext(ctx, `CREATE TABLE test (value TEXT); INSERT INTO test VALUES ('hello')`)
+	require.NoError(t, err)
+
+	// the open session of foo gets terminated
+	bar, err := cloner.CloneInstance(ctx, "foo", "bar")
+	require.NoError(t, err)
+	barResp := bar.GetJSON().(InstanceResponse)
+	require.Equal(t, "db_bar", barResp.Database)
+	require.NotEqual(t, foo.GetJSON().(InstanceResponse).Username, barResp.Username)
+	_, err = cloner.CloneInstance(ctx, "foo", "bar")
+	require.ErrorIs(t, err, adapterpkg.ErrInstanceExists)
+
+	barDB, err := sql.Open("pgx", bar.GetURI())
+	require.NoError(t, err)
+	defer barDB.Close()
+	var value string
+	require.NoError(t, barDB.QueryRowContext(ctx, `SELECT value FROM test`).Scan(&value))
+	require.Equal(t, "hello", value)
+	_, err = barDB.ExecContext(ctx, `INSERT INTO test VALUES ('world')`)
+	require.NoError(t, err)
+
+	// the source keeps its data and ownership, it needs a new session after the termination
+	fooDB.Close()
+	fooDB, err = sql.Open("pgx", foo.GetURI())
+	require.NoError(t, err)
+	defer fooDB.Close()
+	var count int
+	require.NoError(t, fooDB.QueryRowContext(ctx, `SELECT COUNT(*) FROM test`).Scan(&count))
+	require.Equal(t, 1, count)
+	_, err = fooDB.ExecContext(ctx, `DROP TABLE test`)
+	require.NoError(t, err)
+	report, err := adapter.(adapterpkg.DriftDetector).DetectDrift(ctx, false)
+	require.NoError(t, err)
+	require.False(t, report.HasDrift())
+
+	fooDB.Close()
+	barDB.Close()
+	require.NoError(t, adapter.DeleteInstance(ctx, "foo"))
+	require.NoError(t, adapter.DeleteInstance(ctx, "bar"))
+
+	schemaAdapter, err := New(uri, WithSchemaMode("shared"))
+	require.NoError(t, err)
+	defer schemaAdapter.Close()
+	_, err = schemaAdapter.(adapterpkg.Cloner).CloneInstance(ctx, "foo", "bar")
+	require.ErrorIs(t, err, adapterpkg.ErrInvalidParams)
+}
+
+func TestPostgresAdapterSeeds(t *testing.T) {
+	ctx := context.Background()
+	container, uri, _ := startPostgresContainer(t)
+	defer container.Terminate(ctx)
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "01_schema.sql"), []byte(`CREATE TABLE fixtures (name TEXT);`), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "02_data.sql"), []byte(`INSERT INTO fixtures VALUES ('a'); INSERT INTO fixtures VALUES ('b');`), 0o644))
+
+	for _, opts := range [][]Option{nil, {WithSchemaMode("shared")}} {
+		adapter, err := New(uri, append(opts, WithSeedDir(dir))...)
+		require.NoError(t, err)
+		defer adapter.Close()
+
+		foo, err := adapter.GetOrCreateInstance(ctx, "foo")
+		require.NoError(t, err)
+		require.Equal(t, []string{"01_schema.sql", "02_data.sql"}, foo.GetJSON().(InstanceResponse).Seeds)
+		foo, err = adapter.GetInstance(ctx, "foo")
+		require.NoError(t, err)
+		require.Equal(t, []string{"01_schema.sql", "02_data.sql"}, foo.GetJSON().(InstanceResponse).Seeds)
+
+		// the seeded objects belong to the instance
+		fooDB, err := sql.Open("pgx", foo.GetURI())
+		require.NoError(t, err)
+		defer fooDB.Close()
+		var count int
+		require.NoError(t, fooDB.QueryRowContext(ctx, `SELECT COUNT(*) FROM fixtures`).Scan(&count))
+		require.Equal(t, 2, count)
+		_, err = fooDB.ExecContext(ctx, `DROP TABLE fixtures`)
+		require.NoError(t, err)
+
+		fooDB.Close()
+		require.NoError(t, adapter.DeleteInstance(ctx, "foo"))
+	}
+
+	// a failing seed fails the instance and drops its resources
+	badDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(badDir, "01_bad.sql"), []byte(`INSERT INTO missing VALUES (1);`), 0o644))
+	adapter, err := New(uri, WithSeedDir(badDir))
+	require.NoError(t, err)
+	defer adapter.Close()
+	_, err = adapter.CreateInstance(ctx, "bar", adapterpkg.CreateOptions{})
+	require.ErrorContains(t, err, "01_bad.sql")
+	bar, err := adapter.GetInstance(ctx, "bar")
+	require.NoError(t, err)
+	require.Equal(t, adapterpkg.StatusFailed, bar.GetJSON().(InstanceResponse).Status)
+	report, err := adapter.(adapterpkg.DriftDetector).DetectDrift(ctx, false)
+	require.NoError(t, err)
+	require.False(t, report.HasDrift())
+
+	// the instance is only ready once it's seeded
+	slowDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(slowDir, "01_slow.sql"), []byte(`SELECT pg_sleep(2);`), 0o644))
+	slowAdapter, err := New(uri, WithSeedDir(slowDir))
+	require.NoError(t, err)
+	defer slowAdapter.Close()
+	adminDB, err := sql.Open("pgx", uri)
+	require.NoError(t, err)
+	defer adminDB.Close()
+	created := make(chan error, 1)
+	go func() {
+		_, err := slowAdapter.CreateInstance(ctx, "baz", adapterpkg.CreateOptions{})
+		created <- err
+	}()
+	require.Eventually(t, func() bool {
+		var count int
+		err := adminDB.QueryRowContext(ctx, `SELECT COUNT(*) FROM pg_stat_activity WHERE query = 'SELECT pg_sleep(2);'`).Scan(&count)
+		return err == nil && count > 0
+	}, 10*time.Second, 50*time.Millisecond)
+	baz, err := adapter.GetInstance(ctx, "baz")
+	require.NoError(t, err)
+	require.Equal(t, adapterpkg.StatusProvisioning, baz.GetJSON().(InstanceResponse).Status)
+	require.NoError(t, <-created)
+	baz, err = adapter.GetInstance(ctx, "baz")
+	require.NoError(t, err)
+	require.Equal(t, adapterpkg.StatusReady, baz.GetJSON().(InstanceResponse).Status)
+
+	_, err = New(uri, WithSeedDir(filepath.Join(dir, "missing")))
+	require.Error(t, err)
+}
+
+func TestPostgresAdapterResetInstance(t *testing.T) {
+	ctx := context.Background()
+	container, uri, _ := startPostgresContainer(t)
+	defer container.Terminate(ctx)
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "01_schema.sql"), []byte(`CREATE TABLE fixtures (name TEXT);`), 0o644))
+
+	for _, opts := range [][]Option{nil, {WithSchemaMode("shared")}} {
+		adapter, err := New(uri, append(opts, WithSeedDir(dir))...)
+		require.NoError(t, err)
+		defer adapter.Close()
+		resetter := adapter.(adapterpkg.Resetter)
+
+		_, err = resetter.ResetInstance(ctx, "foo")
+		require.ErrorIs(t, err, adapterpkg.ErrInstanceNotFound)
+		foo, err := adapter.GetOrCreateInstance(ctx, "foo")
+		require.NoError(t, err)
+		fooDB, err := sql.Open("pgx", foo.GetURI())
+		require.NoError(t, err)
+		_, err = fooDB.ExecContext(ctx, `CREATE TABLE test (value TEXT)`)
+		require.NoError(t, err)
+		_, err = fooDB.ExecContext(ctx, `INSERT INTO fixtures VALUES ('a')`)
+		require.NoError(t, err)
+		fooDB.Close()
+
+		// the credentials are kept, the owned objects are gone and the seeds are applied again
+		reset, err := resetter.ResetInstance(ctx, "foo")
+		require.NoError(t, err)
+		require.Equal(t, foo.GetURI(), reset.GetURI())
+		fooDB, err = sql.Open("pgx", reset.GetURI())
+		require.NoError(t, err)
+		defer fooDB.Close()
+		var exists bool
+		require.NoError(t, fooDB.QueryRowContext(ctx, `SELECT to_regclass('test') IS NOT NULL`).Scan(&exists))
+		require.False(t, exists)
+		var count int
+		require.NoError(t, fooDB.QueryRowContext(ctx, `SELECT COUNT(*) FROM fixtures`).Scan(&count))
+		require.Equal(t, 0, count)
+		_, err = fooDB.ExecContext(ctx, `CREATE TABLE test (value TEXT)`)
+		require.NoError(t, err)
+
+		report, err := adapter.(adapterpkg.DriftDetector).DetectDrift(ctx, false)
+		require.NoError(t, err)
+		require.False(t, report.HasDrift())
+
+		fooDB.Close()
+		require.NoError(t, adapter.DeleteInstance(ctx, "foo"))
+	}
+}
+
+func TestPostgresAdapterSchemaMode(t *testing.T) {
+	ctx := context.Background()
+	container, uri, _ := startPostgresContainer(t)
+	defer container.Terminate(ctx)
+
+	adapter, err := New(uri, WithSchemaMode("shared"))
+	require.NoError(t, err)
+	defer adapter.Close()
+
+	foo, err := adapter.GetOrCreateInstance(ctx, "foo")
+	require.NoError(t, err)
+	bar, err := adapter.GetOrCreateInstance(ctx, "bar")
+	require.NoError(t, err)
+	fooResp := foo.GetJSON().(InstanceResponse)
+	require.Equal(t, "shared", fooResp.Database)
+	require.Equal(t, "schema_foo", fooResp.Schema)
+
+	fooDB, err := sql.Open("pgx", foo.GetURI())
+	require.NoError(t, err)
+	defer fooDB.Close()
+	barDB, err := sql.Open("pgx", bar.GetURI())
+	require.NoError(t, err)
+	defer barDB.Close()
+
+	// Unqualified tables go to the instance's own schema
+	_, err = fooDB.ExecContext(ctx, `CREATE TABLE test (value TEXT)`)
+	require.NoError(t, err)
+	_, err = fooDB.ExecContext(ctx, `INSERT INTO test (value) VALUES ('foo-value')`)
+	require.NoError(t, err)
+	var schema string
+	err = fooDB.QueryRowContext(ctx, `SELECT table_schema FROM information_schema.tables WHERE table_name = 'test'`).Scan(&schema)
+	require.NoError(t, err)
+	require.Equal(t, "schema_foo", schema)
+
+	// Other schemas are not accessible
+	_, err = barDB.ExecContext(ctx, `SELECT value FROM schema_foo.test`)
+	require.Error(t, err)
+	_, err = barDB.ExecContext(ctx, `CREATE TABLE public.test (value TEXT)`)
+	require.Error(t, err)
+
+	// Database params need a database per instance
+	_, err = adapter.CreateInstance(ctx, "baz", adapterpkg.CreateOptions{Params: json.RawMessage(`{"encoding":"UTF8"}`)})
+	require.ErrorIs(t, err, adapterpkg.ErrInvalidParams)
+
+	// Deletion drops the schema and the role
+	require.NoError(t, adapter.DeleteInstance(ctx, "foo"))
+	_, err = barDB.ExecContext(ctx, `SELECT 1`)
+	require.NoError(t, err)
+	var exists bool
+	err = barDB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM pg_namespace WHERE nspname = 'schema_foo')`).Scan(&exists)
+	require.NoError(t, err)
+	require.False(t, exists)
+	err = barDB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM pg_roles WHERE rolname LIKE 'user_foo_%')`).Scan(&exists)
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	// A database per instance adapter of the same server only serves its own instances,
+	// but its background jobs cover the schema instances too
+	dbAdapter, err := New(uri, WithSharedDatabase("shared"))
+	require.NoError(t, err)
+	defer dbAdapter.Close()
+	qux, err := dbAdapter.GetOrCreateInstance(ctx, "qux")
+	require.NoError(t, err)
+	require.Equal(t, "db_qux", qux.GetJSON().(InstanceResponse).Database)
+	instances, err := dbAdapter.GetInstances(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"qux"}, instances)
+	instances, err = adapter.GetInstances(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"bar"}, instances)
+	_, err = dbAdapter.GetOrCreateInstance(ctx, "bar")
+	require.ErrorIs(t, err, adapterpkg.ErrInstanceExists)
+	require.ErrorIs(t, dbAdapter.DeleteInstance(ctx, "bar"), adapterpkg.ErrInstanceNotFound)
+	report, err := dbAdapter.(adapterpkg.DriftDetector).DetectDrift(ctx, false)
+	require.NoError(t, err)
+	require.False(t, report.HasDrift())
+	barDB.Close()
+	require.NoError(t, adapter.DeleteInstance(ctx, "bar"))
+	report, err = dbAdapter.(adapterpkg.DriftDetector).DetectDrift(ctx, false)
+	require.NoError(t, err)
+	require.False(t, report.HasDrift())
+}
@@ -0,0 +1,92 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/razzie-cloud/database-broker/internal/adapter"
+
+	"github.com/go-rel/rel"
+)
+
+var _ adapter.Resetter = (*postgresAdapter)(nil)
+
+// ResetInstance drops the objects owned by the roles of the instance and gives them their database access back,
+// which DROP OWNED revokes too. The seeds get applied again.
+// Objects the instance doesn't own, like extensions, are kept.
+func (pg *postgresAdapter) ResetInstance(ctx context.Context, instanceName string) (adapter.Instance, error) {
+	var instance *Instance
+	err := pg.withInstanceLock(ctx, instanceName, func() error {
+		var err error
+		instance, err = pg.getInstance(ctx, instanceName)
+		if err != nil {
+			return err
+		}
+		if instance.Status != adapter.StatusReady {
+			return fmt.Errorf("%w: %s is %s", adapter.ErrInstanceNotReady, instanceName, instance.Status)
+		}
+		return pg.resetInstance(ctx, instance)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return instance, nil
+}
+
+func (pg *postgresAdapter) resetInstance(ctx context.Context, instance *Instance) error {
+	if instance.Schema != "" && pg.sharedRepo == nil {
+		return fmt.Errorf("instance %s needs schema mode", instance.InstanceName)
+	}
+	roles := instance.roles()
+	for _, role := range roles {
+		if err := terminateUserSessions(ctx, pg.repo, role); err != nil {
+			return fmt.Errorf("terminate role sessions: %w", err)
+		}
+	}
+	// DROP OWNED only affects the current database, so it has to run inside the instance's one
+	repo := pg.sharedRepo
+	if instance.Schema == "" {
+		dbAdapter, dbRepo, err := pg.openDatabase(instance.Database)
+		if err != nil {
+			return err
+		}
+		defer dbAdapter.Close()
+		repo = dbRepo
+	}
+	err := repo.Transaction(ctx, func(txCtx context.Context) error {
+		return wipeInstance(txCtx, repo, instance)
+	})
+	if err != nil {
+		return err
+	}
+	if len(pg.seeds) > 0 {
+		if err := pg.applySeeds(ctx, instance); err != nil {
+			return err
+		}
+		instance.Seeds = strings.Join(adapter.SeedNames(pg.seeds), ",")
+		if err := pg.repo.Update(ctx, instance); err != nil {
+			return fmt.Errorf("update instance: %w", err)
+		}
+	}
+	return nil
+}
+
+func wipeInstance(ctx context.Context, repo rel.Repository, instance *Instance) error {
+	roles := instance.roles()
+	if err := dropOwned(ctx, repo, roles); err != nil {
+		return fmt.Errorf("drop owned objects: %w", err)
+	}
+	// the schema belongs to the owner role, so it got dropped too
+	if instance.Schema != "" {
+		if err := createSchema(ctx, repo, instance.Schema, instance.Owner); err != nil {
+			return fmt.Errorf("create schema: %w", err)
+		}
+	}
+	for _, role := range roles {
+		if err := grantDatabaseAccess(ctx, repo, instance.Database, role); err != nil {
+			return fmt.Errorf("grant db connect access: %w", err)
+		}
+	}
+	return nil
+}
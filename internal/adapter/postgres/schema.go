@@ -0,0 +1,145 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/razzie-cloud/database-broker/internal/adapter"
+
+	"github.com/go-rel/postgres"
+	"github.com/go-rel/rel"
+	"github.com/jackc/pgerrcode"
+)
+
+// setupSharedDatabase creates the shared database of schema mode and locks it down,
+// so instance roles only get access to their own schema
+func (pg *postgresAdapter) setupSharedDatabase(ctx context.Context) error {
+	if _, err := createDatabase(ctx, pg.repo, pg.sharedDatabase, CreateParams{AdoptExisting: true}); err != nil {
+		return err
+	}
+	if err := revokePublicDatabaseAccess(ctx, pg.repo, pg.sharedDatabase); err != nil {
+		return fmt.Errorf("revoke db public access: %w", err)
+	}
+	var err error
+	pg.sharedAdapter, pg.sharedRepo, err = pg.openDatabase(pg.sharedDatabase)
+	if err != nil {
+		return err
+	}
+	if err := revokePublicSchemaAccess(ctx, pg.sharedRepo); err != nil {
+		return fmt.Errorf("revoke public schema access: %w", err)
+	}
+	return nil
+}
+
+func (pg *postgresAdapter) createSchemaInstance(ctx context.Context, instance *Instance, plan Plan) error {
+	err := pg.repo.Transaction(ctx, func(txCtx context.Context) error {
+		if err := createUser(txCtx, pg.repo, instance.Owner, instance.Password); err != nil {
+			return fmt.Errorf("create role: %w", err)
+		}
+		if err := applyPlan(txCtx, pg.repo, instance.Owner, plan); err != nil {
+			return fmt.Errorf("apply plan: %w", err)
+		}
+		if err := setUserSearchPath(txCtx, pg.repo, instance.Owner, instance.Schema); err != nil {
+			return fmt.Errorf("set role search path: %w", err)
+		}
+		if err := grantDatabaseAccess(txCtx, pg.repo, instance.Database, instance.Owner); err != nil {
+			return fmt.Errorf("grant db connect access: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// the shared database connection only sees the role once it's committed,
+	// so the schema can't be part of the transaction and gets undone by hand
+	if err := createSchema(ctx, pg.sharedRepo, instance.Schema, instance.Owner); err != nil {
+		pg.cleanupSchemaInstance(ctx, instance)
+		if isPgError(err, pgerrcode.DuplicateSchema) {
+			return fmt.Errorf("%w: schema %s already exists", adapter.ErrConflict, instance.Schema)
+		}
+		return fmt.Errorf("create schema: %w", err)
+	}
+	if len(pg.seeds) > 0 {
+		if err := pg.seedInstance(ctx, instance); err != nil {
+			pg.cleanupSchemaInstance(ctx, instance)
+			return err
+		}
+	}
+	instance.Status = adapter.StatusReady
+	if err := pg.repo.Update(ctx, instance); err != nil {
+		pg.cleanupSchemaInstance(ctx, instance)
+		return fmt.Errorf("update instance: %w", err)
+	}
+	return nil
+}
+
+func (pg *postgresAdapter) cleanupSchemaInstance(ctx context.Context, instance *Instance) {
+	if err := pg.dropSchemaInstance(context.WithoutCancel(ctx), instance); err != nil {
+		log.Printf("cleanup instance %s: %v", instance.InstanceName, err)
+	}
+}
+
+func (pg *postgresAdapter) dropSchemaInstance(ctx context.Context, instance *Instance) error {
+	if pg.sharedRepo == nil {
+		return fmt.Errorf("instance %s needs schema mode", instance.InstanceName)
+	}
+	roles := instance.roles()
+	for _, role := range roles {
+		if err := terminateUserSessions(ctx, pg.repo, role); err != nil {
+			return fmt.Errorf("terminate role sessions: %w", err)
+		}
+	}
+	// drops the schema and revokes every privilege of the roles, including CONNECT on the shared database
+	if err := dropOwned(ctx, pg.sharedRepo, roles); err != nil {
+		return fmt.Errorf("drop owned objects: %w", err)
+	}
+	for _, role := range roles {
+		if err := dropUser(ctx, pg.repo, role); err != nil {
+			return fmt.Errorf("drop role: %w", err)
+		}
+	}
+	return nil
+}
+
+func revokePublicSchemaAccess(ctx context.Context, repo rel.Repository) error {
+	_, _, err := repo.Exec(ctx, "REVOKE ALL ON SCHEMA public FROM PUBLIC;")
+	return err
+}
+
+func createSchema(ctx context.Context, repo rel.Repository, name, owner string) error {
+	sql := fmt.Sprintf("CREATE SCHEMA %s AUTHORIZATION %s;",
+		postgres.Quote{}.ID(name), postgres.Quote{}.ID(owner))
+	_, _, err := repo.Exec(ctx, sql)
+	return err
+}
+
+func setUserSearchPath(ctx context.Context, repo rel.Repository, dbUser, schema string) error {
+	sql := fmt.Sprintf("ALTER ROLE %s SET search_path = %s;",
+		postgres.Quote{}.ID(dbUser), postgres.Quote{}.ID(schema))
+	_, _, err := repo.Exec(ctx, sql)
+	return err
+}
+
+func terminateUserSessions(ctx context.Context, repo rel.Repository, dbUser string) error {
+	sql := "SELECT pg_terminate_backend(pid) FROM pg_stat_activity WHERE usename = $1 AND pid <> pg_backend_pid();"
+	_, _, err := repo.Exec(ctx, sql, dbUser)
+	return err
+}
+
+func dropOwned(ctx context.Context, repo rel.Repository, roles []string) error {
+	quoted := make([]string, len(roles))
+	for i, role := range roles {
+		quoted[i] = postgres.Quote{}.ID(role)
+	}
+	sql := fmt.Sprintf("DROP OWNED BY %s CASCADE;", strings.Join(quoted, ", "))
+	_, _, err := repo.Exec(ctx, sql)
+	return err
+}
+
+func dropSchema(ctx context.Context, repo rel.Repository, name string) error {
+	sql := fmt.Sprintf("DROP SCHEMA IF EXISTS %s CASCADE;", postgres.Quote{}.ID(name))
+	_, _, err := repo.Exec(ctx, sql)
+	return err
+}
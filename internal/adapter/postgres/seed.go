@@ -0,0 +1,35 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/razzie-cloud/database-broker/internal/adapter"
+)
+
+// seedInstance runs the seed files as the instance user, so the objects they create belong to the instance.
+// Each file runs as a single implicit transaction. The seeds are applied before the instance is marked as ready,
+// which saves the names of the seeds too, so a failed seed leaves no ready instance behind.
+func (pg *postgresAdapter) seedInstance(ctx context.Context, instance *Instance) error {
+	if err := pg.applySeeds(ctx, instance); err != nil {
+		return err
+	}
+	instance.Seeds = strings.Join(adapter.SeedNames(pg.seeds), ",")
+	return nil
+}
+
+func (pg *postgresAdapter) applySeeds(ctx context.Context, instance *Instance) error {
+	db, err := sql.Open("pgx", instance.GetURI())
+	if err != nil {
+		return fmt.Errorf("open instance db: %w", err)
+	}
+	defer db.Close()
+	for _, seed := range pg.seeds {
+		if _, err := db.ExecContext(ctx, seed.Data); err != nil {
+			return fmt.Errorf("apply seed %s: %w", seed.Name, err)
+		}
+	}
+	return nil
+}
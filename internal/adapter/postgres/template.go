@@ -0,0 +1,183 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/razzie-cloud/database-broker/internal/adapter"
+
+	"github.com/go-rel/postgres"
+	"github.com/go-rel/rel"
+)
+
+type Template struct {
+	TemplateName string    `db:"template_name,primary"`
+	Database     string    `db:"db_name"`
+	CreatedAt    time.Time `db:"created_at"`
+}
+
+func (Template) Table() string { return "templates" }
+
+func (t Template) toAdapter() adapter.Template {
+	return adapter.Template{
+		Name:      t.TemplateName,
+		Database:  t.Database,
+		CreatedAt: t.CreatedAt,
+	}
+}
+
+var _ adapter.TemplateManager = (*postgresAdapter)(nil)
+
+func (pg *postgresAdapter) GetTemplates(ctx context.Context) ([]adapter.Template, error) {
+	var records []Template
+	if err := pg.repo.FindAll(ctx, &records, rel.SortAsc("template_name")); err != nil {
+		return nil, fmt.Errorf("find templates: %w", err)
+	}
+	templates := make([]adapter.Template, 0, len(records))
+	for _, record := range records {
+		templates = append(templates, record.toAdapter())
+	}
+	return templates, nil
+}
+
+// RegisterTemplate marks the database as a template, so it can be copied without being a superuser's database.
+// Databases named like the ones of instances are rejected, as garbage collection would drop them.
+func (pg *postgresAdapter) RegisterTemplate(ctx context.Context, templateName, database string) (adapter.Template, error) {
+	if pg.schemaMode {
+		return adapter.Template{}, fmt.Errorf("%w: templates are not supported in schema mode", adapter.ErrInvalidParams)
+	}
+	if !validIdentifier.MatchString(database) || strings.HasPrefix(database, "db_") {
+		return adapter.Template{}, fmt.Errorf("%w: invalid template database: %s", adapter.ErrInvalidParams, database)
+	}
+	var db pgDatabase
+	err := pg.repo.Find(ctx, &db, rel.Eq("datname", database))
+	if err == rel.ErrNotFound {
+		return adapter.Template{}, fmt.Errorf("%w: database not found: %s", adapter.ErrInvalidParams, database)
+	}
+	if err != nil {
+		return adapter.Template{}, fmt.Errorf("find database: %w", err)
+	}
+	record := &Template{
+		TemplateName: templateName,
+		Database:     database,
+		CreatedAt:    time.Now().UTC(),
+	}
+	err = pg.repo.Transaction(ctx, func(txCtx context.Context) error {
+		err := pg.repo.Insert(txCtx, record)
+		if errors.Is(err, rel.ErrUniqueConstraint) {
+			return fmt.Errorf("%w: %s", adapter.ErrTemplateExists, templateName)
+		}
+		if err != nil {
+			return fmt.Errorf("save template: %w", err)
+		}
+		if !db.IsTemplate {
+			if err := markTemplateDatabase(txCtx, pg.repo, database); err != nil {
+				return fmt.Errorf("mark template database: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return adapter.Template{}, err
+	}
+	return record.toAdapter(), nil
+}
+
+// UnregisterTemplate only deletes the template record, the database stays marked as a template
+func (pg *postgresAdapter) UnregisterTemplate(ctx context.Context, templateName string) error {
+	deleted, err := pg.repo.DeleteAny(ctx, rel.From("templates").Where(rel.Eq("template_name", templateName)))
+	if err != nil {
+		return fmt.Errorf("delete template: %w", err)
+	}
+	if deleted == 0 {
+		return fmt.Errorf("%w: %s", adapter.ErrTemplateNotFound, templateName)
+	}
+	return nil
+}
+
+// resolveTemplate returns the database of the registered template with the given name.
+// Other names are taken as database names, which have to be marked as templates.
+func (pg *postgresAdapter) resolveTemplate(ctx context.Context, name string) (string, error) {
+	var record Template
+	err := pg.repo.Find(ctx, &record, rel.Eq("template_name", name))
+	switch {
+	case err == nil:
+		name = record.Database
+	case err != rel.ErrNotFound:
+		return "", fmt.Errorf("find template: %w", err)
+	}
+	if err := checkTemplateDatabase(ctx, pg.repo, name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+func markTemplateDatabase(ctx context.Context, repo rel.Repository, name string) error {
+	sql := fmt.Sprintf("ALTER DATABASE %s IS_TEMPLATE true;", postgres.Quote{}.ID(name))
+	_, _, err := repo.Exec(ctx, sql)
+	return err
+}
+
+// reassignTemplateObjects hands the objects the template owner had in the template over to the owner role of the instance.
+// REASSIGN OWNED can't be used like for clones, as the template owner is usually the bootstrap superuser,
+// who also owns the system catalogs, so the objects get altered one by one instead.
+func (pg *postgresAdapter) reassignTemplateObjects(ctx context.Context, template string, instance *Instance) error {
+	dbAdapter, dbRepo, err := pg.openDatabase(instance.Database)
+	if err != nil {
+		return err
+	}
+	defer dbAdapter.Close()
+	sql := fmt.Sprintf(reassignTemplateObjectsSQL, postgres.Quote{}.Value(template), postgres.Quote{}.Value(instance.Owner))
+	if _, _, err := dbRepo.Exec(ctx, sql); err != nil {
+		return fmt.Errorf("reassign template objects: %w", err)
+	}
+	return nil
+}
+
+// reassignTemplateObjectsSQL alters the owner of the schemas, relations, routines and types outside the system schemas.
+// The public schema, the members of extensions and the sequences of serial and identity columns, which follow
+// their tables, are skipped.
+const reassignTemplateObjectsSQL = `DO $$
+DECLARE
+	old_owner oid := (SELECT datdba FROM pg_database WHERE datname = %s);
+	new_owner name := %s;
+	stmt text;
+BEGIN
+	FOR stmt IN
+		WITH namespaces AS (
+			SELECT oid, nspname, nspowner FROM pg_namespace
+			WHERE nspname NOT LIKE 'pg\_%%' AND nspname <> 'information_schema'
+		), members AS (
+			SELECT classid, objid FROM pg_depend WHERE deptype = 'e'
+		)
+		SELECT format('ALTER SCHEMA %%I OWNER TO %%I', n.nspname, new_owner)
+		FROM namespaces n
+		WHERE n.nspowner = old_owner AND n.nspname <> 'public'
+			AND n.oid NOT IN (SELECT objid FROM members WHERE classid = 'pg_namespace'::regclass)
+		UNION ALL
+		SELECT format('ALTER TABLE %%I.%%I OWNER TO %%I', n.nspname, c.relname, new_owner)
+		FROM pg_class c JOIN namespaces n ON n.oid = c.relnamespace
+		WHERE c.relowner = old_owner AND c.relkind IN ('r', 'p', 'v', 'm', 'f', 'S')
+			AND c.oid NOT IN (SELECT objid FROM members WHERE classid = 'pg_class'::regclass)
+			AND NOT (c.relkind = 'S' AND EXISTS (
+				SELECT FROM pg_depend d
+				WHERE d.classid = 'pg_class'::regclass AND d.objid = c.oid
+					AND d.refclassid = 'pg_class'::regclass AND d.deptype IN ('a', 'i')))
+		UNION ALL
+		SELECT format('ALTER ROUTINE %%I.%%I(%%s) OWNER TO %%I', n.nspname, p.proname, pg_get_function_identity_arguments(p.oid), new_owner)
+		FROM pg_proc p JOIN namespaces n ON n.oid = p.pronamespace
+		WHERE p.proowner = old_owner
+			AND p.oid NOT IN (SELECT objid FROM members WHERE classid = 'pg_proc'::regclass)
+		UNION ALL
+		SELECT format('ALTER %%s %%I.%%I OWNER TO %%I', CASE t.typtype WHEN 'd' THEN 'DOMAIN' ELSE 'TYPE' END, n.nspname, t.typname, new_owner)
+		FROM pg_type t JOIN namespaces n ON n.oid = t.typnamespace LEFT JOIN pg_class c ON c.oid = t.typrelid
+		WHERE t.typowner = old_owner AND (t.typtype IN ('d', 'e', 'r') OR c.relkind = 'c')
+			AND t.oid NOT IN (SELECT objid FROM members WHERE classid = 'pg_type'::regclass)
+	LOOP
+		EXECUTE stmt;
+	END LOOP;
+END
+$$;`
@@ -0,0 +1,8 @@
+package adapter
+
+// SharedRecords is implemented by the adapters whose instance records can be shared with other adapters,
+// like the database and schema modes of a PostgreSQL server. The broker runs expiry, garbage collection and
+// drift detection only once per records ID, so these have to cover the instances of every adapter sharing the records.
+type SharedRecords interface {
+	RecordsID() string
+}
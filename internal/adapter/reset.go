@@ -0,0 +1,9 @@
+package adapter
+
+import "context"
+
+// Resetter is implemented by the adapters that can wipe the data of an instance while keeping its credentials,
+// so its connection URI stays the same
+type Resetter interface {
+	ResetInstance(ctx context.Context, instanceName string) (Instance, error)
+}
@@ -0,0 +1,44 @@
+package adapter
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// Seed is a file of statements or commands that fresh instances get populated with
+type Seed struct {
+	Name string
+	Data string
+}
+
+// LoadSeeds reads the files of dir matching the pattern (e.g. *.sql) in the order of their names
+func LoadSeeds(dir, pattern string) ([]Seed, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, pattern))
+	if err != nil {
+		return nil, fmt.Errorf("invalid seed pattern: %w", err)
+	}
+	if _, err := os.Stat(dir); err != nil {
+		return nil, fmt.Errorf("read seed directory: %w", err)
+	}
+	slices.Sort(paths)
+	seeds := make([]Seed, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read seed: %w", err)
+		}
+		seeds = append(seeds, Seed{Name: filepath.Base(path), Data: string(data)})
+	}
+	return seeds, nil
+}
+
+// SeedNames returns the names of the seeds in order
+func SeedNames(seeds []Seed) []string {
+	names := make([]string, len(seeds))
+	for i, seed := range seeds {
+		names[i] = seed.Name
+	}
+	return names
+}
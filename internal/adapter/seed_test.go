@@ -0,0 +1,24 @@
+package adapter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadSeeds(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "02_data.sql"), []byte("INSERT INTO t VALUES (1);"), 0o644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "01_schema.sql"), []byte("CREATE TABLE t (id INT);"), 0o644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "README.md"), []byte("not a seed"), 0o644))
+
+	seeds, err := LoadSeeds(dir, "*.sql")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"01_schema.sql", "02_data.sql"}, SeedNames(seeds))
+	assert.Equal(t, "CREATE TABLE t (id INT);", seeds[0].Data)
+
+	_, err = LoadSeeds(filepath.Join(dir, "missing"), "*.sql")
+	assert.Error(t, err)
+}
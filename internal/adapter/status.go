@@ -0,0 +1,13 @@
+package adapter
+
+// Status is the provisioning state of an instance
+type Status string
+
+const (
+	// StatusProvisioning means the instance is reserved, but its resources are still being created
+	StatusProvisioning Status = "provisioning"
+	// StatusReady means the instance can be used
+	StatusReady Status = "ready"
+	// StatusFailed means provisioning failed and got cleaned up. Creating the instance again resumes it.
+	StatusFailed Status = "failed"
+)
@@ -0,0 +1,21 @@
+package adapter
+
+import (
+	"context"
+	"time"
+)
+
+// Template is a database registered under a name, which new instances can be created as a copy of
+type Template struct {
+	Name      string    `json:"name"`
+	Database  string    `json:"database"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TemplateManager is implemented by the adapters that can create instances from registered template databases.
+// An instance is created from a template by passing the template's name in the adapter's template param.
+type TemplateManager interface {
+	GetTemplates(ctx context.Context) ([]Template, error)
+	RegisterTemplate(ctx context.Context, templateName, database string) (Template, error)
+	UnregisterTemplate(ctx context.Context, templateName string) error
+}
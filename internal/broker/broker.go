@@ -2,10 +2,15 @@ package broker
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"maps"
 	"net/http"
 	"regexp"
+	"slices"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/razzie-cloud/database-broker/internal/adapter"
 )
@@ -16,24 +21,108 @@ type Interface interface {
 	RegisterAdapter(name string, adapter adapter.Interface)
 	UnregisterAdapter(name string)
 	GetInstances(ctx context.Context, adapterName string) ([]string, error)
+	GetInstance(ctx context.Context, adapterName, instanceName string) (adapter.Instance, error)
 	GetOrCreateInstance(ctx context.Context, adapterName, instanceName string) (adapter.Instance, error)
+	CreateInstance(ctx context.Context, adapterName, instanceName string, opts adapter.CreateOptions) (adapter.Instance, error)
+	DeleteInstance(ctx context.Context, adapterName, instanceName string) error
+	RotateCredentials(ctx context.Context, adapterName, instanceName string, opts adapter.RotateOptions) (adapter.Instance, error)
+	RenewLease(ctx context.Context, adapterName, instanceName, leaseID string) (adapter.Instance, error)
+	CloneInstance(ctx context.Context, adapterName, instanceName, cloneName string) (adapter.Instance, error)
+	InstallExtensions(ctx context.Context, adapterName, instanceName string, extensions []string) (adapter.Instance, error)
+	ResetInstance(ctx context.Context, adapterName, instanceName string) (adapter.Instance, error)
+	CreateInstanceAsync(ctx context.Context, adapterName, instanceName string, opts adapter.CreateOptions) (Operation, error)
+	GetOrCreateInstanceAsync(ctx context.Context, adapterName, instanceName string) (Operation, error)
+	DeleteInstanceAsync(ctx context.Context, adapterName, instanceName string) (Operation, error)
+	GetOperation(ctx context.Context, id string) (Operation, error)
+	DetectDrift(ctx context.Context, repair bool) (map[string]*adapter.DriftReport, error)
+	CollectGarbage(ctx context.Context, dryRun bool) (map[string]*GCReport, error)
+	GetPoolStats(ctx context.Context) []PoolStats
+	GetTemplates(ctx context.Context, adapterName string) ([]adapter.Template, error)
+	RegisterTemplate(ctx context.Context, adapterName, templateName, database string) (adapter.Template, error)
+	UnregisterTemplate(ctx context.Context, adapterName, templateName string) error
+	Close() error
 }
 
 type broker struct {
 	mu       sync.RWMutex
 	adapters map[string]adapter.Interface
+	workers  int
+	opsMu    sync.Mutex
+	ops      map[string]*operation
+	queue    chan *operation
+	done     chan struct{}
+	wg       sync.WaitGroup
+
+	expiryInterval time.Duration
+	pools          map[string]*pool
+
+	gcInterval  time.Duration
+	gcMinAge    time.Duration
+	gcDryRun    bool
+	gcMu        sync.Mutex
+	orphansSeen map[string]time.Time
+}
+
+type Option func(b *broker)
+
+// WithWorkers sets how many asynchronous operations are processed at the same time
+func WithWorkers(workers int) Option {
+	return func(b *broker) {
+		b.workers = workers
+	}
 }
 
-func New() Interface {
-	return &broker{
+func New(opts ...Option) Interface {
+	b := &broker{
 		adapters: map[string]adapter.Interface{},
+		workers:  defaultWorkers,
+		ops:      map[string]*operation{},
+		queue:    make(chan *operation, operationQueueSize),
+		done:     make(chan struct{}),
+
+		expiryInterval: defaultExpiryInterval,
+		pools:          map[string]*pool{},
+		gcMinAge:       defaultGCMinAge,
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	// without workers the operations would stay pending forever
+	b.workers = max(b.workers, 1)
+	// the age threshold keeps garbage collection from racing instance creations
+	if b.gcMinAge <= 0 {
+		b.gcMinAge = defaultGCMinAge
+	}
+	if b.gcInterval > 0 {
+		go b.gcLoop()
+	}
+	if b.expiryInterval > 0 {
+		go b.expiryLoop()
 	}
+	for _, p := range b.pools {
+		go b.poolLoop(p)
+	}
+	b.wg.Add(b.workers)
+	for range b.workers {
+		go b.worker()
+	}
+	return b
+}
+
+// Close stops the workers after their running operations, pending ones are dropped
+func (b *broker) Close() error {
+	close(b.done)
+	b.wg.Wait()
+	return nil
 }
 
 func (b *broker) RegisterAdapter(name string, adapter adapter.Interface) {
 	b.mu.Lock()
-	defer b.mu.Unlock()
 	b.adapters[strings.ToLower(name)] = adapter
+	b.mu.Unlock()
+	if p := b.pools[strings.ToLower(name)]; p != nil {
+		p.triggerRefill()
+	}
 }
 
 func (b *broker) UnregisterAdapter(name string) {
@@ -42,26 +131,248 @@ func (b *broker) UnregisterAdapter(name string) {
 	delete(b.adapters, strings.ToLower(name))
 }
 
+// GetInstances leaves out the instances of warm pools
 func (b *broker) GetInstances(ctx context.Context, adapterName string) ([]string, error) {
+	a, err := b.getAdapter(adapterName)
+	if err != nil {
+		return nil, err
+	}
+	instanceNames, err := a.GetInstances(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(instanceNames, isPooledInstance), nil
+}
+
+func (b *broker) GetInstance(ctx context.Context, adapterName, instanceName string) (adapter.Instance, error) {
+	instanceName, err := normalizeInstanceName(instanceName)
+	if err != nil {
+		return nil, err
+	}
+	a, err := b.getAdapter(adapterName)
+	if err != nil {
+		return nil, err
+	}
+	instance, err := a.GetInstance(ctx, instanceName)
+	return instance, wrapAdapterError(err, instanceName)
+}
+
+func (b *broker) GetOrCreateInstance(ctx context.Context, adapterName, instanceName string) (adapter.Instance, error) {
+	instanceName, err := normalizeInstanceName(instanceName)
+	if err != nil {
+		return nil, err
+	}
+	a, err := b.getAdapter(adapterName)
+	if err != nil {
+		return nil, err
+	}
+	return b.getOrCreateInstance(ctx, adapterName, a, instanceName)
+}
+
+func (b *broker) getOrCreateInstance(ctx context.Context, adapterName string, a adapter.Interface, instanceName string) (adapter.Instance, error) {
+	if b.hasPool(adapterName) {
+		if _, err := a.GetInstance(ctx, instanceName); errors.Is(err, adapter.ErrInstanceNotFound) {
+			if instance := b.claimPooledInstance(ctx, adapterName, a, instanceName, adapter.CreateOptions{}); instance != nil {
+				return instance, nil
+			}
+		}
+	}
+	return a.GetOrCreateInstance(ctx, instanceName)
+}
+
+func (b *broker) CreateInstance(ctx context.Context, adapterName, instanceName string, opts adapter.CreateOptions) (adapter.Instance, error) {
+	instanceName, err := normalizeInstanceName(instanceName)
+	if err != nil {
+		return nil, err
+	}
+	a, err := b.getAdapter(adapterName)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkExpiry(a, adapterName, opts); err != nil {
+		return nil, err
+	}
+	instance, err := b.createInstance(ctx, adapterName, a, instanceName, opts)
+	return instance, wrapAdapterError(err, instanceName)
+}
+
+// createInstance claims an instance from the adapter's warm pool if it can, or else creates it
+func (b *broker) createInstance(ctx context.Context, adapterName string, a adapter.Interface, instanceName string, opts adapter.CreateOptions) (adapter.Instance, error) {
+	if instance := b.claimPooledInstance(ctx, adapterName, a, instanceName, opts); instance != nil {
+		return instance, nil
+	}
+	return a.CreateInstance(ctx, instanceName, opts)
+}
+
+func (b *broker) DeleteInstance(ctx context.Context, adapterName, instanceName string) error {
+	instanceName, err := normalizeInstanceName(instanceName)
+	if err != nil {
+		return err
+	}
+	a, err := b.getAdapter(adapterName)
+	if err != nil {
+		return err
+	}
+	return wrapAdapterError(a.DeleteInstance(ctx, instanceName), instanceName)
+}
+
+func (b *broker) RotateCredentials(ctx context.Context, adapterName, instanceName string, opts adapter.RotateOptions) (adapter.Instance, error) {
+	instanceName, err := normalizeInstanceName(instanceName)
+	if err != nil {
+		return nil, err
+	}
+	a, err := b.getAdapter(adapterName)
+	if err != nil {
+		return nil, err
+	}
+	instance, err := a.RotateCredentials(ctx, instanceName, opts)
+	return instance, wrapAdapterError(err, instanceName)
+}
+
+func (b *broker) CloneInstance(ctx context.Context, adapterName, instanceName, cloneName string) (adapter.Instance, error) {
+	instanceName, err := normalizeInstanceName(instanceName)
+	if err != nil {
+		return nil, err
+	}
+	cloneName, err = normalizeInstanceName(cloneName)
+	if err != nil {
+		return nil, err
+	}
+	a, err := b.getAdapter(adapterName)
+	if err != nil {
+		return nil, err
+	}
+	cloner, ok := a.(adapter.Cloner)
+	if !ok {
+		return nil, newError("cloning is not supported by adapter: %s", adapterName).WithStatusCode(http.StatusUnprocessableEntity)
+	}
+	instance, err := cloner.CloneInstance(ctx, instanceName, cloneName)
+	// only the clone can already exist, anything else is about the source
+	if errors.Is(err, adapter.ErrInstanceExists) {
+		return nil, wrapAdapterError(err, cloneName)
+	}
+	return instance, wrapAdapterError(err, instanceName)
+}
+
+func (b *broker) InstallExtensions(ctx context.Context, adapterName, instanceName string, extensions []string) (adapter.Instance, error) {
+	instanceName, err := normalizeInstanceName(instanceName)
+	if err != nil {
+		return nil, err
+	}
+	a, err := b.getAdapter(adapterName)
+	if err != nil {
+		return nil, err
+	}
+	installer, ok := a.(adapter.ExtensionInstaller)
+	if !ok {
+		return nil, newError("extensions are not supported by adapter: %s", adapterName).WithStatusCode(http.StatusUnprocessableEntity)
+	}
+	instance, err := installer.InstallExtensions(ctx, instanceName, extensions)
+	return instance, wrapAdapterError(err, instanceName)
+}
+
+func (b *broker) ResetInstance(ctx context.Context, adapterName, instanceName string) (adapter.Instance, error) {
+	instanceName, err := normalizeInstanceName(instanceName)
+	if err != nil {
+		return nil, err
+	}
+	a, err := b.getAdapter(adapterName)
+	if err != nil {
+		return nil, err
+	}
+	resetter, ok := a.(adapter.Resetter)
+	if !ok {
+		return nil, newError("resetting is not supported by adapter: %s", adapterName).WithStatusCode(http.StatusUnprocessableEntity)
+	}
+	instance, err := resetter.ResetInstance(ctx, instanceName)
+	return instance, wrapAdapterError(err, instanceName)
+}
+
+// DetectDrift returns the drift reports of the adapters that support it, by adapter name.
+// Adapters registered under multiple names or sharing their records are only checked once,
+// under the first name in alphabetical order.
+func (b *broker) DetectDrift(ctx context.Context, repair bool) (map[string]*adapter.DriftReport, error) {
+	reports := map[string]*adapter.DriftReport{}
+	for _, na := range b.uniqueAdapters() {
+		detector, ok := na.adapter.(adapter.DriftDetector)
+		if !ok {
+			continue
+		}
+		report, err := detector.DetectDrift(ctx, repair)
+		if err != nil {
+			return nil, fmt.Errorf("detect %s drift: %w", na.name, err)
+		}
+		reports[na.name] = report
+	}
+	return reports, nil
+}
+
+type namedAdapter struct {
+	name    string
+	adapter adapter.Interface
+}
+
+// uniqueAdapters returns the registered adapters in alphabetical order of their names.
+// Adapters registered under multiple names, or sharing their records with another adapter,
+// are only returned once, with the first name.
+func (b *broker) uniqueAdapters() []namedAdapter {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	var adapters []namedAdapter
+	seen := map[any]bool{}
+	for _, name := range slices.Sorted(maps.Keys(b.adapters)) {
+		a := b.adapters[name]
+		var key any = a
+		if shared, ok := a.(adapter.SharedRecords); ok {
+			key = shared.RecordsID()
+		}
+		if !seen[key] {
+			seen[key] = true
+			adapters = append(adapters, namedAdapter{name: name, adapter: a})
+		}
+	}
+	return adapters
+}
+
+func (b *broker) getAdapter(adapterName string) (adapter.Interface, error) {
 	b.mu.RLock()
 	a, ok := b.adapters[strings.ToLower(adapterName)]
 	b.mu.RUnlock()
 	if !ok {
 		return nil, newError("adapter not found: %s", adapterName).WithStatusCode(http.StatusNotFound)
 	}
-	return a.GetInstances(ctx)
+	return a, nil
 }
 
-func (b *broker) GetOrCreateInstance(ctx context.Context, adapterName, instanceName string) (adapter.Instance, error) {
+func normalizeInstanceName(instanceName string) (string, error) {
 	instanceName = strings.ToLower(instanceName)
 	if !validInstanceName.MatchString(instanceName) {
-		return nil, newError("invalid instance name: %s", instanceName).WithStatusCode(http.StatusUnprocessableEntity)
+		return "", newError("invalid instance name: %s", instanceName).WithStatusCode(http.StatusUnprocessableEntity)
 	}
-	b.mu.RLock()
-	a, ok := b.adapters[strings.ToLower(adapterName)]
-	b.mu.RUnlock()
-	if !ok {
-		return nil, newError("adapter not found: %s", adapterName).WithStatusCode(http.StatusNotFound)
+	return instanceName, nil
+}
+
+func wrapAdapterError(err error, instanceName string) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, adapter.ErrInstanceNotFound):
+		return newError("instance not found: %s", instanceName).WithStatusCode(http.StatusNotFound)
+	case errors.Is(err, adapter.ErrInstanceExists):
+		return newError("instance already exists: %s", instanceName).WithStatusCode(http.StatusConflict)
+	case errors.Is(err, adapter.ErrInvalidParams):
+		return newError("%s", err.Error()).WithStatusCode(http.StatusUnprocessableEntity)
+	case errors.Is(err, adapter.ErrConflict):
+		return newError("%s", err.Error()).WithStatusCode(http.StatusConflict)
+	case errors.Is(err, adapter.ErrInstanceNotReady):
+		return newError("%s", err.Error()).WithStatusCode(http.StatusConflict)
+	case errors.Is(err, adapter.ErrInvalidLease):
+		return newError("%s", err.Error()).WithStatusCode(http.StatusConflict)
+	case errors.Is(err, adapter.ErrTemplateNotFound):
+		return newError("%s", err.Error()).WithStatusCode(http.StatusNotFound)
+	case errors.Is(err, adapter.ErrTemplateExists):
+		return newError("%s", err.Error()).WithStatusCode(http.StatusConflict)
+	default:
+		return err
 	}
-	return a.GetOrCreateInstance(ctx, instanceName)
 }
@@ -2,14 +2,17 @@ package broker_test
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/razzie-cloud/database-broker/internal/adapter"
 	"github.com/razzie-cloud/database-broker/internal/broker"
 
 	"github.com/razzie/mock"
 	"github.com/stretchr/testify/assert"
+	testifymock "github.com/stretchr/testify/mock"
 )
 
 func TestRegisterAndUnregisterAdapter(t *testing.T) {
@@ -52,3 +55,504 @@ func assertErrorStatusCode(t *testing.T, err error, statusCode int) {
 		t.Errorf("error does not implement StatusCode method")
 	}
 }
+
+func TestGetInstance_NotFound(t *testing.T) {
+	b := broker.New()
+	a, m := mock.Mock[adapter.Interface]()
+	m.On("GetInstance", mock.Anything, "validname").Return(nil, adapter.ErrInstanceNotFound)
+	b.RegisterAdapter("test", a)
+	_, err := b.GetInstance(context.Background(), "test", "validname")
+	assertErrorStatusCode(t, err, http.StatusNotFound)
+	m.AssertExpectations(t)
+}
+
+func TestCreateInstance_Exists(t *testing.T) {
+	b := broker.New()
+	a, m := mock.Mock[adapter.Interface]()
+	m.On("CreateInstance", mock.Anything, "validname", mock.Anything).Return(nil, adapter.ErrInstanceExists)
+	b.RegisterAdapter("test", a)
+	_, err := b.CreateInstance(context.Background(), "test", "validname", adapter.CreateOptions{})
+	assertErrorStatusCode(t, err, http.StatusConflict)
+	m.AssertExpectations(t)
+}
+
+func TestCreateInstance_InvalidParams(t *testing.T) {
+	b := broker.New()
+	a, m := mock.Mock[adapter.Interface]()
+	m.On("CreateInstance", mock.Anything, "validname", mock.Anything).Return(nil, fmt.Errorf("%w: bad", adapter.ErrInvalidParams))
+	b.RegisterAdapter("test", a)
+	_, err := b.CreateInstance(context.Background(), "test", "validname", adapter.CreateOptions{})
+	assertErrorStatusCode(t, err, http.StatusUnprocessableEntity)
+	m.AssertExpectations(t)
+}
+
+func TestCreateInstance_Conflict(t *testing.T) {
+	b := broker.New()
+	a, m := mock.Mock[adapter.Interface]()
+	m.On("CreateInstance", mock.Anything, "validname", mock.Anything).Return(nil, fmt.Errorf("%w: database exists", adapter.ErrConflict))
+	b.RegisterAdapter("test", a)
+	_, err := b.CreateInstance(context.Background(), "test", "validname", adapter.CreateOptions{})
+	assertErrorStatusCode(t, err, http.StatusConflict)
+	m.AssertExpectations(t)
+}
+
+func TestCreateInstance_TTLNotSupported(t *testing.T) {
+	b := broker.New()
+	a, m := mock.Mock[adapter.Interface]()
+	b.RegisterAdapter("test", a)
+	_, err := b.CreateInstance(context.Background(), "test", "validname", adapter.CreateOptions{TTL: time.Hour})
+	assertErrorStatusCode(t, err, http.StatusUnprocessableEntity)
+	m.AssertExpectations(t)
+}
+
+func TestDeleteInstance(t *testing.T) {
+	b := broker.New()
+	a, m := mock.Mock[adapter.Interface]()
+	m.On("DeleteInstance", mock.Anything, "validname").Return(nil)
+	b.RegisterAdapter("test", a)
+	err := b.DeleteInstance(context.Background(), "test", "ValidName")
+	assert.NoError(t, err)
+	m.AssertExpectations(t)
+}
+
+func TestDeleteInstance_NotFound(t *testing.T) {
+	b := broker.New()
+	a, m := mock.Mock[adapter.Interface]()
+	m.On("DeleteInstance", mock.Anything, "validname").Return(adapter.ErrInstanceNotFound)
+	b.RegisterAdapter("test", a)
+	err := b.DeleteInstance(context.Background(), "test", "validname")
+	assertErrorStatusCode(t, err, http.StatusNotFound)
+	m.AssertExpectations(t)
+}
+
+func TestRotateCredentials_NotFound(t *testing.T) {
+	b := broker.New()
+	a, m := mock.Mock[adapter.Interface]()
+	m.On("RotateCredentials", mock.Anything, "validname", mock.Anything).Return(nil, adapter.ErrInstanceNotFound)
+	b.RegisterAdapter("test", a)
+	_, err := b.RotateCredentials(context.Background(), "test", "validname", adapter.RotateOptions{})
+	assertErrorStatusCode(t, err, http.StatusNotFound)
+	m.AssertExpectations(t)
+}
+
+func TestRotateCredentials_NotReady(t *testing.T) {
+	b := broker.New()
+	a, m := mock.Mock[adapter.Interface]()
+	m.On("RotateCredentials", mock.Anything, "validname", mock.Anything).Return(nil, fmt.Errorf("%w: failed", adapter.ErrInstanceNotReady))
+	b.RegisterAdapter("test", a)
+	_, err := b.RotateCredentials(context.Background(), "test", "validname", adapter.RotateOptions{})
+	assertErrorStatusCode(t, err, http.StatusConflict)
+	m.AssertExpectations(t)
+}
+
+func TestCreateInstanceAsync(t *testing.T) {
+	b := broker.New()
+	defer b.Close()
+	i, imock := mock.Mock[adapter.Instance]()
+	imock.On("GetJSON").Return(map[string]string{"instance": "validname"})
+	a, m := mock.Mock[adapter.Interface]()
+	m.On("CreateInstance", mock.Anything, "validname", mock.Anything).Return(i, nil)
+	b.RegisterAdapter("test", a)
+	op, err := b.CreateInstanceAsync(context.Background(), "test", "ValidName", adapter.CreateOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, broker.OperationCreate, op.Type)
+	assert.Equal(t, "validname", op.Instance)
+	op = waitForOperation(t, b, op.ID)
+	assert.Equal(t, broker.OperationSucceeded, op.Status)
+	assert.Equal(t, map[string]string{"instance": "validname"}, op.Result)
+	m.AssertExpectations(t)
+}
+
+func TestGetOrCreateInstanceAsync(t *testing.T) {
+	b := broker.New()
+	defer b.Close()
+	i, imock := mock.Mock[adapter.Instance]()
+	imock.On("GetJSON").Return(map[string]string{"instance": "validname"})
+	a, m := mock.Mock[adapter.Interface]()
+	m.On("GetOrCreateInstance", mock.Anything, "validname").Return(i, nil)
+	b.RegisterAdapter("test", a)
+	op, err := b.GetOrCreateInstanceAsync(context.Background(), "test", "ValidName")
+	assert.NoError(t, err)
+	assert.Equal(t, broker.OperationCreate, op.Type)
+	op = waitForOperation(t, b, op.ID)
+	assert.Equal(t, broker.OperationSucceeded, op.Status)
+	assert.Equal(t, map[string]string{"instance": "validname"}, op.Result)
+	m.AssertExpectations(t)
+}
+
+func TestCreateInstanceAsync_AdapterNotFound(t *testing.T) {
+	b := broker.New()
+	defer b.Close()
+	_, err := b.CreateInstanceAsync(context.Background(), "missing", "validname", adapter.CreateOptions{})
+	assertErrorStatusCode(t, err, http.StatusNotFound)
+}
+
+func TestDeleteInstanceAsync_NotFound(t *testing.T) {
+	b := broker.New()
+	defer b.Close()
+	a, m := mock.Mock[adapter.Interface]()
+	m.On("DeleteInstance", mock.Anything, "validname").Return(adapter.ErrInstanceNotFound)
+	b.RegisterAdapter("test", a)
+	op, err := b.DeleteInstanceAsync(context.Background(), "test", "validname")
+	assert.NoError(t, err)
+	op = waitForOperation(t, b, op.ID)
+	assert.Equal(t, broker.OperationFailed, op.Status)
+	assert.Equal(t, "instance not found: validname", op.Error)
+	m.AssertExpectations(t)
+}
+
+func TestGetOperation_NotFound(t *testing.T) {
+	b := broker.New()
+	defer b.Close()
+	_, err := b.GetOperation(context.Background(), "missing")
+	assertErrorStatusCode(t, err, http.StatusNotFound)
+}
+
+func waitForOperation(t *testing.T, b broker.Interface, id string) broker.Operation {
+	t.Helper()
+	var op broker.Operation
+	assert.Eventually(t, func() bool {
+		var err error
+		op, err = b.GetOperation(context.Background(), id)
+		assert.NoError(t, err)
+		return op.Status == broker.OperationSucceeded || op.Status == broker.OperationFailed
+	}, time.Second, 10*time.Millisecond)
+	return op
+}
+
+type DriftAdapter interface {
+	adapter.Interface
+	adapter.DriftDetector
+}
+
+func TestDetectDrift(t *testing.T) {
+	b := broker.New()
+	defer b.Close()
+	report := &adapter.DriftReport{OrphanedUsers: []string{"user_foo_abcd"}}
+	a, m := mock.Mock[DriftAdapter]()
+	m.On("DetectDrift", mock.Anything, true).Return(report, nil).Once()
+	b.RegisterAdapter("test", a)
+	b.RegisterAdapter("alias", a)
+	other, _ := mock.Mock[adapter.Interface]()
+	b.RegisterAdapter("other", other)
+	reports, err := b.DetectDrift(context.Background(), true)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]*adapter.DriftReport{"alias": report}, reports)
+	m.AssertExpectations(t)
+}
+
+type SharedRecordsAdapter interface {
+	adapter.Interface
+	adapter.DriftDetector
+	adapter.SharedRecords
+}
+
+func TestDetectDrift_SharedRecords(t *testing.T) {
+	b := broker.New()
+	defer b.Close()
+	report := &adapter.DriftReport{}
+	a, m := mock.Mock[SharedRecordsAdapter]()
+	m.On("RecordsID").Return("server")
+	m.On("DetectDrift", mock.Anything, true).Return(report, nil).Once()
+	b.RegisterAdapter("test", a)
+	other, otherMock := mock.Mock[SharedRecordsAdapter]()
+	otherMock.On("RecordsID").Return("server")
+	b.RegisterAdapter("test-schema", other)
+	reports, err := b.DetectDrift(context.Background(), true)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]*adapter.DriftReport{"test": report}, reports)
+	m.AssertExpectations(t)
+	otherMock.AssertNotCalled(t, "DetectDrift", mock.Anything, mock.Anything)
+}
+
+type GarbageCollectorAdapter interface {
+	adapter.Interface
+	adapter.GarbageCollector
+}
+
+func TestCollectGarbage(t *testing.T) {
+	b := broker.New(broker.WithGarbageCollection(0, time.Nanosecond, false))
+	defer b.Close()
+	report := &adapter.DriftReport{OrphanedDatabases: []string{"db_foo"}, OrphanedUsers: []string{"user_foo_abcd"}}
+	a, m := mock.Mock[GarbageCollectorAdapter]()
+	m.On("DetectDrift", mock.Anything, mock.Anything).Return(report, nil)
+	m.On("DropOrphans", mock.Anything, []string{"db_foo"}, []string{"user_foo_abcd"}).Return(nil).Once()
+	b.RegisterAdapter("test", a)
+
+	// orphans found for the first time are too young to be dropped
+	reports, err := b.CollectGarbage(context.Background(), false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"db_foo", "user_foo_abcd"}, reports["test"].Pending)
+	assert.Empty(t, reports["test"].Databases)
+
+	time.Sleep(time.Millisecond)
+	reports, err = b.CollectGarbage(context.Background(), true)
+	assert.NoError(t, err)
+	assert.Equal(t, &broker.GCReport{DryRun: true, Databases: []string{"db_foo"}, Users: []string{"user_foo_abcd"}}, reports["test"])
+
+	reports, err = b.CollectGarbage(context.Background(), false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"user_foo_abcd"}, reports["test"].Users)
+	m.AssertExpectations(t)
+}
+
+type ExpirerAdapter interface {
+	adapter.Interface
+	adapter.Expirer
+}
+
+func TestDeleteExpiredInstances(t *testing.T) {
+	b := broker.New(broker.WithExpiryInterval(10 * time.Millisecond))
+	defer b.Close()
+	deleted := make(chan struct{})
+	a, m := mock.Mock[ExpirerAdapter]()
+	m.On("DeleteExpiredInstances", mock.Anything, mock.Anything).Return([]string{"foo"}, nil).Once().Run(func(testifymock.Arguments) {
+		close(deleted)
+	})
+	m.On("DeleteExpiredInstances", mock.Anything, mock.Anything).Return(nil, nil)
+	b.RegisterAdapter("test", a)
+	select {
+	case <-deleted:
+	case <-time.After(time.Second):
+		t.Fatal("expired instances were not deleted")
+	}
+}
+
+type LeaserAdapter interface {
+	adapter.Interface
+	adapter.Leaser
+}
+
+func TestRenewLease_Invalid(t *testing.T) {
+	b := broker.New()
+	defer b.Close()
+	a, m := mock.Mock[LeaserAdapter]()
+	m.On("RenewLease", mock.Anything, "validname", "wrong").Return(nil, fmt.Errorf("%w: wrong", adapter.ErrInvalidLease))
+	b.RegisterAdapter("test", a)
+	_, err := b.RenewLease(context.Background(), "test", "validname", "wrong")
+	assertErrorStatusCode(t, err, http.StatusConflict)
+	m.AssertExpectations(t)
+}
+
+func TestRenewLease_NotSupported(t *testing.T) {
+	b := broker.New()
+	defer b.Close()
+	a, _ := mock.Mock[adapter.Interface]()
+	b.RegisterAdapter("test", a)
+	_, err := b.RenewLease(context.Background(), "test", "validname", "lease")
+	assertErrorStatusCode(t, err, http.StatusUnprocessableEntity)
+}
+
+type ClaimerAdapter interface {
+	adapter.Interface
+	adapter.Claimer
+}
+
+func TestWarmPool(t *testing.T) {
+	b := broker.New(broker.WithWarmPool("test", 1))
+	defer b.Close()
+	i, _ := mock.Mock[adapter.Instance]()
+	a, m := mock.Mock[ClaimerAdapter]()
+	m.On("GetInstances", mock.Anything).Return([]string{"foo", "pool_foo", "POOL_abcd"}, nil)
+	m.On("ClaimInstance", mock.Anything, "POOL_abcd", "bar", mock.Anything).Return(i, nil).Once()
+	m.On("CreateInstance", mock.Anything, mock.Anything, mock.Anything).Return(i, nil)
+	b.RegisterAdapter("test", a)
+
+	// the pooled instance left over from a previous run is taken over
+	assert.Eventually(t, func() bool {
+		return b.GetPoolStats(context.Background())[0].Fill == 1
+	}, time.Second, 10*time.Millisecond)
+	instances, err := b.GetInstances(context.Background(), "test")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"foo", "pool_foo"}, instances)
+
+	instance, err := b.CreateInstance(context.Background(), "test", "bar", adapter.CreateOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, i, instance)
+	assert.Eventually(t, func() bool {
+		stats := b.GetPoolStats(context.Background())[0]
+		return stats.Claims == 1 && stats.Fill == 1
+	}, time.Second, 10*time.Millisecond)
+	m.AssertExpectations(t)
+}
+
+func TestWarmPool_PooledNameNotReachable(t *testing.T) {
+	b := broker.New()
+	defer b.Close()
+	i, _ := mock.Mock[adapter.Instance]()
+	a, m := mock.Mock[adapter.Interface]()
+	m.On("GetInstance", mock.Anything, "pool_abcd").Return(i, nil)
+	b.RegisterAdapter("test", a)
+
+	// the name is lowercased, so it's not the pooled instance
+	instance, err := b.GetInstance(context.Background(), "test", "POOL_abcd")
+	assert.NoError(t, err)
+	assert.Equal(t, i, instance)
+	m.AssertExpectations(t)
+}
+
+func TestWarmPool_KeepsPooledInstanceOnRequestError(t *testing.T) {
+	b := broker.New(broker.WithWarmPool("test", 1))
+	defer b.Close()
+	i, _ := mock.Mock[adapter.Instance]()
+	a, m := mock.Mock[ClaimerAdapter]()
+	m.On("GetInstances", mock.Anything).Return([]string{"POOL_abcd"}, nil)
+	m.On("ClaimInstance", mock.Anything, "POOL_abcd", "bar", mock.Anything).Return(nil, context.Canceled).Once()
+	m.On("CreateInstance", mock.Anything, "bar", mock.Anything).Return(nil, context.Canceled).Once()
+	b.RegisterAdapter("test", a)
+	assert.Eventually(t, func() bool {
+		return b.GetPoolStats(context.Background())[0].Fill == 1
+	}, time.Second, 10*time.Millisecond)
+
+	_, err := b.CreateInstance(context.Background(), "test", "bar", adapter.CreateOptions{})
+	assert.Error(t, err)
+	assert.Equal(t, 1, b.GetPoolStats(context.Background())[0].Fill)
+	m.AssertNotCalled(t, "DeleteInstance", mock.Anything, "POOL_abcd")
+
+	// a broken pooled instance gets discarded
+	m.On("ClaimInstance", mock.Anything, "POOL_abcd", "baz", mock.Anything).Return(nil, adapter.ErrInstanceNotFound).Once()
+	discarded := make(chan struct{})
+	m.On("DeleteInstance", mock.Anything, "POOL_abcd").Return(adapter.ErrInstanceNotFound).Once().
+		Run(func(testifymock.Arguments) { close(discarded) })
+	m.On("CreateInstance", mock.Anything, mock.Anything, mock.Anything).Return(i, nil)
+	instance, err := b.CreateInstance(context.Background(), "test", "baz", adapter.CreateOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, i, instance)
+	select {
+	case <-discarded:
+	case <-time.After(time.Second):
+		t.Fatal("pooled instance was not discarded")
+	}
+	m.AssertExpectations(t)
+}
+
+type ClonerAdapter interface {
+	adapter.Interface
+	adapter.Cloner
+}
+
+func TestCloneInstance(t *testing.T) {
+	b := broker.New()
+	defer b.Close()
+	a, m := mock.Mock[ClonerAdapter]()
+	i, _ := mock.Mock[adapter.Instance]()
+	m.On("CloneInstance", mock.Anything, "foo", "bar").Return(i, nil)
+	m.On("CloneInstance", mock.Anything, "foo", "baz").Return(nil, adapter.ErrInstanceExists)
+	b.RegisterAdapter("test", a)
+
+	instance, err := b.CloneInstance(context.Background(), "test", "foo", "BAR")
+	assert.NoError(t, err)
+	assert.Equal(t, i, instance)
+	_, err = b.CloneInstance(context.Background(), "test", "foo", "baz")
+	assertErrorStatusCode(t, err, http.StatusConflict)
+	assert.ErrorContains(t, err, "baz")
+	m.AssertExpectations(t)
+}
+
+func TestCloneInstance_NotSupported(t *testing.T) {
+	b := broker.New()
+	defer b.Close()
+	a, _ := mock.Mock[adapter.Interface]()
+	b.RegisterAdapter("test", a)
+	_, err := b.CloneInstance(context.Background(), "test", "foo", "bar")
+	assertErrorStatusCode(t, err, http.StatusUnprocessableEntity)
+}
+
+type TemplateManagerAdapter interface {
+	adapter.Interface
+	adapter.TemplateManager
+}
+
+func TestTemplates(t *testing.T) {
+	b := broker.New()
+	defer b.Close()
+	a, m := mock.Mock[TemplateManagerAdapter]()
+	template := adapter.Template{Name: "base", Database: "base_template"}
+	m.On("RegisterTemplate", mock.Anything, "base", "base_template").Return(template, nil)
+	m.On("GetTemplates", mock.Anything).Return([]adapter.Template{template}, nil)
+	m.On("UnregisterTemplate", mock.Anything, "other").Return(fmt.Errorf("%w: other", adapter.ErrTemplateNotFound))
+	b.RegisterAdapter("test", a)
+
+	registered, err := b.RegisterTemplate(context.Background(), "test", "BASE", "base_template")
+	assert.NoError(t, err)
+	assert.Equal(t, template, registered)
+	templates, err := b.GetTemplates(context.Background(), "test")
+	assert.NoError(t, err)
+	assert.Equal(t, []adapter.Template{template}, templates)
+	err = b.UnregisterTemplate(context.Background(), "test", "other")
+	assertErrorStatusCode(t, err, http.StatusNotFound)
+	_, err = b.RegisterTemplate(context.Background(), "test", "invalid-name", "base_template")
+	assertErrorStatusCode(t, err, http.StatusUnprocessableEntity)
+	m.AssertExpectations(t)
+}
+
+func TestTemplates_NotSupported(t *testing.T) {
+	b := broker.New()
+	defer b.Close()
+	a, _ := mock.Mock[adapter.Interface]()
+	b.RegisterAdapter("test", a)
+	_, err := b.GetTemplates(context.Background(), "test")
+	assertErrorStatusCode(t, err, http.StatusUnprocessableEntity)
+}
+
+type ExtensionInstallerAdapter interface {
+	adapter.Interface
+	adapter.ExtensionInstaller
+}
+
+func TestInstallExtensions(t *testing.T) {
+	b := broker.New()
+	defer b.Close()
+	a, m := mock.Mock[ExtensionInstallerAdapter]()
+	i, _ := mock.Mock[adapter.Instance]()
+	m.On("InstallExtensions", mock.Anything, "foo", []string{"pgcrypto"}).Return(i, nil)
+	m.On("InstallExtensions", mock.Anything, "foo", []string{"plpython3u"}).Return(nil, fmt.Errorf("%w: extension not allowed: plpython3u", adapter.ErrInvalidParams))
+	b.RegisterAdapter("test", a)
+
+	instance, err := b.InstallExtensions(context.Background(), "test", "foo", []string{"pgcrypto"})
+	assert.NoError(t, err)
+	assert.Equal(t, i, instance)
+	_, err = b.InstallExtensions(context.Background(), "test", "foo", []string{"plpython3u"})
+	assertErrorStatusCode(t, err, http.StatusUnprocessableEntity)
+	m.AssertExpectations(t)
+}
+
+func TestInstallExtensions_NotSupported(t *testing.T) {
+	b := broker.New()
+	defer b.Close()
+	a, _ := mock.Mock[adapter.Interface]()
+	b.RegisterAdapter("test", a)
+	_, err := b.InstallExtensions(context.Background(), "test", "foo", []string{"pgcrypto"})
+	assertErrorStatusCode(t, err, http.StatusUnprocessableEntity)
+}
+
+type ResetterAdapter interface {
+	adapter.Interface
+	adapter.Resetter
+}
+
+func TestResetInstance(t *testing.T) {
+	b := broker.New()
+	defer b.Close()
+	a, m := mock.Mock[ResetterAdapter]()
+	i, _ := mock.Mock[adapter.Instance]()
+	m.On("ResetInstance", mock.Anything, "foo").Return(i, nil)
+	m.On("ResetInstance", mock.Anything, "bar").Return(nil, adapter.ErrInstanceNotFound)
+	b.RegisterAdapter("test", a)
+
+	instance, err := b.ResetInstance(context.Background(), "test", "FOO")
+	assert.NoError(t, err)
+	assert.Equal(t, i, instance)
+	_, err = b.ResetInstance(context.Background(), "test", "bar")
+	assertErrorStatusCode(t, err, http.StatusNotFound)
+	m.AssertExpectations(t)
+}
+
+func TestResetInstance_NotSupported(t *testing.T) {
+	b := broker.New()
+	defer b.Close()
+	a, _ := mock.Mock[adapter.Interface]()
+	b.RegisterAdapter("test", a)
+	_, err := b.ResetInstance(context.Background(), "test", "foo")
+	assertErrorStatusCode(t, err, http.StatusUnprocessableEntity)
+}
@@ -0,0 +1,84 @@
+package broker
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/razzie-cloud/database-broker/internal/adapter"
+)
+
+const defaultExpiryInterval = time.Minute
+
+// WithExpiryInterval sets how often the expired instances get deleted (default: 1m). Zero disables it.
+func WithExpiryInterval(interval time.Duration) Option {
+	return func(b *broker) {
+		b.expiryInterval = interval
+	}
+}
+
+// checkExpiry rejects a time-to-live or lease the adapter can't enforce
+func checkExpiry(a adapter.Interface, adapterName string, opts adapter.CreateOptions) error {
+	if opts.TTL < 0 {
+		return newError("invalid ttl: %s", opts.TTL).WithStatusCode(http.StatusUnprocessableEntity)
+	}
+	if opts.Lease < 0 {
+		return newError("invalid lease: %s", opts.Lease).WithStatusCode(http.StatusUnprocessableEntity)
+	}
+	if _, ok := a.(adapter.Expirer); opts.TTL > 0 && !ok {
+		return newError("ttl is not supported by adapter: %s", adapterName).WithStatusCode(http.StatusUnprocessableEntity)
+	}
+	if _, ok := a.(adapter.Leaser); opts.Lease > 0 && !ok {
+		return newError("leases are not supported by adapter: %s", adapterName).WithStatusCode(http.StatusUnprocessableEntity)
+	}
+	return nil
+}
+
+func (b *broker) RenewLease(ctx context.Context, adapterName, instanceName, leaseID string) (adapter.Instance, error) {
+	instanceName, err := normalizeInstanceName(instanceName)
+	if err != nil {
+		return nil, err
+	}
+	a, err := b.getAdapter(adapterName)
+	if err != nil {
+		return nil, err
+	}
+	leaser, ok := a.(adapter.Leaser)
+	if !ok {
+		return nil, newError("leases are not supported by adapter: %s", adapterName).WithStatusCode(http.StatusUnprocessableEntity)
+	}
+	instance, err := leaser.RenewLease(ctx, instanceName, leaseID)
+	return instance, wrapAdapterError(err, instanceName)
+}
+
+func (b *broker) expiryLoop() {
+	ticker := time.NewTicker(b.expiryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-ticker.C:
+			b.deleteExpiredInstances(context.Background())
+		}
+	}
+}
+
+func (b *broker) deleteExpiredInstances(ctx context.Context) {
+	now := time.Now().UTC()
+	for _, na := range b.uniqueAdapters() {
+		expirer, ok := na.adapter.(adapter.Expirer)
+		if !ok {
+			continue
+		}
+		deleted, err := expirer.DeleteExpiredInstances(ctx, now)
+		if len(deleted) > 0 {
+			log.Printf("deleted expired %s instances: %s", na.name, strings.Join(deleted, ", "))
+		}
+		if err != nil {
+			log.Printf("delete expired %s instances: %v", na.name, err)
+		}
+	}
+}
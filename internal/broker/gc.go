@@ -0,0 +1,121 @@
+package broker
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/razzie-cloud/database-broker/internal/adapter"
+)
+
+const defaultGCMinAge = time.Hour
+
+// GCReport lists the orphaned resources a garbage collection dropped, or would drop in dry-run mode
+type GCReport struct {
+	DryRun    bool     `json:"dry_run"`
+	Databases []string `json:"databases,omitempty"`
+	Users     []string `json:"users,omitempty"`
+	// Pending are the orphans that haven't been orphaned for long enough to be dropped
+	Pending []string `json:"pending,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// WithGarbageCollection makes the broker drop orphaned databases and users every interval,
+// once they've been orphaned for at least minAge (default: 1h). In dry-run mode they only get logged.
+func WithGarbageCollection(interval, minAge time.Duration, dryRun bool) Option {
+	return func(b *broker) {
+		b.gcInterval = interval
+		b.gcMinAge = minAge
+		b.gcDryRun = dryRun
+	}
+}
+
+// CollectGarbage drops the orphaned resources of the adapters that support it. Orphans have no creation time,
+// so their age is counted from when the broker first found them, and resets when the broker restarts.
+func (b *broker) CollectGarbage(ctx context.Context, dryRun bool) (map[string]*GCReport, error) {
+	b.gcMu.Lock()
+	defer b.gcMu.Unlock()
+	now := time.Now()
+	seen := map[string]time.Time{}
+	reports := map[string]*GCReport{}
+	for _, na := range b.uniqueAdapters() {
+		gc, ok := na.adapter.(adapter.GarbageCollector)
+		if !ok {
+			continue
+		}
+		drift, err := gc.DetectDrift(ctx, false)
+		if err != nil {
+			return nil, err
+		}
+		report := &GCReport{DryRun: dryRun}
+		for _, name := range drift.OrphanedDatabases {
+			if b.isOldOrphan(seen, na.name+"/database/"+name, now) {
+				report.Databases = append(report.Databases, name)
+			} else {
+				report.Pending = append(report.Pending, name)
+			}
+		}
+		for _, name := range drift.OrphanedUsers {
+			if b.isOldOrphan(seen, na.name+"/user/"+name, now) {
+				report.Users = append(report.Users, name)
+			} else {
+				report.Pending = append(report.Pending, name)
+			}
+		}
+		if !dryRun && (len(report.Databases) > 0 || len(report.Users) > 0) {
+			if err := gc.DropOrphans(ctx, report.Databases, report.Users); err != nil {
+				report.Error = err.Error()
+			}
+		}
+		reports[na.name] = report
+	}
+	// orphans that are gone are forgotten
+	b.orphansSeen = seen
+	return reports, nil
+}
+
+func (b *broker) isOldOrphan(seen map[string]time.Time, key string, now time.Time) bool {
+	firstSeen, ok := b.orphansSeen[key]
+	if !ok {
+		firstSeen = now
+	}
+	seen[key] = firstSeen
+	return now.Sub(firstSeen) >= b.gcMinAge
+}
+
+func (b *broker) gcLoop() {
+	ticker := time.NewTicker(b.gcInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-ticker.C:
+			reports, err := b.CollectGarbage(context.Background(), b.gcDryRun)
+			if err != nil {
+				log.Printf("collect garbage: %v", err)
+				continue
+			}
+			for name, report := range reports {
+				logGCReport(name, report)
+			}
+		}
+	}
+}
+
+func logGCReport(adapterName string, report *GCReport) {
+	verb := "dropped"
+	if report.DryRun {
+		verb = "would drop"
+	}
+	if len(report.Databases) > 0 {
+		log.Printf("%s %s orphaned databases: %s", verb, adapterName, strings.Join(report.Databases, ", "))
+	}
+	if len(report.Users) > 0 {
+		log.Printf("%s %s orphaned users: %s", verb, adapterName, strings.Join(report.Users, ", "))
+	}
+	if report.Error != "" {
+		log.Printf("collect %s garbage: %s", adapterName, report.Error)
+	}
+}
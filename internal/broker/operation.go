@@ -0,0 +1,188 @@
+package broker
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/razzie-cloud/database-broker/internal/adapter"
+	"github.com/razzie-cloud/database-broker/internal/util"
+)
+
+const (
+	defaultWorkers     = 4
+	operationQueueSize = 100
+	// operationRetention is how long finished operations can be polled
+	operationRetention = time.Hour
+)
+
+type OperationType string
+
+const (
+	OperationCreate OperationType = "create"
+	OperationDelete OperationType = "delete"
+)
+
+type OperationStatus string
+
+const (
+	OperationPending   OperationStatus = "pending"
+	OperationRunning   OperationStatus = "running"
+	OperationSucceeded OperationStatus = "succeeded"
+	OperationFailed    OperationStatus = "failed"
+)
+
+// Operation is a create or delete request processed by the broker's workers
+type Operation struct {
+	ID       string          `json:"id"`
+	Type     OperationType   `json:"type"`
+	Adapter  string          `json:"adapter"`
+	Instance string          `json:"instance"`
+	Status   OperationStatus `json:"status"`
+	// Error holds the reason of a failed operation
+	Error string `json:"error,omitempty"`
+	// Result holds the instance details of a succeeded create operation
+	Result    any       `json:"result,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type operation struct {
+	Operation
+	run func(ctx context.Context) (adapter.Instance, error)
+}
+
+func (b *broker) CreateInstanceAsync(ctx context.Context, adapterName, instanceName string, opts adapter.CreateOptions) (Operation, error) {
+	instanceName, err := normalizeInstanceName(instanceName)
+	if err != nil {
+		return Operation{}, err
+	}
+	a, err := b.getAdapter(adapterName)
+	if err != nil {
+		return Operation{}, err
+	}
+	if err := checkExpiry(a, adapterName, opts); err != nil {
+		return Operation{}, err
+	}
+	return b.submitOperation(OperationCreate, adapterName, instanceName, func(ctx context.Context) (adapter.Instance, error) {
+		instance, err := b.createInstance(ctx, adapterName, a, instanceName, opts)
+		return instance, wrapAdapterError(err, instanceName)
+	})
+}
+
+// GetOrCreateInstanceAsync runs GetOrCreateInstance in the background, so an existing instance succeeds right away
+func (b *broker) GetOrCreateInstanceAsync(ctx context.Context, adapterName, instanceName string) (Operation, error) {
+	instanceName, err := normalizeInstanceName(instanceName)
+	if err != nil {
+		return Operation{}, err
+	}
+	a, err := b.getAdapter(adapterName)
+	if err != nil {
+		return Operation{}, err
+	}
+	return b.submitOperation(OperationCreate, adapterName, instanceName, func(ctx context.Context) (adapter.Instance, error) {
+		instance, err := b.getOrCreateInstance(ctx, adapterName, a, instanceName)
+		return instance, wrapAdapterError(err, instanceName)
+	})
+}
+
+func (b *broker) DeleteInstanceAsync(ctx context.Context, adapterName, instanceName string) (Operation, error) {
+	instanceName, err := normalizeInstanceName(instanceName)
+	if err != nil {
+		return Operation{}, err
+	}
+	a, err := b.getAdapter(adapterName)
+	if err != nil {
+		return Operation{}, err
+	}
+	return b.submitOperation(OperationDelete, adapterName, instanceName, func(ctx context.Context) (adapter.Instance, error) {
+		return nil, wrapAdapterError(a.DeleteInstance(ctx, instanceName), instanceName)
+	})
+}
+
+func (b *broker) GetOperation(ctx context.Context, id string) (Operation, error) {
+	b.opsMu.Lock()
+	defer b.opsMu.Unlock()
+	op, ok := b.ops[id]
+	if !ok {
+		return Operation{}, newError("operation not found: %s", id).WithStatusCode(http.StatusNotFound)
+	}
+	return op.Operation, nil
+}
+
+func (b *broker) submitOperation(opType OperationType, adapterName, instanceName string, run func(ctx context.Context) (adapter.Instance, error)) (Operation, error) {
+	now := time.Now().UTC()
+	op := &operation{
+		Operation: Operation{
+			ID:        strings.ToLower(util.RandToken(10)),
+			Type:      opType,
+			Adapter:   strings.ToLower(adapterName),
+			Instance:  instanceName,
+			Status:    OperationPending,
+			CreatedAt: now,
+			UpdatedAt: now,
+		},
+		run: run,
+	}
+	b.opsMu.Lock()
+	defer b.opsMu.Unlock()
+	b.pruneOperations(now)
+	select {
+	case b.queue <- op:
+	default:
+		return Operation{}, newError("too many pending operations").WithStatusCode(http.StatusServiceUnavailable)
+	}
+	b.ops[op.ID] = op
+	return op.Operation, nil
+}
+
+// pruneOperations forgets the operations that finished longer ago than the retention period
+func (b *broker) pruneOperations(now time.Time) {
+	for id, op := range b.ops {
+		finished := op.Status == OperationSucceeded || op.Status == OperationFailed
+		if finished && now.Sub(op.UpdatedAt) > operationRetention {
+			delete(b.ops, id)
+		}
+	}
+}
+
+func (b *broker) worker() {
+	defer b.wg.Done()
+	for {
+		select {
+		case <-b.done:
+			return
+		case op := <-b.queue:
+			b.runOperation(op)
+		}
+	}
+}
+
+// runOperation uses a context of its own, as the request that submitted the operation is already over
+func (b *broker) runOperation(op *operation) {
+	b.updateOperation(op, func() {
+		op.Status = OperationRunning
+	})
+	instance, err := op.run(context.Background())
+	b.updateOperation(op, func() {
+		if err != nil {
+			log.Printf("%s instance %s/%s: %v", op.Type, op.Adapter, op.Instance, err)
+			op.Status = OperationFailed
+			op.Error = err.Error()
+			return
+		}
+		op.Status = OperationSucceeded
+		if instance != nil {
+			op.Result = instance.GetJSON()
+		}
+	})
+}
+
+func (b *broker) updateOperation(op *operation, update func()) {
+	b.opsMu.Lock()
+	defer b.opsMu.Unlock()
+	update()
+	op.UpdatedAt = time.Now().UTC()
+}
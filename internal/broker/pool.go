@@ -0,0 +1,249 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/razzie-cloud/database-broker/internal/adapter"
+	"github.com/razzie-cloud/database-broker/internal/util"
+)
+
+const (
+	// pooledInstancePrefix marks the anonymous instances of warm pools. Instance names are lowercased,
+	// so it can't collide with them, and pooled instances can't be reached through the API.
+	pooledInstancePrefix = "POOL_"
+	poolRefillInterval   = time.Minute
+)
+
+// PoolStats shows the state of the warm pool of an adapter
+type PoolStats struct {
+	Adapter string `json:"adapter"`
+	Size    int    `json:"size"`
+	// Fill is how many pooled instances are ready to be claimed
+	Fill   int `json:"fill"`
+	Claims int `json:"claims"`
+	// Misses counts the creations that found the pool empty
+	Misses           int    `json:"misses"`
+	AvgClaimLatency  string `json:"avg_claim_latency,omitempty"`
+	LastClaimLatency string `json:"last_claim_latency,omitempty"`
+	// Error holds the reason the last refill failed
+	Error string `json:"error,omitempty"`
+}
+
+type pool struct {
+	mu           sync.Mutex
+	adapterName  string
+	size         int
+	loaded       bool
+	instances    []string
+	claims       int
+	misses       int
+	claimLatency time.Duration
+	lastLatency  time.Duration
+	lastError    string
+	refill       chan struct{}
+}
+
+// WithWarmPool keeps size pre-provisioned instances ready for the adapter registered under the given name.
+// Creations without a plan or params claim one of them, if the adapter supports it.
+func WithWarmPool(adapterName string, size int) Option {
+	return func(b *broker) {
+		if size <= 0 {
+			return
+		}
+		adapterName = strings.ToLower(adapterName)
+		b.pools[adapterName] = &pool{
+			adapterName: adapterName,
+			size:        size,
+			refill:      make(chan struct{}, 1),
+		}
+	}
+}
+
+func (b *broker) GetPoolStats(ctx context.Context) []PoolStats {
+	stats := make([]PoolStats, 0, len(b.pools))
+	for _, p := range b.pools {
+		stats = append(stats, p.stats())
+	}
+	slices.SortFunc(stats, func(a, b PoolStats) int {
+		return strings.Compare(a.Adapter, b.Adapter)
+	})
+	return stats
+}
+
+// claimPooledInstance returns nil if the instance has to be created the regular way
+func (b *broker) claimPooledInstance(ctx context.Context, adapterName string, a adapter.Interface, instanceName string, opts adapter.CreateOptions) adapter.Instance {
+	p := b.pools[strings.ToLower(adapterName)]
+	claimer, ok := a.(adapter.Claimer)
+	if p == nil || !ok || opts.Plan != "" || len(opts.Params) > 0 {
+		return nil
+	}
+	start := time.Now()
+	for {
+		pooledName, ok := p.take()
+		if !ok {
+			return nil
+		}
+		p.triggerRefill()
+		instance, err := claimer.ClaimInstance(ctx, pooledName, instanceName, opts)
+		switch {
+		case err == nil:
+			p.recordClaim(time.Since(start))
+			return instance
+		case errors.Is(err, adapter.ErrInstanceNotFound), errors.Is(err, adapter.ErrInstanceNotReady):
+			// the pooled instance is broken, the next one may still work
+			log.Printf("claim pooled %s instance %s: %v", p.adapterName, pooledName, err)
+			go b.discardPooledInstance(a, p, pooledName)
+		default:
+			// the request or the server is at fault, e.g. the request got cancelled,
+			// so the pooled instance is kept and the regular creation reports the error
+			p.put(pooledName)
+			return nil
+		}
+	}
+}
+
+func (b *broker) discardPooledInstance(a adapter.Interface, p *pool, pooledName string) {
+	if err := a.DeleteInstance(context.Background(), pooledName); err != nil && !errors.Is(err, adapter.ErrInstanceNotFound) {
+		log.Printf("delete pooled %s instance %s: %v", p.adapterName, pooledName, err)
+	}
+}
+
+func (b *broker) poolLoop(p *pool) {
+	ticker := time.NewTicker(poolRefillInterval)
+	defer ticker.Stop()
+	for {
+		b.fillPool(context.Background(), p)
+		select {
+		case <-b.done:
+			return
+		case <-ticker.C:
+		case <-p.refill:
+		}
+	}
+}
+
+// fillPool creates pooled instances until the pool is full. The pooled instances left over
+// from a previous run of the broker are taken over first.
+func (b *broker) fillPool(ctx context.Context, p *pool) {
+	a, err := b.getAdapter(p.adapterName)
+	if err != nil {
+		// the adapter may not be registered yet
+		return
+	}
+	if _, ok := a.(adapter.Claimer); !ok {
+		p.setError("warm pools are not supported by the adapter")
+		return
+	}
+	if !p.isLoaded() {
+		instanceNames, err := a.GetInstances(ctx)
+		if err != nil {
+			p.setError(err.Error())
+			return
+		}
+		p.load(instanceNames)
+	}
+	for p.missing() > 0 {
+		pooledName := pooledInstancePrefix + strings.ToLower(util.RandToken(6))
+		if _, err := a.CreateInstance(ctx, pooledName, adapter.CreateOptions{}); err != nil {
+			log.Printf("fill %s pool: %v", p.adapterName, err)
+			p.setError(err.Error())
+			return
+		}
+		p.put(pooledName)
+	}
+	p.setError("")
+}
+
+func (b *broker) hasPool(adapterName string) bool {
+	return b.pools[strings.ToLower(adapterName)] != nil
+}
+
+func isPooledInstance(instanceName string) bool {
+	return strings.HasPrefix(instanceName, pooledInstancePrefix)
+}
+
+func (p *pool) take() (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.instances) == 0 {
+		p.misses++
+		return "", false
+	}
+	pooledName := p.instances[0]
+	p.instances = p.instances[1:]
+	return pooledName, true
+}
+
+func (p *pool) put(pooledName string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.instances = append(p.instances, pooledName)
+}
+
+func (p *pool) missing() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.size - len(p.instances)
+}
+
+func (p *pool) isLoaded() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.loaded
+}
+
+func (p *pool) load(instanceNames []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, instanceName := range instanceNames {
+		if isPooledInstance(instanceName) && !slices.Contains(p.instances, instanceName) {
+			p.instances = append(p.instances, instanceName)
+		}
+	}
+	p.loaded = true
+}
+
+func (p *pool) recordClaim(latency time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.claims++
+	p.claimLatency += latency
+	p.lastLatency = latency
+}
+
+func (p *pool) setError(err string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lastError = err
+}
+
+func (p *pool) triggerRefill() {
+	select {
+	case p.refill <- struct{}{}:
+	default:
+	}
+}
+
+func (p *pool) stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := PoolStats{
+		Adapter: p.adapterName,
+		Size:    p.size,
+		Fill:    len(p.instances),
+		Claims:  p.claims,
+		Misses:  p.misses,
+		Error:   p.lastError,
+	}
+	if p.claims > 0 {
+		stats.AvgClaimLatency = (p.claimLatency / time.Duration(p.claims)).String()
+		stats.LastClaimLatency = p.lastLatency.String()
+	}
+	return stats
+}
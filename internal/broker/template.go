@@ -0,0 +1,62 @@
+package broker
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/razzie-cloud/database-broker/internal/adapter"
+)
+
+func (b *broker) GetTemplates(ctx context.Context, adapterName string) ([]adapter.Template, error) {
+	tm, err := b.getTemplateManager(adapterName)
+	if err != nil {
+		return nil, err
+	}
+	return tm.GetTemplates(ctx)
+}
+
+func (b *broker) RegisterTemplate(ctx context.Context, adapterName, templateName, database string) (adapter.Template, error) {
+	templateName, err := normalizeTemplateName(templateName)
+	if err != nil {
+		return adapter.Template{}, err
+	}
+	tm, err := b.getTemplateManager(adapterName)
+	if err != nil {
+		return adapter.Template{}, err
+	}
+	template, err := tm.RegisterTemplate(ctx, templateName, database)
+	return template, wrapAdapterError(err, templateName)
+}
+
+func (b *broker) UnregisterTemplate(ctx context.Context, adapterName, templateName string) error {
+	templateName, err := normalizeTemplateName(templateName)
+	if err != nil {
+		return err
+	}
+	tm, err := b.getTemplateManager(adapterName)
+	if err != nil {
+		return err
+	}
+	return wrapAdapterError(tm.UnregisterTemplate(ctx, templateName), templateName)
+}
+
+func (b *broker) getTemplateManager(adapterName string) (adapter.TemplateManager, error) {
+	a, err := b.getAdapter(adapterName)
+	if err != nil {
+		return nil, err
+	}
+	tm, ok := a.(adapter.TemplateManager)
+	if !ok {
+		return nil, newError("templates are not supported by adapter: %s", adapterName).WithStatusCode(http.StatusUnprocessableEntity)
+	}
+	return tm, nil
+}
+
+func normalizeTemplateName(templateName string) (string, error) {
+	templateName = strings.ToLower(templateName)
+	if !validInstanceName.MatchString(templateName) {
+		return "", newError("invalid template name: %s", templateName).WithStatusCode(http.StatusUnprocessableEntity)
+	}
+	return templateName, nil
+}
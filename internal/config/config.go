@@ -1,19 +1,60 @@
 package config
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/url"
 	"os"
+	"time"
 
 	"github.com/alexflint/go-arg"
 )
 
 type Config struct {
-	ServicePort           int    `arg:"--port,env:SERVICE_PORT" default:"8080"`
-	PostgresURI           string `arg:"--postgres-uri,env:POSTGRES_URI"`
-	PostgresPasswordFile  string `arg:"--postgres-password-file,env:POSTGRES_PASSWORD_FILE"`
-	DragonflyURI          string `arg:"--dragonfly-uri,env:DRAGONFLY_URI"`
-	DragonflyPasswordFile string `arg:"--dragonfly-password-file,env:DRAGONFLY_PASSWORD_FILE"`
+	ServicePort                int            `arg:"--port,env:SERVICE_PORT" default:"8080"`
+	PostgresURI                string         `arg:"--postgres-uri,env:POSTGRES_URI"`
+	PostgresPasswordFile       string         `arg:"--postgres-password-file,env:POSTGRES_PASSWORD_FILE"`
+	PostgresSharedDB           string         `arg:"--postgres-shared-db,env:POSTGRES_SHARED_DB"`
+	PostgresSeedDir            string         `arg:"--postgres-seed-dir,env:POSTGRES_SEED_DIR"`
+	PostgresExtensions         []string       `arg:"--postgres-extensions,env:POSTGRES_EXTENSIONS"`
+	MySQLURI                   string         `arg:"--mysql-uri,env:MYSQL_URI"`
+	MySQLPasswordFile          string         `arg:"--mysql-password-file,env:MYSQL_PASSWORD_FILE"`
+	MongoDBURI                 string         `arg:"--mongodb-uri,env:MONGODB_URI"`
+	MongoDBPasswordFile        string         `arg:"--mongodb-password-file,env:MONGODB_PASSWORD_FILE"`
+	DragonflyURI               string         `arg:"--dragonfly-uri,env:DRAGONFLY_URI"`
+	DragonflyPasswordFile      string         `arg:"--dragonfly-password-file,env:DRAGONFLY_PASSWORD_FILE"`
+	DragonflyIsolation         string         `arg:"--dragonfly-isolation,env:DRAGONFLY_ISOLATION"`
+	DragonflyReconcileInterval time.Duration  `arg:"--dragonfly-reconcile-interval,env:DRAGONFLY_RECONCILE_INTERVAL" default:"5m"`
+	DragonflySeedDir           string         `arg:"--dragonfly-seed-dir,env:DRAGONFLY_SEED_DIR"`
+	RotationGracePeriod        time.Duration  `arg:"--rotation-grace-period,env:ROTATION_GRACE_PERIOD" default:"0s"`
+	AsyncWorkers               int            `arg:"--async-workers,env:ASYNC_WORKERS" default:"4"`
+	ExpiryInterval             time.Duration  `arg:"--expiry-interval,env:EXPIRY_INTERVAL" default:"1m"`
+	WarmPools                  map[string]int `arg:"--warm-pool,env:WARM_POOLS"`
+	GCInterval                 time.Duration  `arg:"--gc-interval,env:GC_INTERVAL" default:"0s"`
+	GCMinAge                   time.Duration  `arg:"--gc-min-age,env:GC_MIN_AGE" default:"1h"`
+	GCDryRun                   bool           `arg:"--gc-dry-run,env:GC_DRY_RUN"`
+	PlansFile                  string         `arg:"--plans-file,env:PLANS_FILE"`
+	Plans                      Plans          `arg:"-"`
+	Verify                     *VerifyCmd     `arg:"subcommand:verify" help:"compare the instance records with the database servers and exit"`
+}
+
+type VerifyCmd struct {
+	Repair bool `arg:"--repair" help:"restore the missing resources of instances that can be restored without data loss"`
+}
+
+// Plans holds the provisioning plans of each adapter in JSON format
+type Plans map[string]json.RawMessage
+
+// Decode decodes the plans of an adapter into v, leaving it untouched if there are none
+func (p Plans) Decode(adapterName string, v any) error {
+	data, ok := p[adapterName]
+	if !ok {
+		return nil
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("invalid %s plans: %w", adapterName, err)
+	}
+	return nil
 }
 
 func Load() (*Config, error) {
@@ -22,6 +63,11 @@ func Load() (*Config, error) {
 		return nil, fmt.Errorf("failed to load config: %w", err)
 	}
 
+	// without an age threshold, garbage collection could drop the resources of instances being created
+	if cfg.GCMinAge <= 0 {
+		return nil, fmt.Errorf("GC min age must be positive: %s", cfg.GCMinAge)
+	}
+
 	if cfg.PostgresURI != "" && cfg.PostgresPasswordFile != "" {
 		pwd, err := os.ReadFile(cfg.PostgresPasswordFile)
 		if err != nil {
@@ -35,6 +81,32 @@ func Load() (*Config, error) {
 		cfg.PostgresURI = uri.String()
 	}
 
+	if cfg.MySQLURI != "" && cfg.MySQLPasswordFile != "" {
+		pwd, err := os.ReadFile(cfg.MySQLPasswordFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read MySQL password file: %w", err)
+		}
+		uri, err := url.Parse(cfg.MySQLURI)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MySQL URI: %w", err)
+		}
+		uri.User = url.UserPassword(uri.User.Username(), string(pwd))
+		cfg.MySQLURI = uri.String()
+	}
+
+	if cfg.MongoDBURI != "" && cfg.MongoDBPasswordFile != "" {
+		pwd, err := os.ReadFile(cfg.MongoDBPasswordFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read MongoDB password file: %w", err)
+		}
+		uri, err := url.Parse(cfg.MongoDBURI)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MongoDB URI: %w", err)
+		}
+		uri.User = url.UserPassword(uri.User.Username(), string(pwd))
+		cfg.MongoDBURI = uri.String()
+	}
+
 	if cfg.DragonflyURI != "" && cfg.DragonflyPasswordFile != "" {
 		pwd, err := os.ReadFile(cfg.DragonflyPasswordFile)
 		if err != nil {
@@ -48,5 +120,15 @@ func Load() (*Config, error) {
 		cfg.DragonflyURI = uri.String()
 	}
 
+	if cfg.PlansFile != "" {
+		data, err := os.ReadFile(cfg.PlansFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read plans file: %w", err)
+		}
+		if err := json.Unmarshal(data, &cfg.Plans); err != nil {
+			return nil, fmt.Errorf("invalid plans file: %w", err)
+		}
+	}
+
 	return &cfg, nil
 }
@@ -2,15 +2,28 @@ package router
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/razzie-cloud/database-broker/internal/adapter"
 	"github.com/razzie-cloud/database-broker/internal/broker"
 
 	"github.com/go-chi/chi/v5"
 )
 
 type controller struct {
-	broker broker.Interface
+	broker              broker.Interface
+	rotationGracePeriod time.Duration
+}
+
+type requestError struct {
+	error
+}
+
+func (requestError) StatusCode() int {
+	return http.StatusBadRequest
 }
 
 func (ctrl *controller) listInstances(w http.ResponseWriter, r *http.Request) {
@@ -24,11 +37,63 @@ func (ctrl *controller) listInstances(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, instances)
 }
 
+func (ctrl *controller) createInstance(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	adapterName := chi.URLParam(r, "adapter_name")
+	var req struct {
+		Name   string          `json:"name"`
+		Plan   string          `json:"plan"`
+		Params json.RawMessage `json:"params"`
+		TTL    string          `json:"ttl"`
+		Lease  string          `json:"lease"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, requestError{fmt.Errorf("invalid request body: %w", err)})
+		return
+	}
+	ttl, err := durationParam(r, "ttl", req.TTL)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	lease, err := durationParam(r, "lease", req.Lease)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	opts := adapter.CreateOptions{
+		Plan:   req.Plan,
+		Params: req.Params,
+		TTL:    ttl,
+		Lease:  lease,
+	}
+	async, err := isAsync(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if async {
+		op, err := ctrl.broker.CreateInstanceAsync(ctx, adapterName, req.Name, opts)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeOperation(w, op)
+		return
+	}
+	instance, err := ctrl.broker.CreateInstance(ctx, adapterName, req.Name, opts)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, instance.GetJSON())
+}
+
 func (ctrl *controller) getInstance(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	adapterName := chi.URLParam(r, "adapter_name")
 	instanceName := chi.URLParam(r, "instance_name")
-	instance, err := ctrl.broker.GetOrCreateInstance(ctx, adapterName, instanceName)
+	instance, err := ctrl.broker.GetInstance(ctx, adapterName, instanceName)
 	if err != nil {
 		writeError(w, err)
 		return
@@ -40,14 +105,313 @@ func (ctrl *controller) getInstanceURI(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	adapterName := chi.URLParam(r, "adapter_name")
 	instanceName := chi.URLParam(r, "instance_name")
+	instance, err := ctrl.broker.GetInstance(ctx, adapterName, instanceName)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeURI(w, instance.GetURI())
+}
+
+func (ctrl *controller) getOrCreateInstance(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	adapterName := chi.URLParam(r, "adapter_name")
+	instanceName := chi.URLParam(r, "instance_name")
+	if ctrl.getOrCreateInstanceAsync(w, r, adapterName, instanceName) {
+		return
+	}
 	instance, err := ctrl.broker.GetOrCreateInstance(ctx, adapterName, instanceName)
 	if err != nil {
 		writeError(w, err)
 		return
 	}
-	w.Header().Set("Content-Type", "text/uri-list")
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(instance.GetURI()))
+	writeJSON(w, http.StatusOK, instance.GetJSON())
+}
+
+func (ctrl *controller) getOrCreateInstanceURI(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	adapterName := chi.URLParam(r, "adapter_name")
+	instanceName := chi.URLParam(r, "instance_name")
+	if ctrl.getOrCreateInstanceAsync(w, r, adapterName, instanceName) {
+		return
+	}
+	instance, err := ctrl.broker.GetOrCreateInstance(ctx, adapterName, instanceName)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeURI(w, instance.GetURI())
+}
+
+// getOrCreateInstanceAsync submits the request as an operation if it asks for that, and reports whether it got handled
+func (ctrl *controller) getOrCreateInstanceAsync(w http.ResponseWriter, r *http.Request, adapterName, instanceName string) bool {
+	async, err := isAsync(r)
+	if err != nil {
+		writeError(w, err)
+		return true
+	}
+	if !async {
+		return false
+	}
+	op, err := ctrl.broker.GetOrCreateInstanceAsync(r.Context(), adapterName, instanceName)
+	if err != nil {
+		writeError(w, err)
+		return true
+	}
+	writeOperation(w, op)
+	return true
+}
+
+func (ctrl *controller) deleteInstance(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	adapterName := chi.URLParam(r, "adapter_name")
+	instanceName := chi.URLParam(r, "instance_name")
+	async, err := isAsync(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if async {
+		op, err := ctrl.broker.DeleteInstanceAsync(ctx, adapterName, instanceName)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeOperation(w, op)
+		return
+	}
+	if err := ctrl.broker.DeleteInstance(ctx, adapterName, instanceName); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (ctrl *controller) rotateCredentials(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	adapterName := chi.URLParam(r, "adapter_name")
+	instanceName := chi.URLParam(r, "instance_name")
+	opts := adapter.RotateOptions{GracePeriod: ctrl.rotationGracePeriod}
+	if gracePeriod := r.URL.Query().Get("grace_period"); gracePeriod != "" {
+		d, err := time.ParseDuration(gracePeriod)
+		if err != nil || d < 0 {
+			writeError(w, requestError{fmt.Errorf("invalid grace period: %s", gracePeriod)})
+			return
+		}
+		opts.GracePeriod = d
+	}
+	instance, err := ctrl.broker.RotateCredentials(ctx, adapterName, instanceName, opts)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, instance.GetJSON())
+}
+
+func (ctrl *controller) renewLease(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	adapterName := chi.URLParam(r, "adapter_name")
+	instanceName := chi.URLParam(r, "instance_name")
+	var req struct {
+		LeaseID string `json:"lease_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, requestError{fmt.Errorf("invalid request body: %w", err)})
+		return
+	}
+	if req.LeaseID == "" {
+		writeError(w, requestError{fmt.Errorf("missing lease_id")})
+		return
+	}
+	instance, err := ctrl.broker.RenewLease(ctx, adapterName, instanceName, req.LeaseID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, instance.GetJSON())
+}
+
+func (ctrl *controller) cloneInstance(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	adapterName := chi.URLParam(r, "adapter_name")
+	instanceName := chi.URLParam(r, "instance_name")
+	cloneName := r.URL.Query().Get("to")
+	if cloneName == "" {
+		writeError(w, requestError{fmt.Errorf("missing clone name")})
+		return
+	}
+	instance, err := ctrl.broker.CloneInstance(ctx, adapterName, instanceName, cloneName)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, instance.GetJSON())
+}
+
+func (ctrl *controller) installExtensions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	adapterName := chi.URLParam(r, "adapter_name")
+	instanceName := chi.URLParam(r, "instance_name")
+	var req struct {
+		Extensions []string `json:"extensions"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, requestError{fmt.Errorf("invalid request body: %w", err)})
+		return
+	}
+	if len(req.Extensions) == 0 {
+		writeError(w, requestError{fmt.Errorf("missing extensions")})
+		return
+	}
+	instance, err := ctrl.broker.InstallExtensions(ctx, adapterName, instanceName, req.Extensions)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, instance.GetJSON())
+}
+
+func (ctrl *controller) resetInstance(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	adapterName := chi.URLParam(r, "adapter_name")
+	instanceName := chi.URLParam(r, "instance_name")
+	instance, err := ctrl.broker.ResetInstance(ctx, adapterName, instanceName)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, instance.GetJSON())
+}
+
+func (ctrl *controller) getOperation(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	operationID := chi.URLParam(r, "operation_id")
+	op, err := ctrl.broker.GetOperation(ctx, operationID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, op)
+}
+
+func (ctrl *controller) detectDrift(w http.ResponseWriter, r *http.Request) {
+	reports, err := ctrl.broker.DetectDrift(r.Context(), false)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, reports)
+}
+
+func (ctrl *controller) repairDrift(w http.ResponseWriter, r *http.Request) {
+	reports, err := ctrl.broker.DetectDrift(r.Context(), true)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, reports)
+}
+
+func (ctrl *controller) collectGarbage(w http.ResponseWriter, r *http.Request) {
+	dryRun, err := boolQuery(r, "dry_run")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	reports, err := ctrl.broker.CollectGarbage(r.Context(), dryRun)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, reports)
+}
+
+// durationParam parses an optional positive duration from the query parameter, or else from the request body field
+func durationParam(r *http.Request, name, bodyValue string) (time.Duration, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		value = bodyValue
+	}
+	if value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, requestError{fmt.Errorf("invalid %s: %s", name, value)}
+	}
+	return d, nil
+}
+
+func (ctrl *controller) getPoolStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, ctrl.broker.GetPoolStats(r.Context()))
+}
+
+func (ctrl *controller) listTemplates(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	adapterName := chi.URLParam(r, "adapter_name")
+	templates, err := ctrl.broker.GetTemplates(ctx, adapterName)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, templates)
+}
+
+func (ctrl *controller) registerTemplate(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	adapterName := chi.URLParam(r, "adapter_name")
+	var req struct {
+		Name     string `json:"name"`
+		Database string `json:"database"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, requestError{fmt.Errorf("invalid request body: %w", err)})
+		return
+	}
+	if req.Database == "" {
+		writeError(w, requestError{fmt.Errorf("missing database")})
+		return
+	}
+	template, err := ctrl.broker.RegisterTemplate(ctx, adapterName, req.Name, req.Database)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, template)
+}
+
+func (ctrl *controller) unregisterTemplate(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	adapterName := chi.URLParam(r, "adapter_name")
+	templateName := chi.URLParam(r, "template_name")
+	if err := ctrl.broker.UnregisterTemplate(ctx, adapterName, templateName); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// isAsync reports whether the request asks to be processed in the background with the async query parameter
+func isAsync(r *http.Request) (bool, error) {
+	return boolQuery(r, "async")
+}
+
+// boolQuery parses an optional boolean query parameter, which is false if missing
+func boolQuery(r *http.Request, name string) (bool, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, requestError{fmt.Errorf("invalid %s: %s", name, value)}
+	}
+	return b, nil
+}
+
+func writeOperation(w http.ResponseWriter, op broker.Operation) {
+	w.Header().Set("Location", "/v1/operations/"+op.ID)
+	writeJSON(w, http.StatusAccepted, op)
 }
 
 func writeJSON(w http.ResponseWriter, code int, v any) {
@@ -56,6 +420,12 @@ func writeJSON(w http.ResponseWriter, code int, v any) {
 	_ = json.NewEncoder(w).Encode(v)
 }
 
+func writeURI(w http.ResponseWriter, uri string) {
+	w.Header().Set("Content-Type", "text/uri-list")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(uri))
+}
+
 func writeError(w http.ResponseWriter, err error) {
 	code := http.StatusInternalServerError
 	if errWithCode, ok := err.(interface{ StatusCode() int }); ok {
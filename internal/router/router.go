@@ -2,6 +2,7 @@ package router
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/razzie-cloud/database-broker/internal/broker"
 
@@ -9,15 +10,45 @@ import (
 	"github.com/go-chi/chi/v5/middleware"
 )
 
-func New(broker broker.Interface) http.Handler {
+type Option func(ctrl *controller)
+
+// WithRotationGracePeriod sets how long the previous credential stays valid after a rotation
+// when the request doesn't specify it
+func WithRotationGracePeriod(gracePeriod time.Duration) Option {
+	return func(ctrl *controller) {
+		ctrl.rotationGracePeriod = gracePeriod
+	}
+}
+
+func New(broker broker.Interface, opts ...Option) http.Handler {
 	ctrl := &controller{broker: broker}
+	for _, opt := range opts {
+		opt(ctrl)
+	}
 	r := chi.NewRouter()
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 	r.Route("/v1", func(r chi.Router) {
 		r.Get("/instances/{adapter_name}", ctrl.listInstances)
+		r.Post("/instances/{adapter_name}", ctrl.createInstance)
 		r.Get("/instances/{adapter_name}/{instance_name}", ctrl.getInstance)
+		r.Put("/instances/{adapter_name}/{instance_name}", ctrl.getOrCreateInstance)
+		r.Delete("/instances/{adapter_name}/{instance_name}", ctrl.deleteInstance)
 		r.Get("/instances/{adapter_name}/{instance_name}/uri", ctrl.getInstanceURI)
+		r.Put("/instances/{adapter_name}/{instance_name}/uri", ctrl.getOrCreateInstanceURI)
+		r.Post("/instances/{adapter_name}/{instance_name}/rotate", ctrl.rotateCredentials)
+		r.Post("/instances/{adapter_name}/{instance_name}/lease", ctrl.renewLease)
+		r.Post("/instances/{adapter_name}/{instance_name}/clone", ctrl.cloneInstance)
+		r.Post("/instances/{adapter_name}/{instance_name}/extensions", ctrl.installExtensions)
+		r.Post("/instances/{adapter_name}/{instance_name}/reset", ctrl.resetInstance)
+		r.Get("/operations/{operation_id}", ctrl.getOperation)
+		r.Get("/admin/drift", ctrl.detectDrift)
+		r.Post("/admin/drift/repair", ctrl.repairDrift)
+		r.Post("/admin/gc", ctrl.collectGarbage)
+		r.Get("/admin/pools", ctrl.getPoolStats)
+		r.Get("/admin/templates/{adapter_name}", ctrl.listTemplates)
+		r.Post("/admin/templates/{adapter_name}", ctrl.registerTemplate)
+		r.Delete("/admin/templates/{adapter_name}/{template_name}", ctrl.unregisterTemplate)
 	})
 	return r
 }
@@ -1,9 +1,12 @@
 package router
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/razzie-cloud/database-broker/internal/adapter"
 	"github.com/razzie-cloud/database-broker/internal/broker"
@@ -26,11 +29,172 @@ func TestRouter_ListInstances(t *testing.T) {
 	bmock.AssertExpectations(t)
 }
 
+func TestRouter_CreateInstance(t *testing.T) {
+	i, imock := mock.Mock[adapter.Instance]()
+	imock.On("GetJSON").Return(map[string]string{"instance": "instance1"})
+	b, bmock := mock.Mock[broker.Interface]()
+	opts := adapter.CreateOptions{Plan: "small", Params: json.RawMessage(`{"encoding":"UTF8"}`)}
+	bmock.On("CreateInstance", mock.Anything, "test", "instance1", opts).Return(i, nil)
+
+	h := New(b)
+	body := strings.NewReader(`{"name":"instance1","plan":"small","params":{"encoding":"UTF8"}}`)
+	req := httptest.NewRequest("POST", "/v1/instances/test", body)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Contains(t, w.Body.String(), "instance1")
+	imock.AssertExpectations(t)
+	bmock.AssertExpectations(t)
+}
+
+func TestRouter_RenewLease(t *testing.T) {
+	i, imock := mock.Mock[adapter.Instance]()
+	imock.On("GetJSON").Return(map[string]string{"instance": "instance1"})
+	b, bmock := mock.Mock[broker.Interface]()
+	bmock.On("RenewLease", mock.Anything, "test", "instance1", "abcd").Return(i, nil)
+
+	h := New(b)
+	req := httptest.NewRequest("POST", "/v1/instances/test/instance1/lease", strings.NewReader(`{"lease_id":"abcd"}`))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "instance1")
+	bmock.AssertExpectations(t)
+}
+
+func TestRouter_RenewLease_MissingID(t *testing.T) {
+	b, bmock := mock.Mock[broker.Interface]()
+
+	h := New(b)
+	req := httptest.NewRequest("POST", "/v1/instances/test/instance1/lease", strings.NewReader(`{}`))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	bmock.AssertExpectations(t)
+}
+
+func TestRouter_CloneInstance(t *testing.T) {
+	i, imock := mock.Mock[adapter.Instance]()
+	imock.On("GetJSON").Return(map[string]string{"instance": "instance2"})
+	b, bmock := mock.Mock[broker.Interface]()
+	bmock.On("CloneInstance", mock.Anything, "test", "instance1", "instance2").Return(i, nil)
+
+	h := New(b)
+	req := httptest.NewRequest("POST", "/v1/instances/test/instance1/clone?to=instance2", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Contains(t, w.Body.String(), "instance2")
+	bmock.AssertExpectations(t)
+}
+
+func TestRouter_CloneInstance_MissingName(t *testing.T) {
+	b, bmock := mock.Mock[broker.Interface]()
+
+	h := New(b)
+	req := httptest.NewRequest("POST", "/v1/instances/test/instance1/clone", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	bmock.AssertExpectations(t)
+}
+
+func TestRouter_InstallExtensions(t *testing.T) {
+	i, imock := mock.Mock[adapter.Instance]()
+	imock.On("GetJSON").Return(map[string]any{"extensions": []string{"pgcrypto"}})
+	b, bmock := mock.Mock[broker.Interface]()
+	bmock.On("InstallExtensions", mock.Anything, "test", "instance1", []string{"pgcrypto"}).Return(i, nil)
+
+	h := New(b)
+	req := httptest.NewRequest("POST", "/v1/instances/test/instance1/extensions", strings.NewReader(`{"extensions":["pgcrypto"]}`))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "pgcrypto")
+	bmock.AssertExpectations(t)
+}
+
+func TestRouter_InstallExtensions_Missing(t *testing.T) {
+	b, bmock := mock.Mock[broker.Interface]()
+
+	h := New(b)
+	req := httptest.NewRequest("POST", "/v1/instances/test/instance1/extensions", strings.NewReader(`{"extensions":[]}`))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	bmock.AssertExpectations(t)
+}
+
+func TestRouter_ResetInstance(t *testing.T) {
+	i, imock := mock.Mock[adapter.Instance]()
+	imock.On("GetJSON").Return(map[string]string{"instance": "instance1"})
+	b, bmock := mock.Mock[broker.Interface]()
+	bmock.On("ResetInstance", mock.Anything, "test", "instance1").Return(i, nil)
+
+	h := New(b)
+	req := httptest.NewRequest("POST", "/v1/instances/test/instance1/reset", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "instance1")
+	bmock.AssertExpectations(t)
+}
+
+func TestRouter_CreateInstance_TTL(t *testing.T) {
+	i, imock := mock.Mock[adapter.Instance]()
+	imock.On("GetJSON").Return(map[string]string{"instance": "instance1"})
+	b, bmock := mock.Mock[broker.Interface]()
+	bmock.On("CreateInstance", mock.Anything, "test", "instance1", adapter.CreateOptions{TTL: 24 * time.Hour}).Return(i, nil)
+	bmock.On("CreateInstance", mock.Anything, "test", "instance2", adapter.CreateOptions{TTL: 30 * time.Minute}).Return(i, nil)
+	bmock.On("CreateInstance", mock.Anything, "test", "instance3", adapter.CreateOptions{Lease: 5 * time.Minute}).Return(i, nil)
+
+	h := New(b)
+	req := httptest.NewRequest("POST", "/v1/instances/test?ttl=24h", strings.NewReader(`{"name":"instance1"}`))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	req = httptest.NewRequest("POST", "/v1/instances/test", strings.NewReader(`{"name":"instance2","ttl":"30m"}`))
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	req = httptest.NewRequest("POST", "/v1/instances/test?lease=5m", strings.NewReader(`{"name":"instance3"}`))
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	bmock.AssertExpectations(t)
+}
+
+func TestRouter_CreateInstance_InvalidTTL(t *testing.T) {
+	b, bmock := mock.Mock[broker.Interface]()
+
+	h := New(b)
+	req := httptest.NewRequest("POST", "/v1/instances/test?ttl=-1h", strings.NewReader(`{"name":"instance1"}`))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid ttl")
+	bmock.AssertExpectations(t)
+}
+
+func TestRouter_CreateInstance_InvalidBody(t *testing.T) {
+	b, bmock := mock.Mock[broker.Interface]()
+
+	h := New(b)
+	req := httptest.NewRequest("POST", "/v1/instances/test", strings.NewReader(`{"name":`))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid request body")
+	bmock.AssertExpectations(t)
+}
+
 func TestRouter_GetInstance(t *testing.T) {
 	i, imock := mock.Mock[adapter.Instance]()
 	imock.On("GetJSON").Return(map[string]string{"instance": "instance1"})
 	b, bmock := mock.Mock[broker.Interface]()
-	bmock.On("GetOrCreateInstance", mock.Anything, "test", "instance1").Return(i, nil)
+	bmock.On("GetInstance", mock.Anything, "test", "instance1").Return(i, nil)
 
 	h := New(b)
 	req := httptest.NewRequest("GET", "/v1/instances/test/instance1", nil)
@@ -46,7 +210,7 @@ func TestRouter_GetInstanceURI(t *testing.T) {
 	i, imock := mock.Mock[adapter.Instance]()
 	imock.On("GetURI").Return("mock://uri")
 	b, bmock := mock.Mock[broker.Interface]()
-	bmock.On("GetOrCreateInstance", mock.Anything, "test", "instance1").Return(i, nil)
+	bmock.On("GetInstance", mock.Anything, "test", "instance1").Return(i, nil)
 
 	h := New(b)
 	req := httptest.NewRequest("GET", "/v1/instances/test/instance1/uri", nil)
@@ -57,3 +221,286 @@ func TestRouter_GetInstanceURI(t *testing.T) {
 	imock.AssertExpectations(t)
 	bmock.AssertExpectations(t)
 }
+
+func TestRouter_GetInstance_NotFound(t *testing.T) {
+	b, bmock := mock.Mock[broker.Interface]()
+	bmock.On("GetInstance", mock.Anything, "test", "missing").Return(nil, notFoundError{})
+
+	h := New(b)
+	req := httptest.NewRequest("GET", "/v1/instances/test/missing", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	bmock.AssertExpectations(t)
+}
+
+func TestRouter_GetOrCreateInstance(t *testing.T) {
+	i, imock := mock.Mock[adapter.Instance]()
+	imock.On("GetJSON").Return(map[string]string{"instance": "instance1"})
+	b, bmock := mock.Mock[broker.Interface]()
+	bmock.On("GetOrCreateInstance", mock.Anything, "test", "instance1").Return(i, nil)
+
+	h := New(b)
+	req := httptest.NewRequest("PUT", "/v1/instances/test/instance1", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "instance1")
+	imock.AssertExpectations(t)
+	bmock.AssertExpectations(t)
+}
+
+func TestRouter_GetOrCreateInstanceURI(t *testing.T) {
+	i, imock := mock.Mock[adapter.Instance]()
+	imock.On("GetURI").Return("mock://uri")
+	b, bmock := mock.Mock[broker.Interface]()
+	bmock.On("GetOrCreateInstance", mock.Anything, "test", "instance1").Return(i, nil)
+
+	h := New(b)
+	req := httptest.NewRequest("PUT", "/v1/instances/test/instance1/uri", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "mock://uri", w.Body.String())
+	imock.AssertExpectations(t)
+	bmock.AssertExpectations(t)
+}
+
+func TestRouter_DeleteInstance(t *testing.T) {
+	b, bmock := mock.Mock[broker.Interface]()
+	bmock.On("DeleteInstance", mock.Anything, "test", "instance1").Return(nil)
+
+	h := New(b)
+	req := httptest.NewRequest("DELETE", "/v1/instances/test/instance1", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Empty(t, w.Body.String())
+	bmock.AssertExpectations(t)
+}
+
+func TestRouter_RotateCredentials(t *testing.T) {
+	i, imock := mock.Mock[adapter.Instance]()
+	imock.On("GetJSON").Return(map[string]string{"password": "newpass"})
+	b, bmock := mock.Mock[broker.Interface]()
+	bmock.On("RotateCredentials", mock.Anything, "test", "instance1", adapter.RotateOptions{GracePeriod: time.Minute}).Return(i, nil)
+
+	h := New(b, WithRotationGracePeriod(time.Minute))
+	req := httptest.NewRequest("POST", "/v1/instances/test/instance1/rotate", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "newpass")
+	imock.AssertExpectations(t)
+	bmock.AssertExpectations(t)
+}
+
+func TestRouter_RotateCredentials_GracePeriod(t *testing.T) {
+	i, imock := mock.Mock[adapter.Instance]()
+	imock.On("GetJSON").Return(map[string]string{"password": "newpass"})
+	b, bmock := mock.Mock[broker.Interface]()
+	bmock.On("RotateCredentials", mock.Anything, "test", "instance1", adapter.RotateOptions{GracePeriod: time.Hour}).Return(i, nil)
+
+	h := New(b, WithRotationGracePeriod(time.Minute))
+	req := httptest.NewRequest("POST", "/v1/instances/test/instance1/rotate?grace_period=1h", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	imock.AssertExpectations(t)
+	bmock.AssertExpectations(t)
+}
+
+func TestRouter_RotateCredentials_InvalidGracePeriod(t *testing.T) {
+	b, bmock := mock.Mock[broker.Interface]()
+
+	h := New(b)
+	req := httptest.NewRequest("POST", "/v1/instances/test/instance1/rotate?grace_period=soon", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid grace period")
+	bmock.AssertExpectations(t)
+}
+
+func TestRouter_CreateInstance_Async(t *testing.T) {
+	b, bmock := mock.Mock[broker.Interface]()
+	opts := adapter.CreateOptions{Plan: "small"}
+	op := broker.Operation{ID: "op1", Type: broker.OperationCreate, Status: broker.OperationPending}
+	bmock.On("CreateInstanceAsync", mock.Anything, "test", "instance1", opts).Return(op, nil)
+
+	h := New(b)
+	body := strings.NewReader(`{"name":"instance1","plan":"small"}`)
+	req := httptest.NewRequest("POST", "/v1/instances/test?async=true", body)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, "/v1/operations/op1", w.Header().Get("Location"))
+	assert.Contains(t, w.Body.String(), `"status":"pending"`)
+	bmock.AssertExpectations(t)
+}
+
+func TestRouter_CreateInstance_InvalidAsync(t *testing.T) {
+	b, bmock := mock.Mock[broker.Interface]()
+
+	h := New(b)
+	body := strings.NewReader(`{"name":"instance1"}`)
+	req := httptest.NewRequest("POST", "/v1/instances/test?async=maybe", body)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid async")
+	bmock.AssertExpectations(t)
+}
+
+func TestRouter_GetOrCreateInstance_Async(t *testing.T) {
+	b, bmock := mock.Mock[broker.Interface]()
+	op := broker.Operation{ID: "op1", Type: broker.OperationCreate, Status: broker.OperationPending}
+	bmock.On("GetOrCreateInstanceAsync", mock.Anything, "test", "instance1").Return(op, nil)
+
+	h := New(b)
+	for _, path := range []string{"/v1/instances/test/instance1?async=true", "/v1/instances/test/instance1/uri?async=true"} {
+		req := httptest.NewRequest("PUT", path, nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		assert.Equal(t, "/v1/operations/op1", w.Header().Get("Location"))
+	}
+	bmock.AssertExpectations(t)
+}
+
+func TestRouter_DeleteInstance_Async(t *testing.T) {
+	b, bmock := mock.Mock[broker.Interface]()
+	op := broker.Operation{ID: "op1", Type: broker.OperationDelete, Status: broker.OperationPending}
+	bmock.On("DeleteInstanceAsync", mock.Anything, "test", "instance1").Return(op, nil)
+
+	h := New(b)
+	req := httptest.NewRequest("DELETE", "/v1/instances/test/instance1?async=true", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, "/v1/operations/op1", w.Header().Get("Location"))
+	bmock.AssertExpectations(t)
+}
+
+func TestRouter_GetOperation(t *testing.T) {
+	b, bmock := mock.Mock[broker.Interface]()
+	op := broker.Operation{ID: "op1", Type: broker.OperationCreate, Status: broker.OperationFailed, Error: "instance already exists: instance1"}
+	bmock.On("GetOperation", mock.Anything, "op1").Return(op, nil)
+
+	h := New(b)
+	req := httptest.NewRequest("GET", "/v1/operations/op1", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"status":"failed"`)
+	assert.Contains(t, w.Body.String(), "instance already exists")
+	bmock.AssertExpectations(t)
+}
+
+func TestRouter_DetectDrift(t *testing.T) {
+	b, bmock := mock.Mock[broker.Interface]()
+	reports := map[string]*adapter.DriftReport{"test": {OrphanedUsers: []string{"user_foo_abcd"}}}
+	bmock.On("DetectDrift", mock.Anything, mock.Anything).Return(reports, nil)
+
+	h := New(b)
+	req := httptest.NewRequest("GET", "/v1/admin/drift", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "user_foo_abcd")
+	bmock.AssertExpectations(t)
+}
+
+func TestRouter_RepairDrift(t *testing.T) {
+	b, bmock := mock.Mock[broker.Interface]()
+	reports := map[string]*adapter.DriftReport{"test": {Repaired: []string{"foo"}}}
+	bmock.On("DetectDrift", mock.Anything, true).Return(reports, nil)
+
+	h := New(b)
+	req := httptest.NewRequest("POST", "/v1/admin/drift/repair", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"repaired":["foo"]`)
+	bmock.AssertExpectations(t)
+}
+
+func TestRouter_CollectGarbage(t *testing.T) {
+	b, bmock := mock.Mock[broker.Interface]()
+	reports := map[string]*broker.GCReport{"test": {DryRun: true, Users: []string{"user_foo_abcd"}}}
+	bmock.On("CollectGarbage", mock.Anything, true).Return(reports, nil)
+
+	h := New(b)
+	req := httptest.NewRequest("POST", "/v1/admin/gc?dry_run=true", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"users":["user_foo_abcd"]`)
+	bmock.AssertExpectations(t)
+}
+
+func TestRouter_CollectGarbage_InvalidDryRun(t *testing.T) {
+	b, _ := mock.Mock[broker.Interface]()
+
+	h := New(b)
+	req := httptest.NewRequest("POST", "/v1/admin/gc?dry_run=maybe", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestRouter_GetPoolStats(t *testing.T) {
+	b, bmock := mock.Mock[broker.Interface]()
+	stats := []broker.PoolStats{{Adapter: "test", Size: 3, Fill: 2, Claims: 1, AvgClaimLatency: "15ms"}}
+	bmock.On("GetPoolStats", mock.Anything).Return(stats)
+
+	h := New(b)
+	req := httptest.NewRequest("GET", "/v1/admin/pools", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"fill":2`)
+	bmock.AssertExpectations(t)
+}
+
+func TestRouter_RegisterTemplate(t *testing.T) {
+	b, bmock := mock.Mock[broker.Interface]()
+	template := adapter.Template{Name: "base", Database: "base_template"}
+	bmock.On("RegisterTemplate", mock.Anything, "test", "base", "base_template").Return(template, nil)
+
+	h := New(b)
+	req := httptest.NewRequest("POST", "/v1/admin/templates/test", strings.NewReader(`{"name":"base","database":"base_template"}`))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Contains(t, w.Body.String(), `"database":"base_template"`)
+	bmock.AssertExpectations(t)
+}
+
+func TestRouter_RegisterTemplate_MissingDatabase(t *testing.T) {
+	b, bmock := mock.Mock[broker.Interface]()
+
+	h := New(b)
+	req := httptest.NewRequest("POST", "/v1/admin/templates/test", strings.NewReader(`{"name":"base"}`))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	bmock.AssertExpectations(t)
+}
+
+func TestRouter_UnregisterTemplate(t *testing.T) {
+	b, bmock := mock.Mock[broker.Interface]()
+	bmock.On("UnregisterTemplate", mock.Anything, "test", "base").Return(notFoundError{})
+
+	h := New(b)
+	req := httptest.NewRequest("DELETE", "/v1/admin/templates/test/base", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	bmock.AssertExpectations(t)
+}
+
+type notFoundError struct{}
+
+func (notFoundError) Error() string   { return "not found" }
+func (notFoundError) StatusCode() int { return http.StatusNotFound }